@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 
 	"github.com/edwinjordan/erp_golang/internal/config"
 	"github.com/edwinjordan/erp_golang/internal/database"
 	"github.com/edwinjordan/erp_golang/internal/handlers"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/jobs"
 	"github.com/edwinjordan/erp_golang/internal/middleware"
 	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/internal/throttle"
+	"github.com/edwinjordan/erp_golang/pkg/mailer"
+	"github.com/edwinjordan/erp_golang/pkg/money"
+	"github.com/edwinjordan/erp_golang/pkg/notify"
+	"github.com/edwinjordan/erp_golang/pkg/oidc"
 	"github.com/edwinjordan/erp_golang/pkg/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -16,78 +25,356 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	// Initialize JWT
-	utils.InitJWT(cfg.JWTSecret)
+	if err := initJWT(cfg); err != nil {
+		log.Fatalf("Failed to initialize JWT: %v", err)
+	}
+	utils.SetAccessTokenTTL(cfg.AccessTokenTTL)
+	utils.SetRevocationCheck(middleware.IsTokenRevoked)
+
+	currency, err := cfg.MoneyCurrency()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	money.SetDefaultCurrency(currency)
+
+	// Initialize handlers
+	handlers.Init(cfg)
+	handlers.SetMailer(newMailer(cfg))
+
+	var bannedPasswords []string
+	if cfg.PasswordBannedFile != "" {
+		if bannedPasswords, err = utils.LoadBannedPasswords(cfg.PasswordBannedFile); err != nil {
+			log.Fatalf("Failed to load banned passwords: %v", err)
+		}
+	}
+	handlers.SetPasswordPolicy(utils.NewPasswordPolicy(cfg.PasswordMinLength, bannedPasswords))
+
+	if cfg.OIDCEnabled {
+		handlers.SetOIDCProvider(oidc.NewProvider(oidc.Config{
+			IssuerURL:    cfg.OIDCIssuerURL,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+		}))
+	}
 
 	// Connect to database
 	if err := database.Connect(cfg); err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
+	// Convert existing data before AutoMigrate touches the columns
+	if err := database.Migrate(database.DB, database.Migrations); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
 	// Auto migrate database
 	if err := database.DB.AutoMigrate(
 		&models.User{},
+		&models.UserToken{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.RecoveryCode{},
+		&models.APIKey{},
+		&models.OIDCAuthRequest{},
+		&models.LoginThrottle{},
+		&models.LoginAttempt{},
 		&models.Role{},
 		&models.Permission{},
 		&models.Category{},
 		&models.Unit{},
+		&models.Warehouse{},
+		&models.Terminal{},
 		&models.Product{},
+		&models.ProductBarcode{},
+		&models.ProductUnit{},
+		&models.StockLevel{},
+		&models.LowStockAlert{},
+		&models.Lot{},
+		&models.LotLevel{},
+		&models.SerialNumber{},
+		&models.SerialEvent{},
+		&models.StockMovement{},
+		&models.Transfer{},
+		&models.TransferLine{},
+		&models.TransferLineSerial{},
+		&models.StockAdjustment{},
+		&models.StockAdjustmentLine{},
+		&models.StockAdjustmentSerial{},
+		&models.CycleCount{},
+		&models.CycleCountLine{},
+		&models.CycleCountSerial{},
+		&models.StockReservation{},
+		&models.StockReservationLine{},
 		&models.Sale{},
 		&models.SaleItem{},
+		&models.SaleItemLot{},
+		&models.SaleItemSerial{},
 	); err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
+	// Backfill data that needs the migrated schema
+	if err := database.Migrate(database.DB, database.DataMigrations); err != nil {
+		log.Fatalf("Failed to run data migrations: %v", err)
+	}
+
 	// Seed initial data
 	seedData()
 
+	// Throttle failed logins; the limiters need the database for the db store
+	userLimiter, ipLimiter := newLoginLimiters(cfg)
+	handlers.SetLoginLimiters(userLimiter, ipLimiter)
+
+	// Check stock against reorder points in the background
+	if cfg.LowStockCheckInterval > 0 {
+		notifier := newNotifier(cfg)
+		policy := inventory.ReorderPolicy{VelocityDays: cfg.ReorderVelocityDays, CoverDays: cfg.ReorderCoverDays}
+		go jobs.Every(context.Background(), "low stock alerts", cfg.LowStockCheckInterval, func(ctx context.Context) error {
+			return inventory.RaiseLowStockAlerts(ctx, database.DB, policy, notifier)
+		})
+	}
+
+	// Release reservations past their expiry
+	if cfg.ReservationSweepInterval > 0 {
+		go jobs.Every(context.Background(), "reservation sweeper", cfg.ReservationSweepInterval, func(ctx context.Context) error {
+			released, err := inventory.ReleaseExpired(ctx, database.DB)
+			if released > 0 {
+				log.Printf("Released %d expired stock reservations", released)
+			}
+			return err
+		})
+	}
+
 	// Setup router
-	router := gin.Default()
+	router, err := newRouter(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up router: %v", err)
+	}
 
 	// Public routes
+	router.GET("/.well-known/jwks.json", handlers.JWKS)
 	router.POST("/register", handlers.Register)
 	router.POST("/login", handlers.Login)
+	router.POST("/login/mfa", handlers.LoginMFA)
+	router.POST("/login/mfa/enroll", handlers.LoginMFAEnroll)
+	router.POST("/login/mfa/enroll/confirm", handlers.LoginMFAEnrollConfirm)
+	router.GET("/auth/oidc/login", handlers.OIDCLogin)
+	router.GET("/auth/oidc/callback", handlers.OIDCCallback)
+	router.POST("/refresh", handlers.Refresh)
+	router.POST("/logout", middleware.AuthMiddleware(), middleware.SessionOnly(), handlers.Logout)
+	router.POST("/logout/all", middleware.AuthMiddleware(), middleware.SessionOnly(), handlers.LogoutAll)
+	router.POST("/invitations/accept", handlers.AcceptInvite)
+	router.POST("/password/forgot", handlers.ForgotPassword)
+	router.POST("/password/reset", handlers.ResetPassword)
 
 	// Protected routes
 	api := router.Group("/api")
 	api.Use(middleware.AuthMiddleware())
 	{
+		// Self-service routes for the current user
+		account := api.Group("/account", middleware.SessionOnly())
+		{
+			account.GET("", handlers.GetAccount)
+			account.POST("/password", handlers.ChangePassword)
+			account.POST("/2fa/setup", handlers.SetupTOTP)
+			account.POST("/2fa/confirm", handlers.ConfirmTOTP)
+			account.POST("/2fa/disable", handlers.DisableTOTP)
+			account.POST("/2fa/recovery-codes", handlers.RegenerateRecoveryCodes)
+			account.POST("/oidc/link", handlers.OIDCLink)
+		}
+
 		// Category routes
 		categories := api.Group("/categories")
 		{
-			categories.GET("", handlers.GetCategories)
-			categories.GET("/:id", handlers.GetCategory)
-			categories.POST("", handlers.CreateCategory)
-			categories.PUT("/:id", handlers.UpdateCategory)
-			categories.DELETE("/:id", handlers.DeleteCategory)
+			categories.GET("", middleware.RBACMiddleware(models.PermCategoriesRead), handlers.GetCategories)
+			categories.GET("/:id", middleware.RBACMiddleware(models.PermCategoriesRead), handlers.GetCategory)
+			categories.POST("", middleware.RBACMiddleware(models.PermCategoriesWrite), handlers.CreateCategory)
+			categories.PUT("/:id", middleware.RBACMiddleware(models.PermCategoriesWrite), handlers.UpdateCategory)
+			categories.DELETE("/:id", middleware.RBACMiddleware(models.PermCategoriesWrite), handlers.DeleteCategory)
 		}
 
 		// Unit routes
 		units := api.Group("/units")
 		{
-			units.GET("", handlers.GetUnits)
-			units.GET("/:id", handlers.GetUnit)
-			units.POST("", handlers.CreateUnit)
-			units.PUT("/:id", handlers.UpdateUnit)
-			units.DELETE("/:id", handlers.DeleteUnit)
+			units.GET("", middleware.RBACMiddleware(models.PermUnitsRead), handlers.GetUnits)
+			units.GET("/:id", middleware.RBACMiddleware(models.PermUnitsRead), handlers.GetUnit)
+			units.POST("", middleware.RBACMiddleware(models.PermUnitsWrite), handlers.CreateUnit)
+			units.PUT("/:id", middleware.RBACMiddleware(models.PermUnitsWrite), handlers.UpdateUnit)
+			units.DELETE("/:id", middleware.RBACMiddleware(models.PermUnitsWrite), handlers.DeleteUnit)
 		}
 
 		// Product routes
 		products := api.Group("/products")
 		{
-			products.GET("", handlers.GetProducts)
-			products.GET("/:id", handlers.GetProduct)
-			products.POST("", handlers.CreateProduct)
-			products.PUT("/:id", handlers.UpdateProduct)
-			products.DELETE("/:id", handlers.DeleteProduct)
+			products.GET("", middleware.RBACMiddleware(models.PermProductsRead), handlers.GetProducts)
+			products.GET("/search", middleware.RBACMiddleware(models.PermProductsRead), handlers.SearchProducts)
+			products.GET("/by-barcode/:code", middleware.RBACMiddleware(models.PermProductsRead), handlers.GetProductByBarcode)
+			products.GET("/:id", middleware.RBACMiddleware(models.PermProductsRead), handlers.GetProduct)
+			products.POST("", middleware.RBACMiddleware(models.PermProductsWrite), handlers.CreateProduct)
+			products.PUT("/:id", middleware.RBACMiddleware(models.PermProductsWrite), handlers.UpdateProduct)
+			products.DELETE("/:id", middleware.RBACMiddleware(models.PermProductsWrite), handlers.DeleteProduct)
+			products.GET("/:id/movements", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetProductMovements)
+			products.POST("/:id/barcode", middleware.RBACMiddleware(models.PermProductsWrite), handlers.GenerateBarcode)
+			products.POST("/:id/variants", middleware.RBACMiddleware(models.PermProductsWrite), handlers.GenerateVariants)
+			products.PUT("/:id/stock-levels/:warehouseId", middleware.RBACMiddleware(models.PermProductsWrite), handlers.UpdateStockLevelReorder)
+		}
+
+		// Stock adjustment routes
+		adjustments := api.Group("/adjustments")
+		{
+			adjustments.GET("", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetAdjustments)
+			adjustments.GET("/:id", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetAdjustment)
+			adjustments.POST("", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.CreateAdjustment)
+			adjustments.POST("/:id/post", middleware.RBACMiddleware(models.PermInventoryAdjust), handlers.PostAdjustment)
+			adjustments.POST("/:id/cancel", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.CancelAdjustment)
+		}
+
+		// Cycle count routes
+		cycleCounts := api.Group("/cycle-counts")
+		{
+			cycleCounts.GET("", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetCycleCounts)
+			cycleCounts.GET("/:id", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetCycleCount)
+			cycleCounts.POST("", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.CreateCycleCount)
+			cycleCounts.PUT("/:id/counts", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.RecordCounts)
+			cycleCounts.POST("/:id/approve", middleware.RBACMiddleware(models.PermInventoryAdjust), handlers.ApproveCycleCount)
+			cycleCounts.POST("/:id/cancel", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.CancelCycleCount)
+		}
+
+		// Warehouse routes
+		warehouses := api.Group("/warehouses")
+		{
+			warehouses.GET("", middleware.RBACMiddleware(models.PermWarehousesRead), handlers.GetWarehouses)
+			warehouses.GET("/:id", middleware.RBACMiddleware(models.PermWarehousesRead), handlers.GetWarehouse)
+			warehouses.POST("", middleware.RBACMiddleware(models.PermWarehousesWrite), handlers.CreateWarehouse)
+			warehouses.PUT("/:id", middleware.RBACMiddleware(models.PermWarehousesWrite), handlers.UpdateWarehouse)
+			warehouses.DELETE("/:id", middleware.RBACMiddleware(models.PermWarehousesWrite), handlers.DeleteWarehouse)
+		}
+
+		// Terminal routes
+		terminals := api.Group("/terminals")
+		{
+			terminals.GET("", middleware.RBACMiddleware(models.PermWarehousesRead), handlers.GetTerminals)
+			terminals.GET("/:id", middleware.RBACMiddleware(models.PermWarehousesRead), handlers.GetTerminal)
+			terminals.POST("", middleware.RBACMiddleware(models.PermWarehousesWrite), handlers.CreateTerminal)
+			terminals.PUT("/:id", middleware.RBACMiddleware(models.PermWarehousesWrite), handlers.UpdateTerminal)
+			terminals.DELETE("/:id", middleware.RBACMiddleware(models.PermWarehousesWrite), handlers.DeleteTerminal)
+		}
+
+		// Inventory routes
+		stock := api.Group("/inventory")
+		{
+			stock.POST("/receipts", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.ReceiveStock)
+			stock.GET("/consistency", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetInventoryConsistency)
+			stock.POST("/reconcile", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.ReconcileInventory)
+			stock.GET("/in-transit", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetInTransit)
+			stock.GET("/low-stock", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetLowStock)
+			stock.GET("/near-expiry", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetNearExpiry)
+		}
+
+		// Lot routes
+		lots := api.Group("/lots")
+		{
+			lots.GET("", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetLots)
+			lots.GET("/:id", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetLot)
+			lots.GET("/:id/sales", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetLotSales)
+		}
+
+		// Serial number routes
+		serials := api.Group("/serials")
+		{
+			serials.GET("", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetSerials)
+			serials.GET("/:id", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetSerial)
+			serials.POST("/:id/return", middleware.RBACMiddleware(models.PermSalesCreate), handlers.ReturnSerial)
+		}
+
+		// Stock transfer routes
+		transfers := api.Group("/transfers")
+		{
+			transfers.GET("", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetTransfers)
+			transfers.GET("/:id", middleware.RBACMiddleware(models.PermInventoryRead), handlers.GetTransfer)
+			transfers.POST("", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.CreateTransfer)
+			transfers.PUT("/:id", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.UpdateTransfer)
+			transfers.POST("/:id/ship", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.ShipTransfer)
+			transfers.POST("/:id/receive", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.ReceiveTransfer)
+			transfers.POST("/:id/cancel", middleware.RBACMiddleware(models.PermInventoryWrite), handlers.CancelTransfer)
+		}
+
+		// Stock reservation routes
+		reservations := api.Group("/reservations")
+		{
+			reservations.GET("", middleware.RBACMiddleware(models.PermSalesRead), handlers.GetReservations)
+			reservations.GET("/:id", middleware.RBACMiddleware(models.PermSalesRead), handlers.GetReservation)
+			reservations.POST("", middleware.RBACMiddleware(models.PermSalesCreate), handlers.CreateReservation)
+			reservations.POST("/:id/release", middleware.RBACMiddleware(models.PermSalesCreate), handlers.ReleaseReservation)
 		}
 
 		// POS/Sales routes
 		sales := api.Group("/sales")
 		{
-			sales.GET("", handlers.GetSales)
-			sales.GET("/:id", handlers.GetSale)
-			sales.POST("", handlers.CreateSale)
+			sales.GET("", middleware.RBACMiddleware(models.PermSalesRead), handlers.GetSales)
+			sales.GET("/:id", middleware.RBACMiddleware(models.PermSalesRead), handlers.GetSale)
+			sales.POST("", middleware.RBACMiddleware(models.PermSalesCreate), handlers.CreateSale)
+		}
+
+		// User routes
+		users := api.Group("/users")
+		{
+			users.GET("", middleware.RBACMiddleware(models.PermUsersRead), handlers.GetUsers)
+			users.GET("/:id", middleware.RBACMiddleware(models.PermUsersRead), handlers.GetUser)
+			users.POST("", middleware.RBACMiddleware(models.PermUsersWrite), handlers.CreateUser)
+			users.POST("/invite", middleware.RBACMiddleware(models.PermUsersWrite), handlers.InviteUser)
+			users.PUT("/:id/role", middleware.RBACMiddleware(models.PermUsersWrite), handlers.UpdateUserRole)
+			users.POST("/:id/disable", middleware.RBACMiddleware(models.PermUsersWrite), handlers.DisableUser)
+			users.POST("/:id/enable", middleware.RBACMiddleware(models.PermUsersWrite), handlers.EnableUser)
+			users.POST("/:id/logout-all", middleware.RBACMiddleware(models.PermUsersWrite), handlers.LogoutUserEverywhere)
+			users.POST("/:id/unlock", middleware.RBACMiddleware(models.PermUsersWrite), handlers.UnlockUser)
+		}
+
+		// API key routes
+		apiKeys := api.Group("/api-keys")
+		{
+			apiKeys.GET("", middleware.RBACMiddleware(models.PermAPIKeysRead), handlers.GetAPIKeys)
+			apiKeys.GET("/:id", middleware.RBACMiddleware(models.PermAPIKeysRead), handlers.GetAPIKey)
+			apiKeys.POST("", middleware.SessionOnly(), middleware.RBACMiddleware(models.PermAPIKeysWrite), handlers.CreateAPIKey)
+			apiKeys.DELETE("/:id", middleware.RBACMiddleware(models.PermAPIKeysWrite), handlers.RevokeAPIKey)
+		}
+
+		// Login attempt routes
+		loginAttempts := api.Group("/login-attempts")
+		{
+			loginAttempts.GET("", middleware.RBACMiddleware(models.PermUsersRead), handlers.GetLoginAttempts)
+			loginAttempts.POST("/unlock-ip", middleware.RBACMiddleware(models.PermUsersWrite), handlers.UnlockIP)
+		}
+
+		// Role routes
+		roles := api.Group("/roles")
+		{
+			roles.GET("", middleware.RBACMiddleware(models.PermRolesRead), handlers.GetRoles)
+			roles.GET("/:id", middleware.RBACMiddleware(models.PermRolesRead), handlers.GetRole)
+			roles.GET("/:id/users", middleware.RBACMiddleware(models.PermRolesRead), handlers.GetRoleUsers)
+			roles.POST("", middleware.RBACMiddleware(models.PermRolesWrite), handlers.CreateRole)
+			roles.PUT("/:id", middleware.RBACMiddleware(models.PermRolesWrite), handlers.UpdateRole)
+			roles.DELETE("/:id", middleware.RBACMiddleware(models.PermRolesWrite), handlers.DeleteRole)
+			roles.POST("/:id/clone", middleware.RBACMiddleware(models.PermRolesWrite), handlers.CloneRole)
+			roles.POST("/:id/permissions", middleware.RBACMiddleware(models.PermRolesWrite), handlers.AddRolePermissions)
+			roles.DELETE("/:id/permissions/:permissionId", middleware.RBACMiddleware(models.PermRolesWrite), handlers.RemoveRolePermission)
+		}
+
+		// Permission routes
+		permissions := api.Group("/permissions")
+		{
+			permissions.GET("", middleware.RBACMiddleware(models.PermRolesRead), handlers.GetPermissions)
+			permissions.GET("/:id", middleware.RBACMiddleware(models.PermRolesRead), handlers.GetPermission)
+			permissions.POST("", middleware.RBACMiddleware(models.PermRolesWrite), handlers.CreatePermission)
+			permissions.PUT("/:id", middleware.RBACMiddleware(models.PermRolesWrite), handlers.UpdatePermission)
+			permissions.DELETE("/:id", middleware.RBACMiddleware(models.PermRolesWrite), handlers.DeletePermission)
 		}
 	}
 
@@ -98,25 +385,153 @@ func main() {
 	}
 }
 
+// initJWT configures token signing. HS256 uses the shared secret; RS256 and
+// EdDSA load JWT_KEY_FILES, or generate a throwaway key in development.
+func initJWT(cfg *config.Config) error {
+	if cfg.JWTAlgorithm == "HS256" {
+		utils.InitJWT(cfg.JWTSecret)
+		return nil
+	}
+
+	var keys []utils.SigningKey
+	for _, path := range cfg.JWTKeyFiles {
+		key, err := utils.LoadSigningKeyFile(path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		key, err := utils.GenerateSigningKey(cfg.JWTAlgorithm)
+		if err != nil {
+			return err
+		}
+		log.Printf("No JWT_KEY_FILES configured, using an ephemeral %s key; tokens will not survive a restart", cfg.JWTAlgorithm)
+		keys = append(keys, key)
+	}
+
+	if keys[0].Method.Alg() != cfg.JWTAlgorithm {
+		return fmt.Errorf("active key is %s but JWT_ALGORITHM is %s", keys[0].Method.Alg(), cfg.JWTAlgorithm)
+	}
+
+	return utils.InitJWTKeys(keys[0].KID, keys)
+}
+
+// newRouter builds the engine and limits which peers may set the client IP
+// through X-Forwarded-For. The API key allow-lists and login throttling key
+// off ClientIP, so a forged header from an untrusted peer must be ignored.
+func newRouter(cfg *config.Config) (*gin.Engine, error) {
+	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+func newMailer(cfg *config.Config) mailer.Mailer {
+	switch cfg.MailDriver {
+	case "smtp":
+		return &mailer.SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+	case "file":
+		return &mailer.FileMailer{Dir: cfg.MailDir, From: cfg.MailFrom}
+	default:
+		return &mailer.LogMailer{From: cfg.MailFrom}
+	}
+}
+
+func newNotifier(cfg *config.Config) notify.Notifier {
+	switch cfg.LowStockNotifier {
+	case "mail":
+		return &notify.MailNotifier{Mailer: newMailer(cfg), To: cfg.LowStockMailTo}
+	case "webhook":
+		return &notify.WebhookNotifier{URL: cfg.LowStockWebhookURL}
+	default:
+		return notify.LogNotifier{}
+	}
+}
+
+func newLoginLimiters(cfg *config.Config) (user, ip *throttle.Limiter) {
+	var store throttle.Store
+	if cfg.LoginThrottleStore == "memory" {
+		store = throttle.NewMemoryStore()
+	} else {
+		store = throttle.NewDBStore(database.DB)
+	}
+
+	policy := throttle.Policy{
+		MaxFailures:     cfg.LoginMaxFailures,
+		LockoutDuration: cfg.LoginLockout,
+		BaseDelay:       cfg.LoginBackoffBase,
+		MaxDelay:        cfg.LoginBackoffMax,
+		Window:          cfg.LoginFailureWindow,
+	}
+	ipPolicy := policy
+	ipPolicy.MaxFailures = cfg.LoginIPMaxFailures
+	// Many users can share an IP behind a proxy, so only lock it out instead
+	// of delaying every attempt.
+	ipPolicy.BaseDelay = 0
+
+	return throttle.NewLimiter(store, policy), throttle.NewLimiter(store, ipPolicy)
+}
+
 func seedData() {
 	// Create default roles
 	var adminRole models.Role
-	if err := database.DB.Where("name = ?", "admin").First(&adminRole).Error; err != nil {
+	if err := database.DB.Where("name = ?", models.RoleAdmin).First(&adminRole).Error; err != nil {
 		adminRole = models.Role{
-			Name:        "admin",
+			Name:        models.RoleAdmin,
 			Description: "Administrator role with full access",
+			IsSystem:    true,
 		}
 		database.DB.Create(&adminRole)
 	}
 
 	var userRole models.Role
-	if err := database.DB.Where("name = ?", "user").First(&userRole).Error; err != nil {
+	if err := database.DB.Where("name = ?", models.RoleUser).First(&userRole).Error; err != nil {
 		userRole = models.Role{
-			Name:        "user",
+			Name:        models.RoleUser,
 			Description: "Regular user role",
+			IsSystem:    true,
 		}
 		database.DB.Create(&userRole)
 	}
+	database.DB.Model(&models.Role{}).Where("id IN ?", []uint{adminRole.ID, userRole.ID}).Update("is_system", true)
+
+	// Create the permission catalogue
+	for _, perm := range models.PermissionCatalogue {
+		var existing models.Permission
+		if err := database.DB.Where("name = ?", perm.Name).First(&existing).Error; err != nil {
+			perm.IsSystem = true
+			database.DB.Create(&perm)
+		} else if !existing.IsSystem {
+			database.DB.Model(&existing).Update("is_system", true)
+		}
+	}
+
+	// Admin is granted every permission; the user role gets the defaults
+	// only when it has nothing assigned, so edits made by admins survive
+	// restarts.
+	var allPermissions []models.Permission
+	database.DB.Find(&allPermissions)
+	if err := database.DB.Model(&adminRole).Association("Permissions").Append(allPermissions); err != nil {
+		log.Printf("Failed to grant permissions to admin role: %v", err)
+	}
+
+	if database.DB.Model(&userRole).Association("Permissions").Count() == 0 {
+		var userPermissions []models.Permission
+		database.DB.Where("name IN ?", models.DefaultUserPermissions).Find(&userPermissions)
+		if err := database.DB.Model(&userRole).Association("Permissions").Append(userPermissions); err != nil {
+			log.Printf("Failed to grant permissions to user role: %v", err)
+		}
+	}
+	middleware.InvalidateAllPermissions()
 
 	// Create default admin user
 	var adminUser models.User
@@ -147,8 +562,8 @@ func seedData() {
 	// Create sample units
 	units := []models.Unit{
 		{Name: "Piece", Description: "Individual item"},
-		{Name: "Kilogram", Description: "Weight in kg"},
-		{Name: "Liter", Description: "Volume in liters"},
+		{Name: "Kilogram", Description: "Weight in kg", AllowFractions: true},
+		{Name: "Liter", Description: "Volume in liters", AllowFractions: true},
 	}
 	for _, unit := range units {
 		var existing models.Unit
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edwinjordan/erp_golang/internal/config"
+	"github.com/edwinjordan/erp_golang/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouterIgnoresForwardedForFromUntrustedPeer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	allowList := []string{"10.0.0.0/8"}
+	clientIP := func(cfg *config.Config) (string, bool) {
+		router, err := newRouter(cfg)
+		if err != nil {
+			t.Fatalf("newRouter: %v", err)
+		}
+		router.GET("/ip", func(c *gin.Context) {
+			c.String(http.StatusOK, c.ClientIP())
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = "203.0.113.9:41000"
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		ip := w.Body.String()
+		return ip, utils.IPAllowed(ip, allowList)
+	}
+
+	ip, allowed := clientIP(&config.Config{})
+	if ip != "203.0.113.9" || allowed {
+		t.Errorf("A forged X-Forwarded-For should be ignored, got %s (allowed %v)", ip, allowed)
+	}
+
+	ip, allowed = clientIP(&config.Config{TrustedProxies: []string{"203.0.113.0/24"}})
+	if ip != "10.0.0.1" || !allowed {
+		t.Errorf("X-Forwarded-For from a trusted proxy should be honoured, got %s (allowed %v)", ip, allowed)
+	}
+}
@@ -1,13 +1,30 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/edwinjordan/erp_golang/pkg/money"
+	"github.com/edwinjordan/erp_golang/pkg/utils"
 	"github.com/joho/godotenv"
 )
 
+// Placeholder JWT secrets: the built-in default and the one in .env.example.
+const (
+	defaultJWTSecret = "your-secret-key"
+	exampleJWTSecret = "your-secret-key-change-this-in-production"
+)
+
 type Config struct {
+	// AppEnv is "development" for local work; anything else, including the
+	// default "production", enables the safety checks in Validate.
+	AppEnv string
+
 	DBHost     string
 	DBUser     string
 	DBPassword string
@@ -15,6 +32,129 @@ type Config struct {
 	DBPort     string
 	ServerPort string
 	JWTSecret  string
+
+	// TrustedProxies lists the proxy addresses and CIDR ranges whose
+	// X-Forwarded-For header is believed. Empty trusts none, so the client
+	// IP is always the peer address.
+	TrustedProxies []string
+
+	// JWTAlgorithm is HS256 (shared JWTSecret), RS256 or EdDSA.
+	JWTAlgorithm string
+	// JWTKeyFiles are PEM key files for RS256/EdDSA. The first one signs new
+	// tokens; the rest are kept to verify tokens signed before a rotation and
+	// may be public keys only.
+	JWTKeyFiles []string
+
+	// AllowRegistration enables the public /register endpoint.
+	AllowRegistration bool
+	// DefaultRole is the name of the role given to self-registered users.
+	DefaultRole string
+	// InviteTTL is how long an invitation link stays valid.
+	InviteTTL time.Duration
+
+	// AccessTokenTTL is the lifetime of JWT access tokens.
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is the lifetime of refresh tokens.
+	RefreshTokenTTL time.Duration
+
+	// TOTPIssuer is the account issuer shown in authenticator apps.
+	TOTPIssuer string
+	// MFATokenTTL is how long a user has to complete the second login step.
+	MFATokenTTL time.Duration
+
+	// LoginThrottleStore keeps failed login counters in "db" (shared by all
+	// instances) or "memory" (single instance only).
+	LoginThrottleStore string
+	// LoginMaxFailures failed logins lock a username for LoginLockout.
+	LoginMaxFailures int
+	// LoginIPMaxFailures failed logins lock a client IP for LoginLockout.
+	LoginIPMaxFailures int
+	LoginLockout       time.Duration
+	// LoginBackoffBase is the delay after the first failure; it doubles with
+	// every further failure up to LoginBackoffMax.
+	LoginBackoffBase time.Duration
+	LoginBackoffMax  time.Duration
+	// LoginFailureWindow is how long a failure counts towards a lockout.
+	LoginFailureWindow time.Duration
+
+	// OIDCEnabled turns on single sign-on through an OpenID Connect provider.
+	OIDCEnabled      bool
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	// OIDCRedirectURL is the callback URL of this API registered at the
+	// provider.
+	OIDCRedirectURL string
+	OIDCScopes      []string
+	// OIDCRoleClaim names the ID token claim holding the user's groups.
+	OIDCRoleClaim string
+	// OIDCRoleMapping maps claim values to role names as "value=role"
+	// entries; the first entry matching the user wins.
+	OIDCRoleMapping []string
+	// OIDCDefaultRole is given to users no mapping entry matches. When empty
+	// such users cannot sign in.
+	OIDCDefaultRole string
+	// OIDCAutoProvision creates a local account on a user's first sign-in.
+	OIDCAutoProvision bool
+	// OIDCAutoLink links an existing account with the same verified email on
+	// the first sign-in. When off, users link from their account instead.
+	// Accounts that use or require TOTP are never linked this way.
+	OIDCAutoLink bool
+	// OIDCTrustProviderMFA accepts the provider's word that the user passed a
+	// second factor, when its amr claim holds "mfa" or its acr claim is one of
+	// OIDCMFAACRValues, instead of asking for the local TOTP code.
+	OIDCTrustProviderMFA bool
+	OIDCMFAACRValues     []string
+
+	// PasswordMinLength is the minimum password length users may choose.
+	PasswordMinLength int
+	// PasswordBannedFile optionally lists extra banned passwords, one per line.
+	PasswordBannedFile string
+	// PasswordResetTTL is how long a password reset link stays valid.
+	PasswordResetTTL time.Duration
+	// AppBaseURL is the front-end URL used to build links in emails.
+	AppBaseURL string
+
+	// Currency is the ISO 4217 code of the currency prices are kept in.
+	Currency string
+	// CurrencyRounding is "half_up" or "half_even".
+	CurrencyRounding string
+
+	// MailDriver selects how mail is delivered: "log", "file" or "smtp".
+	MailDriver   string
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// LowStockCheckInterval is how often products are checked against their
+	// reorder points; zero disables the check.
+	LowStockCheckInterval time.Duration
+	// LowStockNotifier delivers low stock alerts: "log", "mail" or "webhook".
+	LowStockNotifier string
+	// LowStockMailTo lists the recipients of low stock alert emails.
+	LowStockMailTo []string
+	// LowStockWebhookURL receives low stock alerts as JSON.
+	LowStockWebhookURL string
+	// ReorderVelocityDays is how many days of sales suggested order
+	// quantities are based on.
+	ReorderVelocityDays int
+	// ReorderCoverDays is how many days of sales a suggested order should
+	// cover beyond the reorder point.
+	ReorderCoverDays int
+
+	// ReservationTTL is how long a stock reservation lasts when no expiry
+	// is given.
+	ReservationTTL time.Duration
+	// ReservationSweepInterval is how often expired reservations are
+	// released; zero disables the sweeper.
+	ReservationSweepInterval time.Duration
+
+	// SellExpiredLots lets sales take stock from expired lots once fresher
+	// stock runs out. It is off by default, so expired lots cannot be sold.
+	SellExpiredLots bool
 }
 
 func LoadConfig() *Config {
@@ -24,21 +164,239 @@ func LoadConfig() *Config {
 	}
 
 	config := &Config{
+		AppEnv:     getEnv("APP_ENV", "production"),
 		DBHost:     getEnv("DB_HOST", "localhost"),
 		DBUser:     getEnv("DB_USER", "postgres"),
 		DBPassword: getEnv("DB_PASSWORD", "postgres"),
 		DBName:     getEnv("DB_NAME", "erp_db"),
 		DBPort:     getEnv("DB_PORT", "5432"),
 		ServerPort: getEnv("SERVER_PORT", "8080"),
-		JWTSecret:  getEnv("JWT_SECRET", "your-secret-key"),
+		JWTSecret:  getEnv("JWT_SECRET", defaultJWTSecret),
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+
+		JWTAlgorithm: getEnv("JWT_ALGORITHM", "HS256"),
+		JWTKeyFiles:  getEnvList("JWT_KEY_FILES"),
+
+		AllowRegistration: getEnvBool("ALLOW_REGISTRATION", true),
+		DefaultRole:       getEnv("DEFAULT_ROLE", "user"),
+		InviteTTL:         getEnvDuration("INVITE_TTL", 72*time.Hour),
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		TOTPIssuer:  getEnv("TOTP_ISSUER", "ERP Golang"),
+		MFATokenTTL: getEnvDuration("MFA_TOKEN_TTL", 5*time.Minute),
+
+		LoginThrottleStore: getEnv("LOGIN_THROTTLE_STORE", "db"),
+		LoginMaxFailures:   getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures: getEnvInt("LOGIN_IP_MAX_FAILURES", 50),
+		LoginLockout:       getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		LoginBackoffBase:   getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		LoginBackoffMax:    getEnvDuration("LOGIN_BACKOFF_MAX", 30*time.Second),
+		LoginFailureWindow: getEnvDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+
+		OIDCEnabled:          getEnvBool("OIDC_ENABLED", false),
+		OIDCIssuerURL:        getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:         getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:     getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:      getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/auth/oidc/callback"),
+		OIDCScopes:           getEnvList("OIDC_SCOPES"),
+		OIDCRoleClaim:        getEnv("OIDC_ROLE_CLAIM", "groups"),
+		OIDCRoleMapping:      getEnvList("OIDC_ROLE_MAPPING"),
+		OIDCDefaultRole:      getEnv("OIDC_DEFAULT_ROLE", ""),
+		OIDCAutoProvision:    getEnvBool("OIDC_AUTO_PROVISION", true),
+		OIDCAutoLink:         getEnvBool("OIDC_AUTO_LINK", false),
+		OIDCTrustProviderMFA: getEnvBool("OIDC_TRUST_PROVIDER_MFA", false),
+		OIDCMFAACRValues:     getEnvList("OIDC_MFA_ACR_VALUES"),
+
+		PasswordMinLength:  getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordBannedFile: getEnv("PASSWORD_BANNED_FILE", ""),
+		PasswordResetTTL:   getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		AppBaseURL:         getEnv("APP_BASE_URL", "http://localhost:8080"),
+
+		Currency:         getEnv("CURRENCY", "IDR"),
+		CurrencyRounding: getEnv("CURRENCY_ROUNDING", "half_up"),
+
+		MailDriver:   getEnv("MAIL_DRIVER", "log"),
+		MailFrom:     getEnv("MAIL_FROM", "erp@localhost"),
+		MailDir:      getEnv("MAIL_DIR", "mail"),
+		SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:     getEnv("SMTP_PORT", "25"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+
+		LowStockCheckInterval: getEnvDuration("LOW_STOCK_CHECK_INTERVAL", time.Hour),
+		LowStockNotifier:      getEnv("LOW_STOCK_NOTIFIER", "log"),
+		LowStockMailTo:        getEnvList("LOW_STOCK_MAIL_TO"),
+		LowStockWebhookURL:    getEnv("LOW_STOCK_WEBHOOK_URL", ""),
+		ReorderVelocityDays:   getEnvInt("REORDER_VELOCITY_DAYS", 30),
+		ReorderCoverDays:      getEnvInt("REORDER_COVER_DAYS", 14),
+
+		ReservationTTL:           getEnvDuration("RESERVATION_TTL", 24*time.Hour),
+		ReservationSweepInterval: getEnvDuration("RESERVATION_SWEEP_INTERVAL", time.Minute),
+
+		SellExpiredLots: getEnvBool("SELL_EXPIRED_LOTS", false),
 	}
 
 	return config
 }
 
+// IsDevelopment reports whether the application runs in development mode.
+func (c *Config) IsDevelopment() bool {
+	return c.AppEnv == "development"
+}
+
+// Validate rejects configurations that are unsafe outside development.
+func (c *Config) Validate() error {
+	if _, err := utils.ParseIPAllowList(c.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
+	switch c.JWTAlgorithm {
+	case "HS256":
+		if !c.IsDevelopment() && (c.JWTSecret == defaultJWTSecret || c.JWTSecret == exampleJWTSecret) {
+			return errors.New("JWT_SECRET must be changed from its default outside development")
+		}
+	case "RS256", "EdDSA":
+		if !c.IsDevelopment() && len(c.JWTKeyFiles) == 0 {
+			return fmt.Errorf("JWT_KEY_FILES is required for %s outside development", c.JWTAlgorithm)
+		}
+	default:
+		return fmt.Errorf("unsupported JWT_ALGORITHM %q", c.JWTAlgorithm)
+	}
+
+	switch c.LoginThrottleStore {
+	case "db", "memory":
+	default:
+		return fmt.Errorf("unsupported LOGIN_THROTTLE_STORE %q", c.LoginThrottleStore)
+	}
+
+	switch c.MailDriver {
+	case "log", "file", "smtp":
+	default:
+		return fmt.Errorf("unsupported MAIL_DRIVER %q", c.MailDriver)
+	}
+
+	if _, err := c.MoneyCurrency(); err != nil {
+		return err
+	}
+
+	if c.LowStockCheckInterval > 0 {
+		switch c.LowStockNotifier {
+		case "log":
+		case "mail":
+			if len(c.LowStockMailTo) == 0 {
+				return errors.New("LOW_STOCK_MAIL_TO is required when LOW_STOCK_NOTIFIER is mail")
+			}
+		case "webhook":
+			if c.LowStockWebhookURL == "" {
+				return errors.New("LOW_STOCK_WEBHOOK_URL is required when LOW_STOCK_NOTIFIER is webhook")
+			}
+		default:
+			return fmt.Errorf("unsupported LOW_STOCK_NOTIFIER %q", c.LowStockNotifier)
+		}
+	}
+	if c.ReorderVelocityDays < 0 || c.ReorderCoverDays < 0 {
+		return errors.New("REORDER_VELOCITY_DAYS and REORDER_COVER_DAYS cannot be negative")
+	}
+
+	if c.OIDCEnabled {
+		if c.OIDCIssuerURL == "" || c.OIDCClientID == "" || c.OIDCRedirectURL == "" {
+			return errors.New("OIDC_ISSUER_URL, OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when OIDC_ENABLED is set")
+		}
+		if !c.IsDevelopment() && !strings.HasPrefix(c.OIDCIssuerURL, "https://") {
+			return errors.New("OIDC_ISSUER_URL must use https outside development")
+		}
+		if _, err := c.OIDCRoleMappings(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoneyCurrency returns the configured currency with its rounding mode.
+func (c *Config) MoneyCurrency() (money.Currency, error) {
+	rounding, err := money.ParseRoundingMode(c.CurrencyRounding)
+	if err != nil {
+		return money.Currency{}, fmt.Errorf("CURRENCY_ROUNDING: %w", err)
+	}
+	currency, err := money.LookupCurrency(c.Currency, rounding)
+	if err != nil {
+		return money.Currency{}, fmt.Errorf("CURRENCY: %w", err)
+	}
+	return currency, nil
+}
+
+// RoleMapping gives users whose role claim contains Value the role named Role.
+type RoleMapping struct {
+	Value string
+	Role  string
+}
+
+// OIDCRoleMappings parses OIDCRoleMapping.
+func (c *Config) OIDCRoleMappings() ([]RoleMapping, error) {
+	mappings := make([]RoleMapping, 0, len(c.OIDCRoleMapping))
+	for _, entry := range c.OIDCRoleMapping {
+		value, role, ok := strings.Cut(entry, "=")
+		value, role = strings.TrimSpace(value), strings.TrimSpace(role)
+		if !ok || value == "" || role == "" {
+			return nil, fmt.Errorf("invalid OIDC_ROLE_MAPPING entry %q, expected value=role", entry)
+		}
+		mappings = append(mappings, RoleMapping{Value: value, Role: role})
+	}
+	return mappings, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Invalid boolean for %s: %q, using default %t", key, value, defaultValue)
+			return defaultValue
+		}
+		return parsed
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Invalid integer for %s: %q, using default %d", key, value, defaultValue)
+			return defaultValue
+		}
+		return parsed
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Invalid duration for %s: %q, using default %s", key, value, defaultValue)
+			return defaultValue
+		}
+		return parsed
+	}
+	return defaultValue
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
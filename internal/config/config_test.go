@@ -0,0 +1,159 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateRejectsDefaultSecretOutsideDevelopment(t *testing.T) {
+	cfg := &Config{AppEnv: "production", MailDriver: "log", LoginThrottleStore: "db", Currency: "IDR", CurrencyRounding: "half_up", JWTAlgorithm: "HS256", JWTSecret: defaultJWTSecret}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should reject the default secret in production")
+	}
+
+	cfg.JWTSecret = exampleJWTSecret
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should reject the example secret in production")
+	}
+
+	cfg.JWTSecret = "a-real-secret"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate should accept a custom secret: %v", err)
+	}
+
+	cfg = &Config{AppEnv: "development", MailDriver: "log", LoginThrottleStore: "db", Currency: "IDR", CurrencyRounding: "half_up", JWTAlgorithm: "HS256", JWTSecret: defaultJWTSecret}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate should allow the default secret in development: %v", err)
+	}
+}
+
+func TestValidateAsymmetricKeys(t *testing.T) {
+	cfg := &Config{AppEnv: "production", MailDriver: "log", LoginThrottleStore: "db", Currency: "IDR", CurrencyRounding: "half_up", JWTAlgorithm: "RS256"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should require key files for RS256 in production")
+	}
+
+	cfg.JWTKeyFiles = []string{"/keys/current.pem"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate should accept RS256 with key files: %v", err)
+	}
+
+	cfg = &Config{AppEnv: "development", MailDriver: "log", LoginThrottleStore: "db", Currency: "IDR", CurrencyRounding: "half_up", JWTAlgorithm: "EdDSA"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate should allow ephemeral keys in development: %v", err)
+	}
+
+	cfg.JWTAlgorithm = "none"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should reject unknown algorithms")
+	}
+}
+
+func TestValidateMailDriver(t *testing.T) {
+	cfg := &Config{AppEnv: "development", MailDriver: "pigeon", LoginThrottleStore: "db", Currency: "IDR", CurrencyRounding: "half_up", JWTAlgorithm: "HS256"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should reject unknown mail drivers")
+	}
+}
+
+func TestValidateOIDC(t *testing.T) {
+	cfg := &Config{AppEnv: "production", MailDriver: "log", LoginThrottleStore: "db", Currency: "IDR", CurrencyRounding: "half_up", JWTAlgorithm: "HS256", JWTSecret: "a-real-secret", OIDCEnabled: true}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should require the issuer and client when OIDC is enabled")
+	}
+
+	cfg.OIDCIssuerURL = "http://idp.example.com"
+	cfg.OIDCClientID = "erp"
+	cfg.OIDCRedirectURL = "https://erp.example.com/auth/oidc/callback"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should require an https issuer in production")
+	}
+
+	cfg.OIDCIssuerURL = "https://idp.example.com"
+	cfg.OIDCRoleMapping = []string{"erp-admins=admin", "staff"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should reject malformed role mappings")
+	}
+
+	cfg.OIDCRoleMapping = []string{"erp-admins=admin", "staff = user"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate should accept a complete OIDC configuration: %v", err)
+	}
+
+	mappings, _ := cfg.OIDCRoleMappings()
+	if len(mappings) != 2 || mappings[1] != (RoleMapping{Value: "staff", Role: "user"}) {
+		t.Errorf("Unexpected role mappings %+v", mappings)
+	}
+}
+
+func TestValidateCurrency(t *testing.T) {
+	cfg := &Config{AppEnv: "development", MailDriver: "log", LoginThrottleStore: "db", Currency: "XYZ", CurrencyRounding: "half_up", JWTAlgorithm: "HS256"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should reject unknown currencies")
+	}
+
+	cfg.Currency = "usd"
+	cfg.CurrencyRounding = "sideways"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should reject unknown rounding modes")
+	}
+
+	cfg.CurrencyRounding = "half_even"
+	currency, err := cfg.MoneyCurrency()
+	if err != nil || currency.Code != "USD" || currency.Scale != 2 {
+		t.Errorf("Unexpected currency %+v (%v)", currency, err)
+	}
+}
+
+func TestValidateLowStockNotifier(t *testing.T) {
+	cfg := &Config{AppEnv: "development", MailDriver: "log", LoginThrottleStore: "db", Currency: "IDR", CurrencyRounding: "half_up", JWTAlgorithm: "HS256",
+		LowStockCheckInterval: time.Hour, LowStockNotifier: "mail"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should require recipients for the mail notifier")
+	}
+
+	cfg.LowStockMailTo = []string{"buyer@example.com"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate rejected a mail notifier with recipients: %v", err)
+	}
+
+	cfg.LowStockNotifier = "webhook"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should require a URL for the webhook notifier")
+	}
+
+	cfg.LowStockNotifier = "pager"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should reject unknown notifiers")
+	}
+
+	cfg.LowStockCheckInterval = 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate should ignore the notifier when the check is disabled: %v", err)
+	}
+}
+
+func TestValidateTrustedProxies(t *testing.T) {
+	cfg := &Config{AppEnv: "development", MailDriver: "log", LoginThrottleStore: "db", Currency: "IDR", CurrencyRounding: "half_up", JWTAlgorithm: "HS256",
+		TrustedProxies: []string{"10.0.0.0/8", "192.168.1.10"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate rejected valid proxies: %v", err)
+	}
+
+	cfg.TrustedProxies = []string{"load-balancer"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate should reject proxies that are not IPs or CIDRs")
+	}
+}
+
+func TestLoadConfigDefaultsToProduction(t *testing.T) {
+	t.Setenv("APP_ENV", "")
+	if cfg := LoadConfig(); cfg.IsDevelopment() {
+		t.Errorf("LoadConfig should default to production, got AppEnv %q", cfg.AppEnv)
+	}
+
+	t.Setenv("APP_ENV", "development")
+	if cfg := LoadConfig(); !cfg.IsDevelopment() {
+		t.Errorf("LoadConfig should honour APP_ENV=development, got AppEnv %q", cfg.AppEnv)
+	}
+}
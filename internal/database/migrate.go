@@ -0,0 +1,66 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a one-off change to existing data or columns that
+// AutoMigrate cannot make on its own, such as converting a column type with
+// an explicit cast or backfilling a new table. Each runs once, in order:
+// Migrations before AutoMigrate and DataMigrations after it.
+type Migration struct {
+	// Version orders migrations and records that they ran. Use a
+	// "YYYYMMDDNN_description" form.
+	Version string
+	Up      func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied Migration.
+type SchemaMigration struct {
+	Version   string `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
+
+// Migrate applies the migrations that have not run yet, each in its own
+// transaction.
+func Migrate(db *gorm.DB, migrations []Migration) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		var count int64
+		if err := db.Model(&SchemaMigration{}).Where("version = ?", migration.Version).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: migration.Version, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", migration.Version, err)
+		}
+		log.Printf("Applied migration %s", migration.Version)
+	}
+	return nil
+}
+
+// columnType returns the information_schema data type of a column, or "" if
+// the table or column does not exist yet.
+func columnType(tx *gorm.DB, table, column string) (string, error) {
+	var dataType string
+	err := tx.Raw(`SELECT data_type FROM information_schema.columns
+		WHERE table_schema = CURRENT_SCHEMA() AND table_name = ? AND column_name = ?`, table, column).
+		Scan(&dataType).Error
+	return dataType, err
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Migrations lists the migrations that run before AutoMigrate, in order.
+var Migrations = []Migration{
+	{Version: "2024060101_money_numeric", Up: moneyToNumeric},
+	{Version: "2024110101_quantity_numeric", Up: quantityToNumeric},
+	{Version: "2024110105_product_sku_active", Up: dropProductSKUIndex},
+}
+
+// DataMigrations lists the migrations that run after AutoMigrate, in order,
+// for backfills that need the new tables and columns.
+var DataMigrations = []Migration{
+	{Version: "2024061501_opening_stock_movements", Up: openingStockMovements},
+	{Version: "2024070101_default_warehouse", Up: defaultWarehouse},
+	{Version: "2024090101_product_search", Up: productSearch},
+	{Version: "2024100101_product_skus", Up: productSKUs},
+	{Version: "2024100102_product_search_sku", Up: productSearchSKU},
+	{Version: "2024110102_sale_item_units", Up: saleItemUnits},
+	{Version: "2024110103_fractional_units", Up: fractionalUnits},
+	{Version: "2024110104_serial_warehouses", Up: serialWarehouses},
+}
+
+// ProductSearchDocument is the full-text document product search matches
+// against, SKUs and names weighted above descriptions. Queries must use it
+// verbatim for Postgres to use the index productSearch builds on it.
+const ProductSearchDocument = `setweight(to_tsvector('simple', coalesce(sku, '') || ' ' || coalesce(name, '')), 'A') || ` +
+	`setweight(to_tsvector('simple', coalesce(description, '')), 'B')`
+
+// moneyToNumeric converts the float money columns to NUMERIC. Values are
+// rounded to four places, which removes float noise such as
+// 0.30000000000000004 without touching real cents.
+func moneyToNumeric(tx *gorm.DB) error {
+	columns := []struct{ table, column string }{
+		{"products", "price"},
+		{"sales", "total"},
+		{"sale_items", "price"},
+		{"sale_items", "subtotal"},
+	}
+	for _, c := range columns {
+		dataType, err := columnType(tx, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		// New databases get NUMERIC columns from AutoMigrate.
+		if dataType != "double precision" && dataType != "real" {
+			continue
+		}
+
+		sql := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE numeric(19,4) USING round(%s::numeric, 4)",
+			c.table, c.column, c.column)
+		if err := tx.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quantityToNumeric converts the integer quantity columns to NUMERIC, so
+// products sold by weight or length can hold fractions of their unit.
+func quantityToNumeric(tx *gorm.DB) error {
+	columns := []struct{ table, column string }{
+		{"products", "stock"},
+		{"products", "reserved"},
+		{"products", "reorder_point"},
+		{"products", "reorder_quantity"},
+		{"stock_levels", "quantity"},
+		{"stock_levels", "reserved"},
+		{"stock_levels", "reorder_point"},
+		{"stock_levels", "reorder_quantity"},
+		{"stock_movements", "quantity"},
+		{"stock_movements", "balance"},
+		{"lot_levels", "quantity"},
+		{"transfer_lines", "quantity"},
+		{"transfer_lines", "received_quantity"},
+		{"transfer_lines", "discrepancy_quantity"},
+		{"stock_adjustment_lines", "quantity"},
+		{"cycle_count_lines", "expected_quantity"},
+		{"cycle_count_lines", "counted_quantity"},
+		{"stock_reservation_lines", "quantity"},
+		{"sale_items", "quantity"},
+		{"sale_item_lots", "quantity"},
+	}
+	for _, c := range columns {
+		dataType, err := columnType(tx, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		// New databases get NUMERIC columns from AutoMigrate.
+		if dataType != "bigint" && dataType != "integer" && dataType != "smallint" {
+			continue
+		}
+
+		sql := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE numeric(18,3) USING %s::numeric",
+			c.table, c.column, c.column)
+		if err := tx.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openingStockMovements records the stock that existed before the ledger as
+// one opening adjustment per product, so the ledger totals match.
+func openingStockMovements(tx *gorm.DB) error {
+	return tx.Exec(`INSERT INTO stock_movements (product_id, type, quantity, balance, reason, created_at)
+		SELECT id, 'adjustment', stock, stock, 'Opening balance', NOW()
+		FROM products
+		WHERE stock <> 0
+		AND NOT EXISTS (SELECT 1 FROM stock_movements WHERE stock_movements.product_id = products.id)`).Error
+}
+
+// productSearch indexes products for search: a GIN index on the full-text
+// document and a trigram index on names for typo-tolerant matching. The
+// trigram index needs the pg_trgm extension, which ships with Postgres.
+func productSearch(tx *gorm.DB) error {
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+		"CREATE INDEX IF NOT EXISTS idx_products_search ON products USING GIN ((" + ProductSearchDocument + "))",
+		"CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (name gin_trgm_ops)",
+	}
+	for _, sql := range statements {
+		if err := tx.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// productSKUs gives every product without a SKU the one models.DefaultSKU
+// would: P and its ID, zero-padded to six digits.
+func productSKUs(tx *gorm.DB) error {
+	return tx.Exec(`UPDATE products SET sku = 'P' || CASE WHEN id < 100000 THEN lpad(id::text, 6, '0') ELSE id::text END
+		WHERE sku IS NULL`).Error
+}
+
+// productSearchSKU rebuilds the search index now that the document includes
+// SKUs.
+func productSearchSKU(tx *gorm.DB) error {
+	if err := tx.Exec("DROP INDEX IF EXISTS idx_products_search").Error; err != nil {
+		return err
+	}
+	return tx.Exec("CREATE INDEX idx_products_search ON products USING GIN ((" + ProductSearchDocument + "))").Error
+}
+
+// defaultWarehouse creates the default warehouse and moves all existing
+// stock, movements and sales into it, since until now there was only one
+// location.
+func defaultWarehouse(tx *gorm.DB) error {
+	var warehouseID uint
+	if err := tx.Raw("SELECT id FROM warehouses WHERE is_default AND deleted_at IS NULL ORDER BY id LIMIT 1").
+		Scan(&warehouseID).Error; err != nil {
+		return err
+	}
+	if warehouseID == 0 {
+		if err := tx.Raw(`INSERT INTO warehouses (code, name, is_default, created_at, updated_at)
+			VALUES ('MAIN', 'Main Warehouse', true, NOW(), NOW()) RETURNING id`).Scan(&warehouseID).Error; err != nil {
+			return err
+		}
+	}
+
+	statements := []string{
+		`INSERT INTO stock_levels (product_id, warehouse_id, quantity, updated_at)
+			SELECT id, ?, stock, NOW() FROM products WHERE stock <> 0
+			ON CONFLICT (product_id, warehouse_id) DO NOTHING`,
+		"UPDATE stock_movements SET warehouse_id = ? WHERE warehouse_id IS NULL",
+		"UPDATE sales SET warehouse_id = ? WHERE warehouse_id IS NULL",
+	}
+	for _, sql := range statements {
+		if err := tx.Exec(sql, warehouseID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saleItemUnits records that the sale items from before sale units were
+// sold in their product's base unit.
+func saleItemUnits(tx *gorm.DB) error {
+	return tx.Exec(`UPDATE sale_items SET unit_id = products.unit_id, unit_quantity = sale_items.quantity
+		FROM products
+		WHERE products.id = sale_items.product_id AND sale_items.unit_id IS NULL`).Error
+}
+
+// fractionalUnits lets the seeded units of weight and volume hold fractions,
+// as new databases seed them.
+func fractionalUnits(tx *gorm.DB) error {
+	return tx.Exec("UPDATE units SET allow_fractions = TRUE WHERE name IN ('Kilogram', 'Liter')").Error
+}
+
+// serialWarehouses records where the serial numbers in stock are: the
+// warehouse of their latest event, where they were received or returned.
+func serialWarehouses(tx *gorm.DB) error {
+	return tx.Exec(`UPDATE serial_numbers SET warehouse_id = (
+			SELECT warehouse_id FROM serial_events
+			WHERE serial_events.serial_number_id = serial_numbers.id
+			ORDER BY serial_events.id DESC LIMIT 1)
+		WHERE status = 'in_stock' AND warehouse_id IS NULL`).Error
+}
+
+// dropProductSKUIndex drops the unique index that covered deleted products
+// too, so AutoMigrate can replace it with one over the products in use and
+// SKUs of deleted products become free again.
+func dropProductSKUIndex(tx *gorm.DB) error {
+	return tx.Exec("DROP INDEX IF EXISTS idx_products_sku").Error
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// transactionAttempts is how often Transaction runs a function that keeps
+// failing with a retryable error.
+const transactionAttempts = 5
+
+// PostgreSQL error codes of transactions that may succeed when retried.
+const (
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// Transaction runs fn in a transaction on db, retrying it from the start when
+// PostgreSQL aborts it with a serialization failure or a deadlock. fn must
+// not have side effects outside the transaction.
+func Transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	return retry(transactionAttempts, func() error {
+		return db.Transaction(fn)
+	})
+}
+
+// IsRetryable reports whether err aborted a transaction that may succeed if
+// run again.
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == serializationFailure || pgErr.Code == deadlockDetected
+}
+
+// uniqueViolation is the PostgreSQL error code of a duplicate key.
+const uniqueViolation = "23505"
+
+// IsUniqueViolation reports whether err is a duplicate key error, for
+// handlers that rely on a unique index instead of checking first.
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+func retry(attempts int, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err = fn(); err == nil || !IsRetryable(err) {
+			return err
+		}
+		// Back off with jitter so the competing transactions do not collide
+		// again straight away.
+		backoff := time.Duration(10<<attempt) * time.Millisecond
+		time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff))))
+	}
+	return err
+}
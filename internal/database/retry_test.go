@@ -0,0 +1,63 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pgconn.PgError{Code: serializationFailure}, true},
+		{&pgconn.PgError{Code: deadlockDetected}, true},
+		{fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: serializationFailure}), true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{errors.New("connection refused"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	if !IsUniqueViolation(fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: uniqueViolation})) {
+		t.Error("A wrapped duplicate key error should be recognised")
+	}
+	if IsUniqueViolation(&pgconn.PgError{Code: deadlockDetected}) || IsUniqueViolation(errors.New("duplicate")) || IsUniqueViolation(nil) {
+		t.Error("Only duplicate key errors should be recognised")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	err := retry(5, func() error {
+		calls++
+		if calls < 3 {
+			return &pgconn.PgError{Code: deadlockDetected}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on the third call, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	permanent := errors.New("insufficient stock")
+	if err := retry(5, func() error { calls++; return permanent }); err != permanent || calls != 1 {
+		t.Errorf("Non-retryable errors should be returned at once, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = retry(3, func() error { calls++; return &pgconn.PgError{Code: serializationFailure} })
+	if !IsRetryable(err) || calls != 3 {
+		t.Errorf("Expected to give up after 3 calls, got %v after %d calls", err, calls)
+	}
+}
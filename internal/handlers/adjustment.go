@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type StockAdjustmentLineRequest struct {
+	ProductID uint `json:"product_id" binding:"required"`
+	// Quantity is the signed change, negative to remove stock.
+	Quantity measure.Quantity `json:"quantity" binding:"required"`
+	// Serials names the units written off or found, one per unit, for
+	// serialized products.
+	Serials []string `json:"serials"`
+}
+
+type StockAdjustmentRequest struct {
+	// WarehouseID defaults to the default warehouse.
+	WarehouseID uint                         `json:"warehouse_id"`
+	ReasonCode  string                       `json:"reason_code" binding:"required,oneof=shrinkage damage count_correction found other"`
+	Notes       string                       `json:"notes"`
+	Lines       []StockAdjustmentLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// preloadAdjustment loads an adjustment's warehouse and lines.
+func preloadAdjustment(db *gorm.DB) *gorm.DB {
+	return db.Preload("Warehouse").
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).Preload("Lines.Product").Preload("Lines.Serials")
+}
+
+// GetAdjustments lists stock adjustments, newest first, optionally filtered
+// by ?status, ?reason_code and ?warehouse_id.
+func GetAdjustments(c *gin.Context) {
+	query := preloadAdjustment(database.DB).Order("id DESC")
+	for _, filter := range []string{"status", "reason_code", "warehouse_id"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+
+	var adjustments []models.StockAdjustment
+	if err := query.Find(&adjustments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch adjustments"})
+		return
+	}
+	c.JSON(http.StatusOK, adjustments)
+}
+
+func GetAdjustment(c *gin.Context) {
+	id := c.Param("id")
+	var adjustment models.StockAdjustment
+	if err := preloadAdjustment(database.DB).First(&adjustment, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Adjustment not found"})
+		return
+	}
+	c.JSON(http.StatusOK, adjustment)
+}
+
+// CreateAdjustment drafts a stock adjustment. Stock changes only when it is
+// posted.
+func CreateAdjustment(c *gin.Context) {
+	var req StockAdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.WarehouseID == 0 {
+		id, err := inventory.DefaultWarehouseID(database.DB)
+		if err != nil {
+			inventoryError(c, err, "Failed to create adjustment")
+			return
+		}
+		req.WarehouseID = id
+	} else if !warehouseExists(c, req.WarehouseID) {
+		return
+	}
+	lines := make([]serialLine, len(req.Lines))
+	for i, line := range req.Lines {
+		lines[i] = serialLine{line.ProductID, line.Quantity, line.Serials}
+	}
+	if !checkLineSerials(c, lines) {
+		return
+	}
+
+	adjustment := models.StockAdjustment{
+		WarehouseID: req.WarehouseID,
+		ReasonCode:  req.ReasonCode,
+		Notes:       req.Notes,
+		Status:      models.AdjustmentDraft,
+		CreatedByID: c.GetUint("userID"),
+	}
+	for _, line := range req.Lines {
+		adjustmentLine := models.StockAdjustmentLine{ProductID: line.ProductID, Quantity: line.Quantity}
+		for _, serial := range line.Serials {
+			adjustmentLine.Serials = append(adjustmentLine.Serials, models.StockAdjustmentSerial{Serial: serial})
+		}
+		adjustment.Lines = append(adjustment.Lines, adjustmentLine)
+	}
+
+	if err := database.DB.Create(&adjustment).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create adjustment"})
+		return
+	}
+
+	// Load relations
+	preloadAdjustment(database.DB).First(&adjustment, adjustment.ID)
+
+	c.JSON(http.StatusCreated, adjustment)
+}
+
+// PostAdjustment applies a draft adjustment to stock.
+func PostAdjustment(c *gin.Context) {
+	userID := c.GetUint("userID")
+	updateAdjustment(c, "Failed to post adjustment", func(tx *gorm.DB, adjustment *models.StockAdjustment) error {
+		return inventory.PostAdjustment(tx, adjustment, userID)
+	})
+}
+
+// CancelAdjustment cancels a draft adjustment.
+func CancelAdjustment(c *gin.Context) {
+	updateAdjustment(c, "Failed to cancel adjustment", func(tx *gorm.DB, adjustment *models.StockAdjustment) error {
+		if adjustment.Status != models.AdjustmentDraft {
+			return inventory.ErrAdjustmentStatus
+		}
+		adjustment.Status = models.AdjustmentCancelled
+		return tx.Model(adjustment).Update("status", adjustment.Status).Error
+	})
+}
+
+// updateAdjustment locks the adjustment named by the id parameter, applies
+// fn in a transaction and responds with the reloaded adjustment.
+func updateAdjustment(c *gin.Context, message string, fn func(tx *gorm.DB, adjustment *models.StockAdjustment) error) {
+	var adjustment models.StockAdjustment
+	err := database.Transaction(database.DB, func(tx *gorm.DB) error {
+		adjustment = models.StockAdjustment{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Lines.Serials").First(&adjustment, c.Param("id")).Error
+		if err != nil {
+			return err
+		}
+		return fn(tx, &adjustment)
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Adjustment not found"})
+		return
+	case errors.Is(err, inventory.ErrAdjustmentStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": "Only draft adjustments can be changed"})
+		return
+	case err != nil:
+		inventoryError(c, err, message)
+		return
+	}
+
+	preloadAdjustment(database.DB).First(&adjustment, adjustment.ID)
+	c.JSON(http.StatusOK, adjustment)
+}
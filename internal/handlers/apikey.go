@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/middleware"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyPrefix marks API keys so they are recognisable in logs and secret
+// scanners.
+const apiKeyPrefix = "erp_"
+
+type CreateAPIKeyRequest struct {
+	Name          string     `json:"name" binding:"required"`
+	PermissionIDs []uint     `json:"permission_ids" binding:"required,min=1"`
+	UserID        *uint      `json:"user_id"`
+	AllowedIPs    []string   `json:"allowed_ips"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey models.APIKey `json:"api_key"`
+	Key    string        `json:"key"`
+}
+
+func GetAPIKeys(c *gin.Context) {
+	query := database.DB.Preload("Permissions").Order("id")
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var keys []models.APIKey
+	if err := query.Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func GetAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	var key models.APIKey
+	if err := database.DB.Preload("Permissions").First(&key, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// CreateAPIKey issues an API key acting on behalf of a user, the caller by
+// default. The key is returned once; only its hash is stored.
+func CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	if _, err := utils.ParseIPAllowList(req.AllowedIPs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	creatorID := c.MustGet("userID").(uint)
+	ownerID := creatorID
+	if req.UserID != nil {
+		ownerID = *req.UserID
+	}
+
+	var owner models.User
+	if err := database.DB.First(&owner, ownerID).Error; err != nil || owner.IsDisabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+		return
+	}
+
+	permissions, ok := findPermissions(c, req.PermissionIDs)
+	if !ok {
+		return
+	}
+
+	// A key may not grant more than its owner holds, nor more than the caller
+	// holds, or issuing keys would be a way to escalate privileges.
+	for _, roleID := range []uint{owner.RoleID, c.MustGet("roleID").(uint)} {
+		granted, err := middleware.RolePermissions(roleID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions"})
+			return
+		}
+		for _, permission := range permissions {
+			if _, ok := granted[permission.Name]; !ok {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant permission " + permission.Name})
+				return
+			}
+		}
+	}
+
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	plain := apiKeyPrefix + secret
+
+	key := models.APIKey{
+		Name:        req.Name,
+		Prefix:      plain[:len(apiKeyPrefix)+8],
+		KeyHash:     utils.HashToken(plain),
+		UserID:      owner.ID,
+		CreatedByID: creatorID,
+		Permissions: permissions,
+		AllowedIPs:  req.AllowedIPs,
+		ExpiresAt:   req.ExpiresAt,
+	}
+	if err := database.DB.Create(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{APIKey: key, Key: plain})
+}
+
+// RevokeAPIKey disables an API key immediately. Revoked keys are kept for
+// auditing.
+func RevokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	var key models.APIKey
+	if err := database.DB.First(&key, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	if key.RevokedAt == nil {
+		if err := database.DB.Model(&key).Update("revoked_at", time.Now()).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
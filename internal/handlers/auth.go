@@ -5,15 +5,13 @@ import (
 
 	"github.com/edwinjordan/erp_golang/internal/database"
 	"github.com/edwinjordan/erp_golang/internal/models"
-	"github.com/edwinjordan/erp_golang/pkg/utils"
 	"github.com/gin-gonic/gin"
 )
 
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
-	RoleID   uint   `json:"role_id"`
+	Password string `json:"password" binding:"required"`
 }
 
 type LoginRequest struct {
@@ -22,26 +20,40 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	Token        string      `json:"token"`
+	RefreshToken string      `json:"refresh_token"`
+	ExpiresIn    int64       `json:"expires_in"`
+	User         models.User `json:"user"`
 }
 
 func Register(c *gin.Context) {
+	if !appConfig.AllowRegistration {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is disabled"})
+		return
+	}
+
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Default role is user (roleID: 2), admin is 1
-	if req.RoleID == 0 {
-		req.RoleID = 2
+	if err := passwordPolicy.Validate(req.Password, req.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Self-registered users always get the configured default role
+	var role models.Role
+	if err := database.DB.Where("name = ?", appConfig.DefaultRole).First(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Default role is not configured"})
+		return
 	}
 
 	user := models.User{
 		Username: req.Username,
 		Email:    req.Email,
-		RoleID:   req.RoleID,
+		RoleID:   role.ID,
 	}
 
 	if err := user.HashPassword(req.Password); err != nil {
@@ -57,16 +69,7 @@ func Register(c *gin.Context) {
 	// Load role
 	database.DB.Preload("Role").First(&user, user.ID)
 
-	token, err := utils.GenerateToken(user.ID, user.RoleID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
-	}
-
-	c.JSON(http.StatusCreated, AuthResponse{
-		Token: token,
-		User:  user,
-	})
+	completeLogin(c, user, http.StatusCreated)
 }
 
 func Login(c *gin.Context) {
@@ -76,28 +79,37 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	if loginThrottled(c, req.Username) {
+		return
+	}
+
 	var user models.User
 	if err := database.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
+		loginFailed(c, req.Username, nil, loginReasonUnknownUser)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	if err := user.CheckPassword(req.Password); err != nil {
+		loginFailed(c, req.Username, &user.ID, loginReasonInvalidPassword)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	if user.IsDisabled() {
+		recordLoginAttempt(c, user.Username, &user.ID, false, loginReasonDisabled)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
 	// Load role
 	database.DB.Preload("Role").First(&user, user.ID)
 
-	token, err := utils.GenerateToken(user.ID, user.RoleID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
+	// With a second factor pending the attempt is only recorded once the code
+	// has been checked, so a known password alone does not reset the counter.
+	if !user.TOTPEnabled && !user.Role.RequireMFA {
+		loginSucceeded(c, user)
 	}
 
-	c.JSON(http.StatusOK, AuthResponse{
-		Token: token,
-		User:  user,
-	})
+	completeLogin(c, user, http.StatusOK)
 }
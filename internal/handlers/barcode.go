@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/barcode"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// checkIdentifiers normalizes the request's barcodes and checks that they
+// and its SKU are not used by a product other than productID, zero for a
+// new product. On failure it writes the response and returns false.
+func checkIdentifiers(c *gin.Context, req ProductRequest, productID uint) ([]string, bool) {
+	if req.SKU != "" {
+		var count int64
+		if err := database.DB.Model(&models.Product{}).
+			Where("sku = ? AND id <> ?", req.SKU, productID).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check SKU"})
+			return nil, false
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "SKU already in use: " + req.SKU})
+			return nil, false
+		}
+	}
+
+	codes := make([]string, 0, len(req.Barcodes))
+	seen := make(map[string]bool, len(req.Barcodes))
+	for _, raw := range req.Barcodes {
+		code, err := barcode.Normalize(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid barcode " + raw + ": " + err.Error()})
+			return nil, false
+		}
+		if !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) > 0 {
+		var taken []string
+		if err := database.DB.Model(&models.ProductBarcode{}).
+			Where("code IN ? AND product_id <> ?", codes, productID).Pluck("code", &taken).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check barcodes"})
+			return nil, false
+		}
+		if len(taken) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Barcode already in use: " + taken[0]})
+			return nil, false
+		}
+	}
+	return codes, true
+}
+
+// replaceBarcodes makes codes the product's barcodes.
+func replaceBarcodes(tx *gorm.DB, productID uint, codes []string) error {
+	query := tx.Where("product_id = ?", productID)
+	if len(codes) > 0 {
+		query = query.Where("code NOT IN ?", codes)
+	}
+	if err := query.Delete(&models.ProductBarcode{}).Error; err != nil {
+		return err
+	}
+	for _, code := range codes {
+		err := tx.Where(models.ProductBarcode{ProductID: productID, Code: code}).
+			FirstOrCreate(&models.ProductBarcode{}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findByBarcode returns the ID of the product with an EAN-13 or UPC-A code.
+// It returns a barcode error for a malformed code and gorm.ErrRecordNotFound
+// for an unknown one.
+func findByBarcode(db *gorm.DB, code string) (uint, error) {
+	code, err := barcode.Normalize(code)
+	if err != nil {
+		return 0, err
+	}
+	var ids []uint
+	err = db.Model(&models.ProductBarcode{}).
+		Joins("JOIN products ON products.id = product_barcodes.product_id AND products.deleted_at IS NULL").
+		Where("product_barcodes.code = ?", code).Limit(1).Pluck("product_barcodes.product_id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return ids[0], nil
+}
+
+// matchIdentifier returns the IDs of products whose SKU or barcode is
+// exactly q, so searching for a scanned or typed code puts its product first.
+func matchIdentifier(db *gorm.DB, q string) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&models.Product{}).
+		Where("sku IN ?", []string{q, strings.ToUpper(q)}).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	id, err := findByBarcode(db, q)
+	switch {
+	case err == nil:
+		for _, existing := range ids {
+			if existing == id {
+				return ids, nil
+			}
+		}
+		return append(ids, id), nil
+	case errors.Is(err, barcode.ErrInvalidFormat), errors.Is(err, barcode.ErrCheckDigit),
+		errors.Is(err, gorm.ErrRecordNotFound):
+		return ids, nil
+	default:
+		return nil, err
+	}
+}
+
+// GetProductByBarcode looks up the product a scanner read. UPC-A codes find
+// the same product as their EAN-13 form.
+func GetProductByBarcode(c *gin.Context) {
+	id, err := findByBarcode(database.DB, c.Param("code"))
+	switch {
+	case errors.Is(err, barcode.ErrInvalidFormat), errors.Is(err, barcode.ErrCheckDigit):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up barcode"})
+		return
+	}
+
+	var product models.Product
+	if err := preloadProduct(database.DB).First(&product, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	c.JSON(http.StatusOK, product)
+}
+
+// GenerateBarcode gives a product without barcodes an in-store EAN-13, so
+// it can be labelled and scanned.
+func GenerateBarcode(c *gin.Context) {
+	var product models.Product
+	if err := database.DB.Preload("Barcodes").First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if product.HasVariants {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Barcodes belong to variants, not their parent"})
+		return
+	}
+	if len(product.Barcodes) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Product already has a barcode"})
+		return
+	}
+
+	code, err := barcode.Internal(product.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate barcode"})
+		return
+	}
+	// The unique index on the code catches both another product holding it
+	// and a concurrent request for the same product.
+	err = database.DB.Create(&models.ProductBarcode{ProductID: product.ID, Code: code}).Error
+	if database.IsUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Barcode already in use: " + code})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate barcode"})
+		return
+	}
+
+	preloadProduct(database.DB).First(&product, product.ID)
+	c.JSON(http.StatusCreated, product)
+}
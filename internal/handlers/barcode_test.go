@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edwinjordan/erp_golang/internal/config"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/barcode"
+	"github.com/gin-gonic/gin"
+)
+
+func TestScanBarcodes(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 2, 0)
+	scanned, unlabelled := f.products[0], f.products[1]
+	digits := fmt.Sprintf("9%010d", scanned.ID)
+	upc := fmt.Sprintf("%s%d", digits, barcode.CheckDigit(digits))
+	db.Create(&models.ProductBarcode{ProductID: scanned.ID, Code: "0" + upc})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.GET("/products/by-barcode/:code", GetProductByBarcode)
+	router.POST("/products/:id/barcode", GenerateBarcode)
+	router.POST("/sales", CreateSale)
+	call := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	for _, code := range []string{upc, "0" + upc} {
+		var product models.Product
+		if status := call(http.MethodGet, "/products/by-barcode/"+code, nil, &product); status != http.StatusOK || product.ID != scanned.ID {
+			t.Errorf("Expected %s to find product %d, got %d: %+v", code, scanned.ID, status, product)
+		}
+	}
+	if status := call(http.MethodGet, "/products/by-barcode/"+upc[:11]+"x", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("A malformed barcode should be refused, got %d", status)
+	}
+
+	internal, _ := barcode.Internal(unlabelled.ID)
+	if status := call(http.MethodGet, "/products/by-barcode/"+internal, nil, nil); status != http.StatusNotFound {
+		t.Errorf("Expected an unknown barcode to find nothing, got %d", status)
+	}
+	var labelled models.Product
+	path := fmt.Sprintf("/products/%d/barcode", unlabelled.ID)
+	if status := call(http.MethodPost, path, nil, &labelled); status != http.StatusCreated || len(labelled.Barcodes) != 1 || labelled.Barcodes[0].Code != internal {
+		t.Errorf("Expected the in-store barcode %s, got %d: %+v", internal, status, labelled.Barcodes)
+	}
+	if status := call(http.MethodPost, path, nil, nil); status != http.StatusConflict {
+		t.Errorf("A product with a barcode should not get another, got %d", status)
+	}
+
+	var sale models.Sale
+	req := CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{{Barcode: upc, Quantity: qty(2)}}}
+	if status := call(http.MethodPost, "/sales", req, &sale); status != http.StatusCreated || len(sale.SaleItems) != 1 || sale.SaleItems[0].ProductID != scanned.ID {
+		t.Fatalf("Expected a sale of the scanned product, got %d: %+v", status, sale)
+	}
+	if stock := stockAt(db, scanned.ID, f.warehouse.ID); !stock.IsZero() {
+		t.Errorf("Expected the scanned stock to be sold, got %s left", stock)
+	}
+	unknown := fmt.Sprintf("9%010d", unlabelled.ID)
+	req = CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{{Barcode: fmt.Sprintf("%s%d", unknown, barcode.CheckDigit(unknown)), Quantity: qty(1)}}}
+	if status := call(http.MethodPost, "/sales", req, nil); status != http.StatusNotFound {
+		t.Errorf("A sale of an unknown barcode should be refused, got %d", status)
+	}
+}
+
+func TestDeletedProductsFreeTheirSKU(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 0, 0)
+	deleted, kept := f.products[0], f.products[1]
+	sku := fmt.Sprintf("REUSE-%d", deleted.ID)
+	db.Model(&deleted).Update("sku", sku)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.PUT("/products/:id", UpdateProduct)
+	router.DELETE("/products/:id", DeleteProduct)
+	call := func(method, path string, body interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		return w.Code
+	}
+	update := ProductRequest{SKU: sku, Name: kept.Name, CategoryID: kept.CategoryID, UnitID: kept.UnitID, Price: &kept.Price}
+	path := fmt.Sprintf("/products/%d", kept.ID)
+
+	if code := call(http.MethodPut, path, update); code != http.StatusConflict {
+		t.Errorf("A SKU in use should conflict, got %d", code)
+	}
+	if code := call(http.MethodDelete, fmt.Sprintf("/products/%d", deleted.ID), nil); code != http.StatusOK {
+		t.Fatalf("Expected the product to be deleted, got %d", code)
+	}
+	if code := call(http.MethodPut, path, update); code != http.StatusOK {
+		t.Errorf("The SKU of a deleted product should be free again, got %d", code)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/listing"
 	"github.com/edwinjordan/erp_golang/internal/models"
 	"github.com/gin-gonic/gin"
 )
@@ -13,13 +14,21 @@ type CategoryRequest struct {
 	Description string `json:"description"`
 }
 
+// categoryListing is what GET /api/categories sorts by.
+var categoryListing = listing.Spec{
+	Sorts:       map[string]string{"id": "id", "name": "name", "created_at": "created_at"},
+	DefaultSort: "id",
+}
+
+// GetCategories lists categories a page at a time.
 func GetCategories(c *gin.Context) {
 	var categories []models.Category
-	if err := database.DB.Find(&categories).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+	page, err := listing.Find(c, database.DB, categoryListing, &categories)
+	if err != nil {
+		listError(c, err, "Failed to fetch categories")
 		return
 	}
-	c.JSON(http.StatusOK, categories)
+	c.JSON(http.StatusOK, page)
 }
 
 func GetCategory(c *gin.Context) {
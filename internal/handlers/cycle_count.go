@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CycleCountRequest struct {
+	WarehouseID uint `json:"warehouse_id" binding:"required"`
+	// ProductIDs limits the count to these products. Without it every
+	// product stocked at the warehouse is counted.
+	ProductIDs []uint `json:"product_ids"`
+	Notes      string `json:"notes"`
+}
+
+type CountRequest struct {
+	ProductID       uint              `json:"product_id" binding:"required"`
+	CountedQuantity *measure.Quantity `json:"counted_quantity" binding:"required,min=0"`
+	// Serials names the units counted, one per unit, for serialized
+	// products.
+	Serials []string `json:"serials"`
+}
+
+type CycleCountCountsRequest struct {
+	Counts []CountRequest `json:"counts" binding:"required,min=1,dive"`
+}
+
+// preloadCycleCount loads a cycle count's warehouse and lines.
+func preloadCycleCount(db *gorm.DB) *gorm.DB {
+	return db.Preload("Warehouse").
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("product_id") }).Preload("Lines.Product").Preload("Lines.Serials")
+}
+
+// GetCycleCounts lists cycle counts, newest first, optionally filtered by
+// ?status and ?warehouse_id. Lines are left out; fetch a single count for
+// them.
+func GetCycleCounts(c *gin.Context) {
+	query := database.DB.Preload("Warehouse").Order("id DESC")
+	for _, filter := range []string{"status", "warehouse_id"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+
+	var counts []models.CycleCount
+	if err := query.Find(&counts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cycle counts"})
+		return
+	}
+	c.JSON(http.StatusOK, counts)
+}
+
+// GetCycleCount returns a cycle count with the expected, counted and
+// variance quantity of every line.
+func GetCycleCount(c *gin.Context) {
+	id := c.Param("id")
+	var count models.CycleCount
+	if err := preloadCycleCount(database.DB).First(&count, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cycle count not found"})
+		return
+	}
+	c.JSON(http.StatusOK, count)
+}
+
+// CreateCycleCount opens a cycle count, freezing the current stock of each
+// product as its expected quantity.
+func CreateCycleCount(c *gin.Context) {
+	var req CycleCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !warehouseExists(c, req.WarehouseID) {
+		return
+	}
+
+	count := models.CycleCount{
+		WarehouseID: req.WarehouseID,
+		Status:      models.CycleCountOpen,
+		Notes:       req.Notes,
+		CreatedByID: c.GetUint("userID"),
+	}
+
+	err := database.Transaction(database.DB, func(tx *gorm.DB) error {
+		productIDs := req.ProductIDs
+		if len(productIDs) == 0 {
+			if err := tx.Model(&models.StockLevel{}).Where("warehouse_id = ? AND quantity <> 0", req.WarehouseID).
+				Order("product_id").Pluck("product_id", &productIDs).Error; err != nil {
+				return err
+			}
+		}
+
+		lines, err := countLines(tx, req.WarehouseID, productIDs)
+		if err != nil {
+			return err
+		}
+		count.ID = 0
+		count.Lines = lines
+		return tx.Create(&count).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create cycle count"})
+		return
+	}
+
+	// Load relations
+	preloadCycleCount(database.DB).First(&count, count.ID)
+
+	c.JSON(http.StatusCreated, count)
+}
+
+// RecordCounts records counted quantities for one or more products of an
+// open cycle count, and the serial numbers counted for serialized products.
+// Recounting a product replaces its count; a product not yet in the count is
+// added with its current stock as expected quantity.
+func RecordCounts(c *gin.Context) {
+	var req CycleCountCountsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lines := make([]serialLine, len(req.Counts))
+	for i, entry := range req.Counts {
+		lines[i] = serialLine{entry.ProductID, *entry.CountedQuantity, entry.Serials}
+	}
+	if !checkLineSerials(c, lines) {
+		return
+	}
+
+	userID := c.GetUint("userID")
+	updateCycleCount(c, "Failed to record counts", func(tx *gorm.DB, count *models.CycleCount) error {
+		if count.Status != models.CycleCountOpen {
+			return inventory.ErrCycleCountStatus
+		}
+
+		lines := make(map[uint]*models.CycleCountLine, len(count.Lines))
+		for i := range count.Lines {
+			lines[count.Lines[i].ProductID] = &count.Lines[i]
+		}
+		var missing []uint
+		for _, entry := range req.Counts {
+			if lines[entry.ProductID] == nil {
+				missing = append(missing, entry.ProductID)
+			}
+		}
+		added, err := countLines(tx, count.WarehouseID, missing)
+		if err != nil {
+			return err
+		}
+		for i := range added {
+			added[i].CycleCountID = count.ID
+			lines[added[i].ProductID] = &added[i]
+		}
+
+		now := time.Now()
+		for _, entry := range req.Counts {
+			line := lines[entry.ProductID]
+			line.CountedQuantity = entry.CountedQuantity
+			line.CountedByID = &userID
+			line.CountedAt = &now
+			if err := tx.Where("cycle_count_line_id = ?", line.ID).Delete(&models.CycleCountSerial{}).Error; err != nil {
+				return err
+			}
+			line.Serials = nil
+			for _, serial := range entry.Serials {
+				line.Serials = append(line.Serials, models.CycleCountSerial{Serial: serial})
+			}
+			if err := tx.Save(line).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ApproveCycleCount posts the variances of a fully counted cycle count as a
+// count_correction adjustment.
+func ApproveCycleCount(c *gin.Context) {
+	userID := c.GetUint("userID")
+	updateCycleCount(c, "Failed to approve cycle count", func(tx *gorm.DB, count *models.CycleCount) error {
+		return inventory.ApproveCycleCount(tx, count, userID)
+	})
+}
+
+// CancelCycleCount abandons an open cycle count without changing stock.
+func CancelCycleCount(c *gin.Context) {
+	updateCycleCount(c, "Failed to cancel cycle count", func(tx *gorm.DB, count *models.CycleCount) error {
+		if count.Status != models.CycleCountOpen {
+			return inventory.ErrCycleCountStatus
+		}
+		count.Status = models.CycleCountCancelled
+		return tx.Model(count).Update("status", count.Status).Error
+	})
+}
+
+// countLines returns new cycle count lines for the products, expecting
+// their current stock at the warehouse.
+func countLines(tx *gorm.DB, warehouseID uint, productIDs []uint) ([]models.CycleCountLine, error) {
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+	onHand, err := inventory.OnHand(tx, warehouseID, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	var lines []models.CycleCountLine
+	seen := make(map[uint]bool, len(productIDs))
+	for _, id := range productIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		lines = append(lines, models.CycleCountLine{ProductID: id, ExpectedQuantity: onHand[id]})
+	}
+	return lines, nil
+}
+
+// updateCycleCount locks the cycle count named by the id parameter, applies
+// fn in a transaction and responds with the reloaded count.
+func updateCycleCount(c *gin.Context, message string, fn func(tx *gorm.DB, count *models.CycleCount) error) {
+	var count models.CycleCount
+	err := database.Transaction(database.DB, func(tx *gorm.DB) error {
+		count = models.CycleCount{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Lines.Serials").First(&count, c.Param("id")).Error
+		if err != nil {
+			return err
+		}
+		return fn(tx, &count)
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cycle count not found"})
+		return
+	case errors.Is(err, inventory.ErrCycleCountStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": "Cycle count is not open"})
+		return
+	case errors.Is(err, inventory.ErrUncounted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Every product must be counted before approval"})
+		return
+	case err != nil:
+		inventoryError(c, err, message)
+		return
+	}
+
+	preloadCycleCount(database.DB).First(&count, count.ID)
+	c.JSON(http.StatusOK, count)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCycleCountPostsVariances(t *testing.T) {
+	db := testDB(t)
+
+	f := newSaleFixture(t, db, 10, 4)
+	first, second := f.products[0], f.products[1]
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.POST("/cycle-counts", CreateCycleCount)
+	router.PUT("/cycle-counts/:id/counts", RecordCounts)
+	router.POST("/cycle-counts/:id/approve", ApproveCycleCount)
+	call := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	var count models.CycleCount
+	if code := call(http.MethodPost, "/cycle-counts", CycleCountRequest{WarehouseID: f.warehouse.ID}, &count); code != http.StatusCreated {
+		t.Fatalf("Expected the count to open, got %d", code)
+	}
+	t.Cleanup(func() {
+		var adjustmentIDs []uint
+		db.Model(&models.StockAdjustment{}).Where("cycle_count_id = ?", count.ID).Pluck("id", &adjustmentIDs)
+		db.Where("adjustment_id IN ?", adjustmentIDs).Delete(&models.StockAdjustmentLine{})
+		db.Delete(&models.StockAdjustment{}, adjustmentIDs)
+		db.Where("cycle_count_id = ?", count.ID).Delete(&models.CycleCountLine{})
+		db.Delete(&models.CycleCount{}, count.ID)
+	})
+	if len(count.Lines) != 2 || !count.Lines[0].ExpectedQuantity.Equal(qty(10)) || !count.Lines[1].ExpectedQuantity.Equal(qty(4)) {
+		t.Fatalf("Expected quantities should be frozen from stock, got %+v", count.Lines)
+	}
+
+	// Stock sold during the count must not change the expected quantity.
+	sold := models.StockMovement{ProductID: first.ID, WarehouseID: f.warehouse.ID, Type: models.MovementSale, Quantity: qty(-2)}
+	if err := inventory.Post(db, &sold); err != nil {
+		t.Fatalf("Failed to sell stock: %v", err)
+	}
+
+	path := fmt.Sprintf("/cycle-counts/%d", count.ID)
+	nine, four := qty(9), qty(4)
+	code := call(http.MethodPut, path+"/counts", CycleCountCountsRequest{Counts: []CountRequest{{ProductID: first.ID, CountedQuantity: &nine}}}, nil)
+	if code != http.StatusOK {
+		t.Fatalf("Expected the count to be recorded, got %d", code)
+	}
+	if code := call(http.MethodPost, path+"/approve", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Approving with uncounted products should fail, got %d", code)
+	}
+
+	code = call(http.MethodPut, path+"/counts", CycleCountCountsRequest{Counts: []CountRequest{{ProductID: second.ID, CountedQuantity: &four}}}, &count)
+	if code != http.StatusOK || count.Lines[0].Variance == nil || !count.Lines[0].Variance.Equal(qty(-1)) {
+		t.Fatalf("Expected a variance of -1, got %d: %+v", code, count.Lines)
+	}
+
+	if code := call(http.MethodPost, path+"/approve", nil, &count); code != http.StatusOK || count.AdjustmentID == nil {
+		t.Fatalf("Expected the count to be approved with an adjustment, got %d: %+v", code, count)
+	}
+	if got := stockAt(db, first.ID, f.warehouse.ID); !got.Equal(qty(7)) {
+		t.Errorf("Expected 10 - 2 sold - 1 missing = 7, got %s", got)
+	}
+	if got := stockAt(db, second.ID, f.warehouse.ID); !got.Equal(qty(4)) {
+		t.Errorf("A product without variance should be untouched, got %s", got)
+	}
+
+	var adjustment models.StockAdjustment
+	db.Preload("Lines").First(&adjustment, *count.AdjustmentID)
+	if adjustment.Status != models.AdjustmentPosted || adjustment.ReasonCode != models.AdjustmentReasonCountCorrection || len(adjustment.Lines) != 1 {
+		t.Errorf("Unexpected adjustment %+v", adjustment)
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/config"
+	"github.com/edwinjordan/erp_golang/pkg/mailer"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/edwinjordan/erp_golang/pkg/utils"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+var appConfig = &config.Config{}
+
+var appMailer mailer.Mailer = &mailer.LogMailer{}
+
+var passwordPolicy = utils.NewPasswordPolicy(8, nil)
+
+func init() {
+	// Quantities validate as numbers, so binding tags such as gt=0 apply
+	// to them.
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+			value, _ := field.Interface().(measure.Quantity).Decimal().Float64()
+			return value
+		}, measure.Quantity{})
+	}
+}
+
+// Init gives the handlers access to the application configuration. It must
+// be called before the router starts serving requests.
+func Init(cfg *config.Config) {
+	appConfig = cfg
+}
+
+// SetMailer replaces the mailer used for invitations and password resets.
+func SetMailer(m mailer.Mailer) {
+	appMailer = m
+}
+
+// SetPasswordPolicy replaces the policy new passwords are checked against.
+func SetPasswordPolicy(p *utils.PasswordPolicy) {
+	passwordPolicy = p
+}
+
+// sendMail delivers msg in the background so slow mail servers do not hold up
+// the request, and so response times do not reveal whether a message was
+// sent at all.
+func sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := appMailer.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send mail %q to %v: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type StockReceiptRequest struct {
+	ProductID uint `json:"product_id" binding:"required"`
+	// WarehouseID defaults to the default warehouse.
+	WarehouseID uint             `json:"warehouse_id"`
+	Quantity    measure.Quantity `json:"quantity" binding:"required,gt=0"`
+	// UnitID is the unit Quantity is in: the product's base unit, which it
+	// defaults to, or one of the units it is bought in.
+	UnitID *uint  `json:"unit_id"`
+	Reason string `json:"reason"`
+	// LotNumber is required for lot-tracked products and refused for
+	// others. A new lot is created on its first receipt.
+	LotNumber string `json:"lot_number"`
+	// ExpiryDate is the lot's expiry date, as YYYY-MM-DD.
+	ExpiryDate string `json:"expiry_date" binding:"omitempty,datetime=2006-01-02"`
+	// Serials lists one serial number per base unit received, for
+	// serialized products only.
+	Serials []string `json:"serials" binding:"omitempty,dive,required"`
+}
+
+// ReceiveStock books goods received from a supplier into stock, converting
+// a quantity in a purchase unit such as a carton to the base unit.
+func ReceiveStock(c *gin.Context) {
+	var req StockReceiptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := database.DB.Preload("Units.Unit").First(&product, req.ProductID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	quantity, _, message := baseQuantity(product, product.Units, req.UnitID, req.Quantity, false)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	switch {
+	case product.TrackLots && req.LotNumber == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lot_number is required for lot-tracked products"})
+		return
+	case !product.TrackLots && (req.LotNumber != "" || req.ExpiryDate != ""):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product is not lot-tracked"})
+		return
+	}
+	if message := checkSerials(product, quantity, req.Serials, nil); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	var expiryDate *time.Time
+	if req.ExpiryDate != "" {
+		date, _ := time.Parse("2006-01-02", req.ExpiryDate)
+		expiryDate = &date
+	}
+
+	userID := c.GetUint("userID")
+	var movement models.StockMovement
+	err := database.Transaction(database.DB, func(tx *gorm.DB) error {
+		movement = models.StockMovement{
+			ProductID:   req.ProductID,
+			WarehouseID: req.WarehouseID,
+			Type:        models.MovementReceipt,
+			Quantity:    quantity,
+			Reason:      req.Reason,
+			UserID:      &userID,
+		}
+		if req.LotNumber != "" {
+			lot, err := inventory.FindOrCreateLot(tx, req.ProductID, req.LotNumber, expiryDate)
+			if err != nil {
+				return err
+			}
+			movement.LotID = &lot.ID
+		}
+		if err := inventory.Post(tx, &movement); err != nil {
+			return err
+		}
+		return inventory.ReceiveSerials(tx, &movement, req.Serials)
+	})
+	if err != nil {
+		inventoryError(c, err, "Failed to record stock movement")
+		return
+	}
+	c.JSON(http.StatusCreated, movement)
+}
+
+// inventoryError writes the response for an error from the inventory
+// package, using message for unexpected errors.
+func inventoryError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, inventory.ErrProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+	case errors.Is(err, inventory.ErrWarehouseNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+	case errors.Is(err, inventory.ErrNoDefaultWarehouse):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No default warehouse; specify warehouse_id"})
+	case errors.Is(err, inventory.ErrInsufficientStock):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
+	case errors.Is(err, inventory.ErrZeroQuantity):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must not be zero"})
+	case errors.Is(err, inventory.ErrFractionalQuantity):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be whole; the product's unit does not allow fractions"})
+	case errors.Is(err, inventory.ErrHasVariants):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product has variants; stock is kept per variant"})
+	case errors.Is(err, inventory.ErrLotNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+	case errors.Is(err, inventory.ErrLotExpiryMismatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Lot already exists with a different expiry date"})
+	case errors.Is(err, inventory.ErrLotExpired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock; the rest is in expired lots"})
+	case errors.Is(err, inventory.ErrSerialExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "Serial number already exists: " + serialOf(err)})
+	case errors.Is(err, inventory.ErrSerialNotInStock):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Serial number not in stock: " + serialOf(err)})
+	case errors.Is(err, inventory.ErrSerialNotInTransit):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Serial number not in transit: " + serialOf(err)})
+	case errors.Is(err, inventory.ErrSerialCount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Give one serial number per unit of serialized products"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// GetProductMovements lists a product's stock movements, newest first.
+func GetProductMovements(c *gin.Context) {
+	var product models.Product
+	if err := database.DB.First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	query := database.DB.Where("product_id = ?", product.ID).Order("id DESC")
+	if movementType := c.Query("type"); movementType != "" {
+		query = query.Where("type = ?", movementType)
+	}
+	if warehouseID := c.Query("warehouse_id"); warehouseID != "" {
+		query = query.Where("warehouse_id = ?", warehouseID)
+	}
+
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = value
+	}
+
+	var movements []models.StockMovement
+	if err := query.Limit(limit).Find(&movements).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock movements"})
+		return
+	}
+	c.JSON(http.StatusOK, movements)
+}
+
+// GetInventoryConsistency reports products whose stock has drifted from the
+// sum of their movements.
+func GetInventoryConsistency(c *gin.Context) {
+	drifts, err := inventory.CheckConsistency(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check inventory consistency"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"consistent": len(drifts) == 0, "drifts": drifts})
+}
+
+// ReconcileInventory resets drifting stock to the ledger totals.
+func ReconcileInventory(c *gin.Context) {
+	var drifts []inventory.Drift
+	err := database.Transaction(database.DB, func(tx *gorm.DB) error {
+		var err error
+		drifts, err = inventory.Reconcile(tx)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile inventory"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reconciled": drifts})
+}
+
+// GetLowStock lists products at or below their reorder point, per warehouse,
+// with a suggested order quantity. ?warehouse_id limits it to one warehouse.
+func GetLowStock(c *gin.Context) {
+	var warehouseID uint
+	if value := c.Query("warehouse_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse_id"})
+			return
+		}
+		warehouseID = uint(id)
+	}
+
+	policy := inventory.ReorderPolicy{VelocityDays: appConfig.ReorderVelocityDays, CoverDays: appConfig.ReorderCoverDays}
+	items, err := inventory.LowStock(database.DB, policy, warehouseID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch low stock"})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// GetNearExpiry lists lots in stock that expire within ?days (default 30),
+// including lots that have already expired. ?warehouse_id limits it to one
+// warehouse.
+func GetNearExpiry(c *gin.Context) {
+	days := 30
+	if raw := c.Query("days"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 || value > 3650 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 0 and 3650"})
+			return
+		}
+		days = value
+	}
+	var warehouseID uint
+	if value := c.Query("warehouse_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse_id"})
+			return
+		}
+		warehouseID = uint(id)
+	}
+
+	lots, err := inventory.ExpiringLots(database.DB, days, warehouseID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch expiring lots"})
+		return
+	}
+	c.JSON(http.StatusOK, lots)
+}
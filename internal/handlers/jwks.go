@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/edwinjordan/erp_golang/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys other services use to verify access tokens.
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, utils.JWKS())
+}
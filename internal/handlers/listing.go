@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/edwinjordan/erp_golang/internal/listing"
+	"github.com/gin-gonic/gin"
+)
+
+// listError writes the response for a failed listing.Find: 400 for a bad
+// list query parameter, otherwise 500 with message.
+func listError(c *gin.Context, err error, message string) {
+	var paramErr *listing.Error
+	if errors.As(err, &paramErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": paramErr.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
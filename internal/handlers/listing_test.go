@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetProductsPages(t *testing.T) {
+	db := testDB(t)
+	f := newSaleFixture(t, db, 1, 5, 9)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/products", GetProducts)
+	type page struct {
+		Data       []models.Product `json:"data"`
+		Pagination struct {
+			Total      int64   `json:"total"`
+			NextCursor *string `json:"next_cursor"`
+		} `json:"pagination"`
+	}
+	list := func(query url.Values) (page, int) {
+		t.Helper()
+		query.Set("category_id", fmt.Sprint(f.products[0].CategoryID))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products?"+query.Encode(), nil))
+		var p page
+		json.Unmarshal(w.Body.Bytes(), &p)
+		return p, w.Code
+	}
+
+	first, code := list(url.Values{"sort": {"-stock"}, "limit": {"2"}})
+	if code != http.StatusOK || first.Pagination.Total != 3 || len(first.Data) != 2 || first.Pagination.NextCursor == nil {
+		t.Fatalf("Expected a first page of 2 of 3 with a cursor, got %d: %+v", code, first)
+	}
+	if !first.Data[0].Stock.Equal(qty(9)) || !first.Data[1].Stock.Equal(qty(5)) {
+		t.Errorf("Expected the most stocked products first, got %s and %s", first.Data[0].Stock, first.Data[1].Stock)
+	}
+	second, code := list(url.Values{"sort": {"-stock"}, "limit": {"2"}, "cursor": {*first.Pagination.NextCursor}})
+	if code != http.StatusOK || len(second.Data) != 1 || !second.Data[0].Stock.Equal(qty(1)) || second.Pagination.NextCursor != nil {
+		t.Errorf("Expected the last product on the last page, got %d: %+v", code, second)
+	}
+
+	filtered, _ := list(url.Values{"min_stock": {"2"}, "max_stock": {"8"}})
+	if len(filtered.Data) != 1 || filtered.Data[0].ID != f.products[1].ID {
+		t.Errorf("Expected only the product with 5 in stock, got %+v", filtered.Data)
+	}
+	if _, code := list(url.Values{"sort": {"description"}}); code != http.StatusBadRequest {
+		t.Errorf("Sorting by an unlisted field should be refused, got %d", code)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// LotSale is a sale that took stock from a lot.
+type LotSale struct {
+	SaleID      uint             `json:"sale_id"`
+	SaleItemID  uint             `json:"sale_item_id"`
+	WarehouseID uint             `json:"warehouse_id"`
+	TerminalID  *uint            `json:"terminal_id"`
+	Quantity    measure.Quantity `json:"quantity"`
+	SoldAt      time.Time        `json:"sold_at"`
+}
+
+// GetLots lists lots, newest first, optionally filtered by ?product_id and
+// ?lot_number.
+func GetLots(c *gin.Context) {
+	query := database.DB.Order("id DESC")
+	for _, filter := range []string{"product_id", "lot_number"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+
+	var lots []models.Lot
+	if err := query.Find(&lots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lots"})
+		return
+	}
+	c.JSON(http.StatusOK, lots)
+}
+
+// GetLot returns a lot with its stock per warehouse.
+func GetLot(c *gin.Context) {
+	id := c.Param("id")
+	var lot models.Lot
+	err := database.DB.
+		Preload("Levels", func(db *gorm.DB) *gorm.DB { return db.Where("quantity <> 0").Order("warehouse_id") }).
+		Preload("Levels.Warehouse").
+		First(&lot, id).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+		return
+	}
+	c.JSON(http.StatusOK, lot)
+}
+
+// GetLotSales lists the sales that took stock from a lot, oldest first, so
+// a recalled lot can be traced to the tills and customers it reached.
+func GetLotSales(c *gin.Context) {
+	var lot models.Lot
+	if err := database.DB.First(&lot, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+		return
+	}
+
+	sales := []LotSale{}
+	err := database.DB.Table("sale_item_lots sil").
+		Select("s.id AS sale_id, si.id AS sale_item_id, s.warehouse_id, s.terminal_id, sil.quantity, s.created_at AS sold_at").
+		Joins("JOIN sale_items si ON si.id = sil.sale_item_id").
+		Joins("JOIN sales s ON s.id = si.sale_id").
+		Where("sil.lot_id = ?", lot.ID).
+		Order("s.created_at, sil.id").
+		Scan(&sales).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lot sales"})
+		return
+	}
+	c.JSON(http.StatusOK, sales)
+}
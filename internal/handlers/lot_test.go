@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/config"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSalesTakeLotsFirstExpiredFirstOut(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 0)
+	product := f.products[0]
+	db.Model(&product).Update("track_lots", true)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.POST("/receipts", ReceiveStock)
+	router.POST("/sales", CreateSale)
+	router.GET("/lots/:id/sales", GetLotSales)
+	call := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+	receive := func(lotNumber string, days, quantity int) uint {
+		t.Helper()
+		var movement models.StockMovement
+		req := StockReceiptRequest{ProductID: product.ID, WarehouseID: f.warehouse.ID, Quantity: qty(int64(quantity)),
+			LotNumber: lotNumber, ExpiryDate: time.Now().AddDate(0, 0, days).Format("2006-01-02")}
+		if code := call(http.MethodPost, "/receipts", req, &movement); code != http.StatusCreated || movement.LotID == nil {
+			t.Fatalf("Expected lot %s to be received, got %d", lotNumber, code)
+		}
+		return *movement.LotID
+	}
+	sell := func(quantities ...int) (models.Sale, int) {
+		t.Helper()
+		req := CreateSaleRequest{TerminalID: &f.terminal.ID}
+		for _, quantity := range quantities {
+			req.Items = append(req.Items, SaleItemRequest{ProductID: product.ID, Quantity: qty(int64(quantity))})
+		}
+		var sale models.Sale
+		code := call(http.MethodPost, "/sales", req, &sale)
+		return sale, code
+	}
+
+	if code := call(http.MethodPost, "/receipts", StockReceiptRequest{ProductID: product.ID, Quantity: qty(1)}, nil); code != http.StatusBadRequest {
+		t.Errorf("A lot-tracked receipt without a lot should be refused, got %d", code)
+	}
+	expired := receive("EXPIRED", -1, 2)
+	soon := receive("SOON", 10, 3)
+	later := receive("LATER", 60, 5)
+
+	sale, code := sell(1, 3)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected the sale to be created, got %d", code)
+	}
+	taken := map[uint]measure.Quantity{}
+	for _, item := range sale.SaleItems {
+		for _, lot := range item.Lots {
+			taken[lot.LotID] = taken[lot.LotID].Add(lot.Quantity)
+		}
+	}
+	if !taken[expired].IsZero() || !taken[soon].Equal(qty(3)) || !taken[later].Equal(qty(1)) {
+		t.Errorf("Expected 3 from the soonest lot and 1 from the next, skipping the expired one, got %v", taken)
+	}
+
+	if _, code := sell(5); code != http.StatusBadRequest {
+		t.Errorf("Expired lots should not be sold, got %d", code)
+	}
+	appConfig.SellExpiredLots = true
+	if _, code := sell(5); code != http.StatusCreated {
+		t.Errorf("Expected expired lots to sell when allowed, got %d", code)
+	}
+
+	var sales []LotSale
+	if code := call(http.MethodGet, fmt.Sprintf("/lots/%d/sales", soon), nil, &sales); code != http.StatusOK || len(sales) != 1 || sales[0].SaleID != sale.ID {
+		t.Errorf("Expected the lot to trace to the first sale, got %d: %+v", code, sales)
+	}
+
+	receive("EXPIRING", 5, 2)
+	lots, err := inventory.ExpiringLots(db, 30, f.warehouse.ID)
+	if err != nil {
+		t.Fatalf("Failed to list expiring lots: %v", err)
+	}
+	if len(lots) != 1 || lots[0].LotNumber != "EXPIRING" || lots[0].DaysLeft != 5 || lots[0].Expired {
+		t.Errorf("Expected only the lot expiring in 5 days, got %+v", lots)
+	}
+}
+
+func TestLotSalesOfFractionalQuantities(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 0)
+	product := f.products[0]
+	db.Model(&product).Update("track_lots", true)
+	db.Model(&models.Unit{}).Where("id = ?", product.UnitID).Update("allow_fractions", true)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.POST("/receipts", ReceiveStock)
+	router.POST("/sales", CreateSale)
+	router.GET("/lots/:id/sales", GetLotSales)
+	call := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	var movement models.StockMovement
+	receipt := StockReceiptRequest{ProductID: product.ID, WarehouseID: f.warehouse.ID, Quantity: qty(3),
+		LotNumber: "LOOSE", ExpiryDate: time.Now().AddDate(0, 0, 30).Format("2006-01-02")}
+	if code := call(http.MethodPost, "/receipts", receipt, &movement); code != http.StatusCreated || movement.LotID == nil {
+		t.Fatalf("Expected the lot to be received, got %d", code)
+	}
+	sale := CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{
+		{ProductID: product.ID, Quantity: measure.MustParse("1.25")},
+	}}
+	if code := call(http.MethodPost, "/sales", sale, nil); code != http.StatusCreated {
+		t.Fatalf("Expected the sale to be created, got %d", code)
+	}
+
+	var sales []LotSale
+	if code := call(http.MethodGet, fmt.Sprintf("/lots/%d/sales", *movement.LotID), nil, &sales); code != http.StatusOK || len(sales) != 1 {
+		t.Fatalf("Expected the lot to trace to the sale, got %d: %+v", code, sales)
+	}
+	if !sales[0].Quantity.Equal(measure.MustParse("1.25")) {
+		t.Errorf("Expected 1.25 sold from the lot, got %s", sales[0].Quantity)
+	}
+}
+
+func TestTrackLotsNeedsEmptyStockToChange(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 2, 0)
+	stocked, empty := f.products[0], f.products[1]
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.PUT("/products/:id", UpdateProduct)
+	router.POST("/receipts", ReceiveStock)
+	call := func(method, path string, body interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		return w.Code
+	}
+	setTrackLots := func(product models.Product, trackLots bool) int {
+		t.Helper()
+		return call(http.MethodPut, fmt.Sprintf("/products/%d", product.ID), ProductRequest{
+			Name: product.Name, CategoryID: product.CategoryID, UnitID: product.UnitID, Price: &product.Price, TrackLots: trackLots})
+	}
+
+	if code := setTrackLots(stocked, true); code != http.StatusConflict {
+		t.Errorf("Stock received without lots cannot become lot-tracked, got %d", code)
+	}
+	if code := setTrackLots(empty, true); code != http.StatusOK {
+		t.Fatalf("A product without stock can become lot-tracked, got %d", code)
+	}
+
+	receipt := StockReceiptRequest{ProductID: empty.ID, WarehouseID: f.warehouse.ID, Quantity: qty(1),
+		LotNumber: "L1", ExpiryDate: time.Now().AddDate(0, 1, 0).Format("2006-01-02")}
+	if code := call(http.MethodPost, "/receipts", receipt); code != http.StatusCreated {
+		t.Fatalf("Expected the lot to be received, got %d", code)
+	}
+	if code := setTrackLots(empty, false); code != http.StatusConflict {
+		t.Errorf("A product with lot balances cannot stop tracking lots, got %d", code)
+	}
+}
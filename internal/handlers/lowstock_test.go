@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edwinjordan/erp_golang/internal/config"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/notify"
+	"github.com/gin-gonic/gin"
+)
+
+// recordingNotifier keeps the alerts it is given, or fails with err when
+// set.
+type recordingNotifier struct {
+	alerts []notify.Alert
+	err    error
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert notify.Alert) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func TestLowStockSuggestsOrderQuantities(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{ReorderVelocityDays: 30, ReorderCoverDays: 14}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 10, 4)
+	first, second := f.products[0], f.products[1]
+	t.Cleanup(func() { db.Where("product_id IN ?", []uint{first.ID, second.ID}).Delete(&models.LowStockAlert{}) })
+	five, one := qty(5), qty(1)
+	db.Model(&first).Updates(map[string]interface{}{"reorder_point": five, "reorder_quantity": one})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.POST("/sales", CreateSale)
+	router.PUT("/products/:id/stock-levels/:warehouseId", UpdateStockLevelReorder)
+	router.GET("/low-stock", GetLowStock)
+	call := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+	lowStock := func() map[uint]inventory.LowStockItem {
+		t.Helper()
+		var items []inventory.LowStockItem
+		if code := call(http.MethodGet, fmt.Sprintf("/low-stock?warehouse_id=%d", f.warehouse.ID), nil, &items); code != http.StatusOK {
+			t.Fatalf("Expected the report, got %d", code)
+		}
+		byProduct := make(map[uint]inventory.LowStockItem)
+		for _, item := range items {
+			byProduct[item.ProductID] = item
+		}
+		return byProduct
+	}
+
+	if items := lowStock(); len(items) != 0 {
+		t.Fatalf("Nothing should be low yet, got %+v", items)
+	}
+
+	// Selling 6 of the first product leaves 4, below its reorder point of 5.
+	sale := CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{{ProductID: first.ID, Quantity: qty(6)}}}
+	if code := call(http.MethodPost, "/sales", sale, nil); code != http.StatusCreated {
+		t.Fatalf("Expected the sale to succeed, got %d", code)
+	}
+	// The second product has no reorder point of its own, only one at the
+	// warehouse.
+	path := fmt.Sprintf("/products/%d/stock-levels/%d", second.ID, f.warehouse.ID)
+	if code := call(http.MethodPut, path, StockLevelReorderRequest{ReorderPoint: &five, ReorderQuantity: &five}, nil); code != http.StatusOK {
+		t.Fatalf("Expected the override to be saved, got %d", code)
+	}
+
+	items := lowStock()
+	item, ok := items[first.ID]
+	if !ok {
+		t.Fatalf("The first product should be low, got %+v", items)
+	}
+	// 6 sold over 30 days is 0.2 a day, so 14 days of cover need 3 more:
+	// 5 + 3 - 4 on hand = 4.
+	if !item.OnHand.Equal(qty(4)) || !item.ReorderPoint.Equal(qty(5)) || !item.SuggestedQuantity.Equal(qty(4)) {
+		t.Errorf("Unexpected suggestion %+v", item)
+	}
+	// Without sales the override's reorder quantity is the floor.
+	if item := items[second.ID]; !item.ReorderPoint.Equal(qty(5)) || !item.SuggestedQuantity.Equal(qty(5)) {
+		t.Errorf("The override should apply at the warehouse, got %+v", item)
+	}
+
+	notifier := &recordingNotifier{}
+	raised := func() int {
+		t.Helper()
+		notifier.alerts = nil
+		if err := inventory.RaiseLowStockAlerts(context.Background(), db, inventory.ReorderPolicy{VelocityDays: 30, CoverDays: 14}, notifier); err != nil {
+			t.Fatalf("Failed to raise alerts: %v", err)
+		}
+		count := 0
+		for _, alert := range notifier.alerts {
+			for _, item := range alert.Data.([]inventory.LowStockItem) {
+				if item.ProductID == first.ID || item.ProductID == second.ID {
+					count++
+				}
+			}
+		}
+		return count
+	}
+	notifier.err = errors.New("mail server down")
+	if err := inventory.RaiseLowStockAlerts(context.Background(), db, inventory.ReorderPolicy{VelocityDays: 30, CoverDays: 14}, notifier); err == nil {
+		t.Error("A failed notification should be reported")
+	}
+	notifier.err = nil
+	if got := raised(); got != 2 {
+		t.Errorf("Expected both products to be alerted after a failed delivery, got %d", got)
+	}
+	if got := raised(); got != 0 {
+		t.Errorf("Products still low should not be alerted again, got %d", got)
+	}
+
+	f.receive(t, db, first.ID, f.warehouse.ID, 10)
+	raised()
+	sold := models.StockMovement{ProductID: first.ID, WarehouseID: f.warehouse.ID, Type: models.MovementSale, Quantity: qty(-12)}
+	if err := inventory.Post(db, &sold); err != nil {
+		t.Fatalf("Failed to sell stock: %v", err)
+	}
+	if got := raised(); got != 1 {
+		t.Errorf("A product that recovered and dropped again should be alerted, got %d", got)
+	}
+}
@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/oidc"
+	"github.com/edwinjordan/erp_golang/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcRequestTTL  = 10 * time.Minute
+	loginReasonOIDC = "oidc"
+)
+
+var oidcProvider *oidc.Provider
+
+var (
+	errOIDCNoAccount     = errors.New("no account is linked to this identity")
+	errOIDCNoRole        = errors.New("this identity is not allowed to sign in")
+	errOIDCNoEmail       = errors.New("the identity provider did not return an email address")
+	errOIDCEmailTaken    = errors.New("an account with this email address already exists")
+	errOIDCUserDisabled  = errors.New("account is disabled")
+	errOIDCIdentityTaken = errors.New("this identity is linked to another account")
+	errOIDCAlreadyLinked = errors.New("this account is linked to another identity")
+)
+
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// SetOIDCProvider enables single sign-on through p. A nil provider disables
+// it.
+func SetOIDCProvider(p *oidc.Provider) {
+	oidcProvider = p
+}
+
+// OIDCLogin starts single sign-on by redirecting the browser to the identity
+// provider.
+func OIDCLogin(c *gin.Context) {
+	if oidcProvider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	authURL, ok := startOIDCRequest(c, nil)
+	if !ok {
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCLink starts linking an identity at the provider to the current user.
+// The browser is sent to the returned URL and the callback links the
+// identity instead of signing in.
+func OIDCLink(c *gin.Context) {
+	if oidcProvider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	userID := c.MustGet("userID").(uint)
+	authURL, ok := startOIDCRequest(c, &userID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// startOIDCRequest records a pending login, or a link for linkUserID, sets
+// the state cookie and returns the provider's authorization URL. It writes
+// an error response and returns false on failure.
+func startOIDCRequest(c *gin.Context, linkUserID *uint) (string, bool) {
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return "", false
+	}
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return "", false
+	}
+	verifier, err := oidc.GenerateCodeVerifier()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return "", false
+	}
+
+	authURL, err := oidcProvider.AuthCodeURL(c.Request.Context(), state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return "", false
+	}
+
+	// Abandoned logins are never redeemed; prune them while we are here.
+	database.DB.Where("expires_at < ?", time.Now()).Delete(&models.OIDCAuthRequest{})
+
+	request := models.OIDCAuthRequest{
+		StateHash:    utils.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oidcRequestTTL),
+	}
+	if err := database.DB.Create(&request).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return "", false
+	}
+
+	// The cookie ties the callback to the browser that started the login, so
+	// an attacker cannot complete their own login in a victim's browser.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcRequestTTL.Seconds()), "/auth/oidc", "", !appConfig.IsDevelopment(), true)
+	return authURL, true
+}
+
+// OIDCCallback completes single sign-on and responds like /login.
+func OIDCCallback(c *gin.Context) {
+	if oidcProvider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed: " + e})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", !appConfig.IsDevelopment(), true)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid single sign-on state"})
+		return
+	}
+
+	request, ok := redeemOIDCRequest(state)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired single sign-on state"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	token, err := oidcProvider.Exchange(ctx, c.Query("code"), request.CodeVerifier)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		return
+	}
+	claims, err := oidcProvider.VerifyIDToken(ctx, token.IDToken, request.Nonce)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		return
+	}
+
+	if request.LinkUserID != nil {
+		switch err := linkOIDCIdentity(ctx, *request.LinkUserID, claims); {
+		case errors.Is(err, errOIDCIdentityTaken), errors.Is(err, errOIDCAlreadyLinked):
+			c.JSON(http.StatusConflict, gin.H{"error": "Single sign-on refused: " + err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link single sign-on identity"})
+		default:
+			c.JSON(http.StatusOK, gin.H{"message": "Single sign-on identity linked"})
+		}
+		return
+	}
+
+	user, err := oidcUser(ctx, claims)
+	switch {
+	case errors.Is(err, errOIDCEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email address already exists; sign in and link the identity from your account"})
+		return
+	case errors.Is(err, errOIDCUserDisabled):
+		recordLoginAttempt(c, user.Username, &user.ID, false, loginReasonDisabled)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	case errors.Is(err, errOIDCNoAccount), errors.Is(err, errOIDCNoRole), errors.Is(err, errOIDCNoEmail):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Single sign-on refused: " + err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	// Unless the provider is trusted to have checked a second factor, SSO
+	// users face the same TOTP challenge or enrollment as a password login,
+	// and the attempt is only recorded once that step is done.
+	if oidcProviderMFA(claims) {
+		recordLoginAttempt(c, user.Username, &user.ID, true, loginReasonOIDC)
+		response, err := issueTokens(c, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	if !user.TOTPEnabled && !user.Role.RequireMFA {
+		recordLoginAttempt(c, user.Username, &user.ID, true, loginReasonOIDC)
+	}
+	completeLogin(c, user, http.StatusOK)
+}
+
+// oidcProviderMFA reports whether the ID token claims a second factor and
+// OIDC_TRUST_PROVIDER_MFA allows relying on it.
+func oidcProviderMFA(claims oidc.Claims) bool {
+	if !appConfig.OIDCTrustProviderMFA {
+		return false
+	}
+	for _, method := range claims.Strings("amr") {
+		if method == "mfa" {
+			return true
+		}
+	}
+	if acr := claims.String("acr"); acr != "" {
+		for _, value := range appConfig.OIDCMFAACRValues {
+			if acr == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redeemOIDCRequest loads and deletes the pending login for state so it can
+// only complete once.
+func redeemOIDCRequest(state string) (models.OIDCAuthRequest, bool) {
+	var request models.OIDCAuthRequest
+	if err := database.DB.Where("state_hash = ?", utils.HashToken(state)).First(&request).Error; err != nil {
+		return request, false
+	}
+
+	result := database.DB.Where("state_hash = ?", request.StateHash).Delete(&models.OIDCAuthRequest{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return request, false
+	}
+	return request, time.Now().Before(request.ExpiresAt)
+}
+
+// oidcUser finds the local account of an identity, linking an account with
+// the same verified email or provisioning a new one as configured, and keeps
+// its role in line with the role mapping.
+func oidcUser(ctx context.Context, claims oidc.Claims) (models.User, error) {
+	issuer, subject := claims.String("iss"), claims.String("sub")
+
+	mapped, err := oidcMappedRole(claims)
+	if err != nil {
+		return models.User{}, err
+	}
+	roleName := mapped
+	if roleName == "" {
+		roleName = appConfig.OIDCDefaultRole
+	}
+
+	var user models.User
+	err = database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("oidc_issuer = ? AND oidc_subject = ?", issuer, subject).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = linkOrProvisionOIDCUser(tx, claims, roleName, &user)
+		}
+		if err != nil {
+			return err
+		}
+
+		if roleName == "" {
+			return errOIDCNoRole
+		}
+		if mapped != "" {
+			var role models.Role
+			if err := tx.Where("name = ?", mapped).First(&role).Error; err != nil {
+				return err
+			}
+			if user.RoleID != role.ID {
+				if err := tx.Model(&user).Update("role_id", role.ID).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Preload("Role").First(&user, user.ID).Error
+	})
+	if err != nil {
+		return user, err
+	}
+
+	if user.IsDisabled() {
+		return user, errOIDCUserDisabled
+	}
+	return user, nil
+}
+
+func linkOrProvisionOIDCUser(tx *gorm.DB, claims oidc.Claims, roleName string, user *models.User) error {
+	issuer, subject := claims.String("iss"), claims.String("sub")
+	email := strings.TrimSpace(claims.String("email"))
+	if email == "" {
+		return errOIDCNoEmail
+	}
+
+	err := tx.Where("LOWER(email) = ?", strings.ToLower(email)).First(user).Error
+	if err == nil {
+		// Only a verified address proves the identity owns the account, and
+		// even then linking is opt-in: it hands the account to whoever holds
+		// the address at the provider. Accounts protected by TOTP are never
+		// linked this way, or SSO would become a way around the second factor.
+		if !appConfig.OIDCAutoLink || !claims.Bool("email_verified") || user.OIDCSubject != nil || user.TOTPEnabled {
+			return errOIDCEmailTaken
+		}
+		var role models.Role
+		if err := tx.First(&role, user.RoleID).Error; err != nil {
+			return err
+		}
+		if role.RequireMFA {
+			return errOIDCEmailTaken
+		}
+		return tx.Model(user).Updates(map[string]interface{}{"oidc_issuer": issuer, "oidc_subject": subject}).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if !appConfig.OIDCAutoProvision {
+		return errOIDCNoAccount
+	}
+	if roleName == "" {
+		return errOIDCNoRole
+	}
+
+	var role models.Role
+	if err := tx.Where("name = ?", roleName).First(&role).Error; err != nil {
+		return err
+	}
+
+	username, err := availableUsername(tx, oidcUsername(claims))
+	if err != nil {
+		return err
+	}
+
+	// SSO users sign in through the provider; nobody knows this password.
+	placeholder, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	*user = models.User{
+		Username:    username,
+		Email:       email,
+		RoleID:      role.ID,
+		OIDCIssuer:  &issuer,
+		OIDCSubject: &subject,
+	}
+	if err := user.HashPassword(placeholder); err != nil {
+		return err
+	}
+	return tx.Create(user).Error
+}
+
+// linkOIDCIdentity links the identity in claims to a signed-in user. Role
+// mapping is left to the next single sign-on.
+func linkOIDCIdentity(ctx context.Context, userID uint, claims oidc.Claims) error {
+	issuer, subject := claims.String("iss"), claims.String("sub")
+
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var owner models.User
+		err := tx.Where("oidc_issuer = ? AND oidc_subject = ?", issuer, subject).First(&owner).Error
+		if err == nil && owner.ID != userID {
+			return errOIDCIdentityTaken
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.OIDCSubject != nil && (*user.OIDCSubject != subject || user.OIDCIssuer == nil || *user.OIDCIssuer != issuer) {
+			return errOIDCAlreadyLinked
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{"oidc_issuer": issuer, "oidc_subject": subject}).Error
+	})
+}
+
+// oidcMappedRole returns the role of the first mapping entry whose value is
+// in the user's role claim, or "" if none matches.
+func oidcMappedRole(claims oidc.Claims) (string, error) {
+	mappings, err := appConfig.OIDCRoleMappings()
+	if err != nil {
+		return "", err
+	}
+
+	values := make(map[string]struct{})
+	for _, value := range claims.Strings(appConfig.OIDCRoleClaim) {
+		values[value] = struct{}{}
+	}
+	for _, mapping := range mappings {
+		if _, ok := values[mapping.Value]; ok {
+			return mapping.Role, nil
+		}
+	}
+	return "", nil
+}
+
+func oidcUsername(claims oidc.Claims) string {
+	candidate := claims.String("preferred_username")
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(claims.String("email"), "@")
+	}
+	candidate = strings.Trim(usernameUnsafe.ReplaceAllString(candidate, "_"), "_")
+	if candidate == "" {
+		candidate = "sso_user"
+	}
+	return candidate
+}
+
+// availableUsername returns base, or base with a random suffix if base is
+// already taken.
+func availableUsername(tx *gorm.DB, base string) (string, error) {
+	candidate := base
+	for i := 0; i < 5; i++ {
+		var count int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+
+		suffix, err := utils.GenerateRandomToken(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "_" + strings.ToLower(usernameUnsafe.ReplaceAllString(suffix, ""))
+	}
+	return "", errors.New("could not find a free username")
+}
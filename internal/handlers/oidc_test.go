@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/config"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/oidc"
+)
+
+func TestOIDCProviderMFA(t *testing.T) {
+	previous := appConfig
+	t.Cleanup(func() { appConfig = previous })
+
+	withMFA := oidc.Claims{"amr": []interface{}{"pwd", "mfa"}}
+	withACR := oidc.Claims{"amr": []interface{}{"pwd"}, "acr": "urn:example:mfa"}
+	passwordOnly := oidc.Claims{"amr": []interface{}{"pwd"}, "acr": "urn:example:basic"}
+
+	appConfig = &config.Config{}
+	if oidcProviderMFA(withMFA) {
+		t.Error("The provider's amr claim should not be trusted unless configured")
+	}
+
+	appConfig = &config.Config{OIDCTrustProviderMFA: true, OIDCMFAACRValues: []string{"urn:example:mfa"}}
+	if !oidcProviderMFA(withMFA) {
+		t.Error("An amr claim of mfa should satisfy the second factor")
+	}
+	if !oidcProviderMFA(withACR) {
+		t.Error("A configured acr value should satisfy the second factor")
+	}
+	if oidcProviderMFA(passwordOnly) {
+		t.Error("A password-only sign-in should not satisfy the second factor")
+	}
+}
+
+func TestOIDCAutoLink(t *testing.T) {
+	db := testDB(t)
+
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	staff := models.Role{Name: "staff-" + suffix}
+	admin := models.Role{Name: "admin-" + suffix, RequireMFA: true}
+	db.Create(&staff)
+	db.Create(&admin)
+	plain := models.User{Username: "plain-" + suffix, Email: "plain-" + suffix + "@example.com", RoleID: staff.ID}
+	boss := models.User{Username: "boss-" + suffix, Email: "boss-" + suffix + "@example.com", RoleID: admin.ID}
+	for _, user := range []*models.User{&plain, &boss} {
+		user.HashPassword("not-a-real-password")
+		db.Create(user)
+	}
+	t.Cleanup(func() {
+		db.Unscoped().Where("username LIKE ?", "%-"+suffix).Delete(&models.User{})
+		db.Unscoped().Delete(&staff)
+		db.Unscoped().Delete(&admin)
+	})
+
+	previous := appConfig
+	appConfig = &config.Config{OIDCDefaultRole: staff.Name}
+	t.Cleanup(func() { appConfig = previous })
+
+	claimsFor := func(user models.User) oidc.Claims {
+		return oidc.Claims{"iss": "https://idp.example.com", "sub": "sub-" + user.Username, "email": user.Email, "email_verified": true}
+	}
+
+	if _, err := oidcUser(context.Background(), claimsFor(plain)); !errors.Is(err, errOIDCEmailTaken) {
+		t.Errorf("Accounts should not be linked by email unless OIDC_AUTO_LINK is on, got %v", err)
+	}
+
+	appConfig.OIDCAutoLink = true
+	if _, err := oidcUser(context.Background(), claimsFor(boss)); !errors.Is(err, errOIDCEmailTaken) {
+		t.Errorf("Accounts whose role requires MFA should never be linked by email, got %v", err)
+	}
+	user, err := oidcUser(context.Background(), claimsFor(plain))
+	if err != nil || user.ID != plain.ID {
+		t.Errorf("The account should have been linked, got user %d (%v)", user.ID, err)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/middleware"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PermissionRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+func GetPermissions(c *gin.Context) {
+	var permissions []models.Permission
+	if err := database.DB.Order("name").Find(&permissions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch permissions"})
+		return
+	}
+	c.JSON(http.StatusOK, permissions)
+}
+
+func GetPermission(c *gin.Context) {
+	id := c.Param("id")
+	var permission models.Permission
+	if err := database.DB.First(&permission, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+		return
+	}
+	c.JSON(http.StatusOK, permission)
+}
+
+func CreatePermission(c *gin.Context) {
+	var req PermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	permission := models.Permission{
+		Name:        req.Name,
+		Description: req.Description,
+	}
+
+	if err := database.DB.Create(&permission).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create permission"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, permission)
+}
+
+func UpdatePermission(c *gin.Context) {
+	id := c.Param("id")
+	var permission models.Permission
+	if err := database.DB.First(&permission, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+		return
+	}
+
+	var req PermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if permission.IsSystem && req.Name != permission.Name {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Built-in permissions cannot be renamed"})
+		return
+	}
+
+	permission.Name = req.Name
+	permission.Description = req.Description
+
+	if err := database.DB.Save(&permission).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update permission"})
+		return
+	}
+
+	middleware.InvalidateAllPermissions()
+	c.JSON(http.StatusOK, permission)
+}
+
+func DeletePermission(c *gin.Context) {
+	id := c.Param("id")
+	var permission models.Permission
+	if err := database.DB.First(&permission, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+		return
+	}
+
+	if permission.IsSystem {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Built-in permissions cannot be deleted"})
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM role_permissions WHERE permission_id = ?", permission.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&permission).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete permission"})
+		return
+	}
+
+	middleware.InvalidateAllPermissions()
+	c.JSON(http.StatusOK, gin.H{"message": "Permission deleted successfully"})
+}
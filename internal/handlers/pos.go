@@ -1,41 +1,132 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/listing"
 	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/barcode"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/edwinjordan/erp_golang/pkg/money"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SaleItemRequest struct {
-	ProductID uint `json:"product_id" binding:"required"`
-	Quantity  int  `json:"quantity" binding:"required,min=1"`
+	// ProductID or Barcode, an EAN-13 or UPC-A code, names the product.
+	ProductID uint             `json:"product_id" binding:"required_without=Barcode"`
+	Barcode   string           `json:"barcode" binding:"required_without=ProductID"`
+	Quantity  measure.Quantity `json:"quantity" binding:"required,gt=0"`
+	// UnitID is the unit Quantity is in: the product's base unit, which it
+	// defaults to, or one of the units it is sold in.
+	UnitID *uint `json:"unit_id"`
+	// Serials lists the serial number of each unit sold, for serialized
+	// products only.
+	Serials []string `json:"serials" binding:"omitempty,dive,required"`
 }
 
 type CreateSaleRequest struct {
-	Items []SaleItemRequest `json:"items" binding:"required,min=1"`
+	// TerminalID is the till making the sale. Stock is taken from its
+	// warehouse, or from the default warehouse if it is not given.
+	TerminalID *uint `json:"terminal_id"`
+	// ReservationID is a reservation the sale fulfils. Its stock is
+	// released to the sale, and it must be held at the sale's warehouse.
+	ReservationID *uint             `json:"reservation_id"`
+	Items         []SaleItemRequest `json:"items" binding:"required,min=1,dive"`
 }
 
+// preloadSale loads a sale's user and items with their products, units,
+// lots and serial numbers.
+func preloadSale(db *gorm.DB) *gorm.DB {
+	return db.Preload("User").Preload("SaleItems.Product").Preload("SaleItems.Unit").Preload("SaleItems.Lots.Lot").Preload("SaleItems.Serials.SerialNumber")
+}
+
+// saleListing is what GET /api/sales sorts and filters by.
+var saleListing = listing.Spec{
+	Sorts:       map[string]string{"id": "id", "created_at": "created_at", "total": "total"},
+	DefaultSort: "-created_at",
+	Filters: map[string]listing.Filter{
+		"from":         listing.AtLeast("created_at", listing.Time),
+		"to":           listing.Until("created_at"),
+		"user_id":      listing.Equal("user_id", listing.Uint),
+		"terminal_id":  listing.Equal("terminal_id", listing.Uint),
+		"warehouse_id": listing.Equal("warehouse_id", listing.Uint),
+		"min_total":    listing.AtLeast("total", listing.Decimal),
+		"max_total":    listing.AtMost("total", listing.Decimal),
+	},
+	Preload: preloadSale,
+}
+
+// GetSales lists sales a page at a time, newest first by default.
 func GetSales(c *gin.Context) {
 	var sales []models.Sale
-	if err := database.DB.Preload("User").Preload("SaleItems.Product").Find(&sales).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sales"})
+	page, err := listing.Find(c, database.DB, saleListing, &sales)
+	if err != nil {
+		listError(c, err, "Failed to fetch sales")
 		return
 	}
-	c.JSON(http.StatusOK, sales)
+	c.JSON(http.StatusOK, page)
 }
 
 func GetSale(c *gin.Context) {
 	id := c.Param("id")
 	var sale models.Sale
-	if err := database.DB.Preload("User").Preload("SaleItems.Product").First(&sale, id).Error; err != nil {
+	if err := preloadSale(database.DB).First(&sale, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Sale not found"})
 		return
 	}
 	c.JSON(http.StatusOK, sale)
 }
 
+// saleError is returned from the CreateSale transaction to abort it with a
+// client error.
+type saleError struct {
+	status  int
+	message string
+}
+
+func (e *saleError) Error() string {
+	return e.message
+}
+
+// saleWarehouse returns the warehouse a sale takes stock from: the
+// terminal's, or the default warehouse without a terminal.
+func saleWarehouse(tx *gorm.DB, terminalID *uint) (uint, error) {
+	if terminalID == nil {
+		id, err := inventory.DefaultWarehouseID(tx)
+		if errors.Is(err, inventory.ErrNoDefaultWarehouse) {
+			return 0, &saleError{http.StatusBadRequest, "No default warehouse; specify a terminal"}
+		}
+		return id, err
+	}
+
+	var terminal models.Terminal
+	if err := tx.First(&terminal, *terminalID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, &saleError{http.StatusBadRequest, "Terminal not found"}
+		}
+		return 0, err
+	}
+	return terminal.WarehouseID, nil
+}
+
+// CreateSale records a sale and posts a sale movement per product at the
+// terminal's warehouse. Items may be sold in any of the product's sale
+// units; stock is taken in the base unit, and the price of a unit without
+// its own is the product's price times the unit's factor. Only stock that
+// is not reserved can be sold, apart from the stock of the reservation the
+// sale fulfils. Lot-tracked products are taken from the lots that expire
+// first, skipping expired lots unless SELL_EXPIRED_LOTS is set, and each
+// item records its lots. Serialized products must name the serial number of
+// every unit sold. Products are locked in ascending ID order so concurrent
+// sales of overlapping products queue up instead of deadlocking, and the
+// stock decrement is conditional so stock never goes negative.
 func CreateSale(c *gin.Context) {
 	var req CreateSaleRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -49,78 +140,283 @@ func CreateSale(c *gin.Context) {
 		return
 	}
 
-	// Start transaction
-	tx := database.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			if err := tx.Rollback().Error; err != nil {
-				// Log the rollback error but continue with panic
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction rollback failed after panic"})
+	// Scanned items are resolved to their products up front. A product
+	// deleted meanwhile is caught when the products are locked.
+	for i, item := range req.Items {
+		if item.ProductID != 0 || item.Barcode == "" {
+			continue
+		}
+		id, err := findByBarcode(database.DB, item.Barcode)
+		switch {
+		case errors.Is(err, barcode.ErrInvalidFormat), errors.Is(err, barcode.ErrCheckDigit):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid barcode " + item.Barcode + ": " + err.Error()})
+			return
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "No product has barcode " + item.Barcode})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sale"})
+			return
+		}
+		req.Items[i].ProductID = id
+	}
+
+	sold := make(map[uint]bool)
+	productIDs := make([]uint, 0, len(req.Items))
+	for _, item := range req.Items {
+		if !sold[item.ProductID] {
+			sold[item.ProductID] = true
+			productIDs = append(productIDs, item.ProductID)
+		}
+	}
+	sort.Slice(productIDs, func(i, j int) bool { return productIDs[i] < productIDs[j] })
+
+	var sale models.Sale
+	err := database.Transaction(database.DB, func(tx *gorm.DB) error {
+		// The reservation is locked before its products, as everywhere
+		// reservations end.
+		var reservation *models.StockReservation
+		lockIDs := productIDs
+		if req.ReservationID != nil {
+			reservation = &models.StockReservation{}
+			if err := inventory.LockReservation(tx, *req.ReservationID, reservation); err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return &saleError{http.StatusNotFound, "Reservation not found"}
+				}
+				return err
 			}
+			lockIDs = reservationProductIDs(productIDs, reservation)
 		}
-	}()
 
-	var total float64
-	var saleItems []models.SaleItem
+		products := make(map[uint]models.Product, len(productIDs))
+		for _, id := range lockIDs {
+			var product models.Product
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					if !sold[id] {
+						// Reserved, not sold, and deleted since.
+						continue
+					}
+					return &saleError{http.StatusNotFound, "Product not found"}
+				}
+				return err
+			}
+			products[id] = product
+		}
 
-	// Process each item
-	for _, item := range req.Items {
-		var product models.Product
-		if err := tx.First(&product, item.ProductID).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-			return
+		for _, id := range productIDs {
+			if products[id].HasVariants {
+				return &saleError{http.StatusBadRequest, "Choose a variant of product: " + products[id].Name}
+			}
 		}
 
-		// Check stock
-		if product.Stock < item.Quantity {
-			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock for product: " + product.Name})
-			return
+		var productUnits []models.ProductUnit
+		if err := tx.Preload("Unit").Where("product_id IN ?", productIDs).Find(&productUnits).Error; err != nil {
+			return err
+		}
+		units := make(map[uint][]models.ProductUnit, len(productIDs))
+		for _, unit := range productUnits {
+			units[unit.ProductID] = append(units[unit.ProductID], unit)
 		}
 
-		// Calculate subtotal
-		subtotal := product.Price * float64(item.Quantity)
-		total += subtotal
+		// Items are converted to the base unit. The same product may appear
+		// on several lines; stock is checked against the combined quantity.
+		bases := make([]measure.Quantity, len(req.Items))
+		itemUnits := make([]*models.ProductUnit, len(req.Items))
+		quantities := make(map[uint]measure.Quantity, len(productIDs))
+		seen := make(map[uint]map[string]bool)
+		for i, item := range req.Items {
+			product := products[item.ProductID]
+			base, unit, message := baseQuantity(product, units[product.ID], item.UnitID, item.Quantity, true)
+			if message != "" {
+				return &saleError{http.StatusBadRequest, message}
+			}
+			bases[i], itemUnits[i] = base, unit
+			quantities[product.ID] = quantities[product.ID].Add(base)
 
-		saleItem := models.SaleItem{
-			ProductID: product.ID,
-			Quantity:  item.Quantity,
-			Price:     product.Price,
-			Subtotal:  subtotal,
+			if seen[product.ID] == nil {
+				seen[product.ID] = make(map[string]bool)
+			}
+			if message := checkSerials(product, base, item.Serials, seen[product.ID]); message != "" {
+				return &saleError{http.StatusBadRequest, message}
+			}
 		}
-		saleItems = append(saleItems, saleItem)
 
-		// Update stock
-		product.Stock -= item.Quantity
-		if err := tx.Save(&product).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update stock"})
-			return
+		warehouseID, err := saleWarehouse(tx, req.TerminalID)
+		if err != nil {
+			return err
 		}
-	}
 
-	// Create sale
-	sale := models.Sale{
-		UserID:    userID.(uint),
-		Total:     total,
-		SaleItems: saleItems,
-	}
+		if reservation != nil {
+			switch {
+			case reservation.Status != models.ReservationActive:
+				return &saleError{http.StatusConflict, "Reservation is not active"}
+			case !reservation.ExpiresAt.After(time.Now()):
+				return &saleError{http.StatusConflict, "Reservation has expired"}
+			case reservation.WarehouseID != warehouseID:
+				return &saleError{http.StatusBadRequest, "Reservation is held at a different warehouse"}
+			}
+			if err := inventory.EndReservation(tx, reservation, models.ReservationFulfilled); err != nil {
+				return err
+			}
+		}
 
-	if err := tx.Create(&sale).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sale"})
+		// Stock levels only change while their product is locked, so these
+		// quantities hold until the transaction ends.
+		available, err := inventory.Available(tx, warehouseID, productIDs)
+		if err != nil {
+			return err
+		}
+		for _, id := range productIDs {
+			if available[id].Cmp(quantities[id]) < 0 {
+				return &saleError{http.StatusBadRequest, "Insufficient stock for product: " + products[id].Name}
+			}
+		}
+
+		currency := money.DefaultCurrency()
+		total := money.Zero
+		var saleItems []models.SaleItem
+		for i, item := range req.Items {
+			product := products[item.ProductID]
+			unitID, price := product.UnitID, product.Price
+			if unit := itemUnits[i]; unit != nil {
+				unitID = unit.UnitID
+				price = currency.Round(product.Price.Mul(unit.Factor.Decimal()))
+				if unit.Price != nil {
+					price = *unit.Price
+				}
+			}
+			subtotal := currency.Round(price.Mul(item.Quantity.Decimal()))
+			total = total.Add(subtotal)
+
+			saleItems = append(saleItems, models.SaleItem{
+				ProductID:    product.ID,
+				Quantity:     bases[i],
+				UnitID:       &unitID,
+				UnitQuantity: item.Quantity,
+				Price:        price,
+				Subtotal:     subtotal,
+			})
+		}
+
+		sale = models.Sale{
+			UserID:      userID.(uint),
+			TerminalID:  req.TerminalID,
+			WarehouseID: warehouseID,
+			Total:       total,
+			SaleItems:   saleItems,
+		}
+		if err := tx.Create(&sale).Error; err != nil {
+			return err
+		}
+		if reservation != nil {
+			if err := tx.Model(reservation).Update("sale_id", sale.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, id := range productIDs {
+			movements, err := inventory.PostFEFO(tx, &models.StockMovement{
+				ProductID:     id,
+				WarehouseID:   warehouseID,
+				Type:          models.MovementSale,
+				Quantity:      quantities[id].Neg(),
+				ReferenceType: models.ReferenceSale,
+				ReferenceID:   &sale.ID,
+				UserID:        &sale.UserID,
+			}, !appConfig.SellExpiredLots)
+			switch {
+			case errors.Is(err, inventory.ErrInsufficientStock):
+				return &saleError{http.StatusBadRequest, "Insufficient stock for product: " + products[id].Name}
+			case errors.Is(err, inventory.ErrLotExpired):
+				return &saleError{http.StatusBadRequest, "Only expired lots are left for product: " + products[id].Name}
+			case err != nil:
+				return err
+			}
+			if err := recordSaleLots(tx, sale.SaleItems, id, movements); err != nil {
+				return err
+			}
+		}
+
+		for i, item := range req.Items {
+			err := inventory.SellSerials(tx, &sale, &sale.SaleItems[i], item.Serials)
+			if errors.Is(err, inventory.ErrSerialNotInStock) {
+				return &saleError{http.StatusBadRequest, "Serial number not in stock: " + serialOf(err)}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	var saleErr *saleError
+	if errors.As(err, &saleErr) {
+		c.JSON(saleErr.status, gin.H{"error": saleErr.message})
 		return
 	}
-
-	// Commit transaction
-	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sale"})
 		return
 	}
 
 	// Load relations
-	database.DB.Preload("User").Preload("SaleItems.Product").First(&sale, sale.ID)
+	preloadSale(database.DB).First(&sale, sale.ID)
 
 	c.JSON(http.StatusCreated, sale)
 }
+
+// reservationProductIDs returns the sold products together with the
+// reservation's, in ascending order.
+func reservationProductIDs(productIDs []uint, reservation *models.StockReservation) []uint {
+	ids := append([]uint(nil), productIDs...)
+	seen := make(map[uint]bool, len(productIDs))
+	for _, id := range productIDs {
+		seen[id] = true
+	}
+	for _, line := range reservation.Lines {
+		if !seen[line.ProductID] {
+			seen[line.ProductID] = true
+			ids = append(ids, line.ProductID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// recordSaleLots records which lots the product's sale items were taken from,
+// handing out the lot movements to the items in order.
+func recordSaleLots(tx *gorm.DB, items []models.SaleItem, productID uint, movements []models.StockMovement) error {
+	var lots []models.SaleItemLot
+	m, left := 0, measure.Zero
+	if len(movements) > 0 {
+		left = movements[0].Quantity.Neg()
+	}
+	for _, item := range items {
+		if item.ProductID != productID {
+			continue
+		}
+		needed := item.Quantity
+		for needed.IsPositive() && m < len(movements) {
+			quantity := needed
+			if quantity.Cmp(left) > 0 {
+				quantity = left
+			}
+			if movements[m].LotID != nil {
+				lots = append(lots, models.SaleItemLot{SaleItemID: item.ID, LotID: *movements[m].LotID, Quantity: quantity})
+			}
+			needed = needed.Sub(quantity)
+			left = left.Sub(quantity)
+			if left.IsZero() {
+				m++
+				if m < len(movements) {
+					left = movements[m].Quantity.Neg()
+				}
+			}
+		}
+	}
+	if len(lots) == 0 {
+		return nil
+	}
+	return tx.Create(&lots).Error
+}
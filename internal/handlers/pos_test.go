@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/edwinjordan/erp_golang/pkg/money"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB connects to the PostgreSQL database named by TEST_DATABASE_URL, for
+// example "host=localhost user=postgres password=postgres dbname=erp_test
+// sslmode=disable". Tests needing it are skipped when it is not set.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Role{}, &models.Permission{}, &models.User{}, &models.Category{},
+		&models.Unit{}, &models.Warehouse{}, &models.Terminal{}, &models.Product{}, &models.ProductBarcode{}, &models.ProductUnit{}, &models.StockLevel{},
+		&models.LowStockAlert{}, &models.StockMovement{}, &models.Transfer{}, &models.TransferLine{}, &models.TransferLineSerial{},
+		&models.StockAdjustment{}, &models.StockAdjustmentLine{}, &models.StockAdjustmentSerial{}, &models.CycleCount{},
+		&models.CycleCountLine{}, &models.CycleCountSerial{}, &models.StockReservation{}, &models.StockReservationLine{},
+		&models.Lot{}, &models.LotLevel{}, &models.SerialNumber{}, &models.SerialEvent{}, &models.Sale{}, &models.SaleItem{},
+		&models.SaleItemLot{}, &models.SaleItemSerial{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = previous })
+	return db
+}
+
+// saleFixture holds the records created by newSaleFixture.
+type saleFixture struct {
+	user      models.User
+	warehouse models.Warehouse
+	terminal  models.Terminal
+	products  []models.Product
+}
+
+// newSaleFixture creates a user, a warehouse with a terminal and products
+// stocked there at the given levels, and removes them, and any variants,
+// sales, reservations, lots, serial numbers, barcodes, units and movements
+// of them, when the test ends.
+func newSaleFixture(t *testing.T, db *gorm.DB, stocks ...int) saleFixture {
+	t.Helper()
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	var f saleFixture
+
+	role := models.Role{Name: "pos-test-" + suffix}
+	db.Create(&role)
+	f.user = models.User{Username: "pos-test-" + suffix, Email: "pos-test-" + suffix + "@example.com", Password: "x", RoleID: role.ID}
+	db.Create(&f.user)
+	category := models.Category{Name: "pos-test-" + suffix}
+	db.Create(&category)
+	unit := models.Unit{Name: "pos-test-" + suffix}
+	db.Create(&unit)
+	f.warehouse = models.Warehouse{Code: "pos-test-" + suffix, Name: "pos-test-" + suffix}
+	db.Create(&f.warehouse)
+	f.terminal = models.Terminal{Code: "pos-test-" + suffix, Name: "pos-test-" + suffix, WarehouseID: f.warehouse.ID}
+	db.Create(&f.terminal)
+
+	var productIDs []uint
+	for i, stock := range stocks {
+		product := models.Product{Name: fmt.Sprintf("pos-test-%d-%s", i, suffix), Price: money.MustParse("2.50"), CategoryID: category.ID, UnitID: unit.ID}
+		db.Create(&product)
+		if stock > 0 {
+			f.receive(t, db, product.ID, f.warehouse.ID, stock)
+			product.Stock = qty(int64(stock))
+		}
+		f.products = append(f.products, product)
+		productIDs = append(productIDs, product.ID)
+	}
+
+	t.Cleanup(func() {
+		var variantIDs []uint
+		db.Unscoped().Model(&models.Product{}).Where("parent_id IN ?", productIDs).Pluck("id", &variantIDs)
+		productIDs = append(productIDs, variantIDs...)
+		db.Where("product_id IN ?", productIDs).Delete(&models.StockMovement{})
+		db.Where("product_id IN ?", productIDs).Delete(&models.StockLevel{})
+		db.Where("product_id IN ?", productIDs).Delete(&models.StockReservationLine{})
+		db.Where("warehouse_id = ?", f.warehouse.ID).Delete(&models.StockReservation{})
+		lotIDs := db.Model(&models.Lot{}).Select("id").Where("product_id IN ?", productIDs)
+		db.Where("lot_id IN (?)", lotIDs).Delete(&models.SaleItemLot{})
+		db.Where("lot_id IN (?)", lotIDs).Delete(&models.LotLevel{})
+		db.Where("product_id IN ?", productIDs).Delete(&models.Lot{})
+		serialIDs := db.Model(&models.SerialNumber{}).Select("id").Where("product_id IN ?", productIDs)
+		db.Where("serial_number_id IN (?)", serialIDs).Delete(&models.SaleItemSerial{})
+		db.Where("serial_number_id IN (?)", serialIDs).Delete(&models.SerialEvent{})
+		db.Where("product_id IN ?", productIDs).Delete(&models.SerialNumber{})
+		db.Where("product_id IN ?", productIDs).Delete(&models.ProductBarcode{})
+		db.Where("product_id IN ?", productIDs).Delete(&models.ProductUnit{})
+		db.Unscoped().Where("product_id IN ?", productIDs).Delete(&models.SaleItem{})
+		db.Unscoped().Where("user_id = ?", f.user.ID).Delete(&models.Sale{})
+		db.Unscoped().Delete(&models.Product{}, productIDs)
+		db.Unscoped().Delete(&f.terminal)
+		db.Unscoped().Where("code LIKE ?", "pos-test-"+suffix+"%").Delete(&models.Warehouse{})
+		db.Unscoped().Delete(&unit)
+		db.Unscoped().Delete(&category)
+		db.Unscoped().Delete(&f.user)
+		db.Unscoped().Delete(&role)
+	})
+	return f
+}
+
+// receive books quantity of a product into a warehouse.
+func (f saleFixture) receive(t *testing.T, db *gorm.DB, productID, warehouseID uint, quantity int) {
+	t.Helper()
+	err := inventory.Post(db, &models.StockMovement{ProductID: productID, WarehouseID: warehouseID, Type: models.MovementReceipt, Quantity: qty(int64(quantity))})
+	if err != nil {
+		t.Fatalf("Failed to receive stock: %v", err)
+	}
+}
+
+// stockAt returns a product's stock level at a warehouse.
+func stockAt(db *gorm.DB, productID, warehouseID uint) measure.Quantity {
+	var quantity measure.Quantity
+	db.Model(&models.StockLevel{}).Where("product_id = ? AND warehouse_id = ?", productID, warehouseID).Select("quantity").Scan(&quantity)
+	return quantity
+}
+
+// qty returns a quantity of n whole units.
+func qty(n int64) measure.Quantity {
+	return measure.FromInt(n)
+}
+
+func saleRouter(userID uint) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/sales", func(c *gin.Context) { c.Set("userID", userID) }, CreateSale)
+	return router
+}
+
+func TestCreateSaleConcurrentStock(t *testing.T) {
+	db := testDB(t)
+
+	const stock = 20
+	f := newSaleFixture(t, db, stock, stock)
+	first, second := f.products[0], f.products[1]
+	router := saleRouter(f.user.ID)
+
+	// Half of the sales list the products in the opposite order, which would
+	// deadlock without a consistent lock order.
+	const attempts = 60
+	var (
+		wg       sync.WaitGroup
+		start    = make(chan struct{})
+		mu       sync.Mutex
+		statuses = map[int]int{}
+	)
+	for i := 0; i < attempts; i++ {
+		items := []SaleItemRequest{{ProductID: first.ID, Quantity: qty(1)}, {ProductID: second.ID, Quantity: qty(1)}}
+		if i%2 == 1 {
+			items[0], items[1] = items[1], items[0]
+		}
+		body, _ := json.Marshal(CreateSaleRequest{TerminalID: &f.terminal.ID, Items: items})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sales", bytes.NewReader(body)))
+			mu.Lock()
+			statuses[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if statuses[http.StatusCreated] != stock {
+		t.Errorf("Expected %d successful sales, got statuses %v", stock, statuses)
+	}
+	if statuses[http.StatusCreated]+statuses[http.StatusBadRequest] != attempts {
+		t.Errorf("Every sale should either succeed or fail for lack of stock, got statuses %v", statuses)
+	}
+
+	for _, product := range []models.Product{first, second} {
+		var reloaded models.Product
+		db.First(&reloaded, product.ID)
+		if !reloaded.Stock.IsZero() {
+			t.Errorf("Expected %s to be sold out, stock is %s", product.Name, reloaded.Stock)
+		}
+
+		var sold measure.Quantity
+		db.Model(&models.SaleItem{}).Where("product_id = ?", product.ID).Select("COALESCE(SUM(quantity), 0)").Scan(&sold)
+		if !sold.Equal(qty(stock)) {
+			t.Errorf("Expected %d units of %s sold, got %s", stock, product.Name, sold)
+		}
+
+		var movements int64
+		db.Model(&models.StockMovement{}).Where("product_id = ? AND type = ?", product.ID, models.MovementSale).Count(&movements)
+		if movements != stock {
+			t.Errorf("Expected a sale movement per sale of %s, got %d", product.Name, movements)
+		}
+	}
+
+	drifts, err := inventory.CheckConsistency(db)
+	if err != nil {
+		t.Fatalf("Failed to check consistency: %v", err)
+	}
+	for _, drift := range drifts {
+		if drift.ProductID == first.ID || drift.ProductID == second.ID {
+			t.Errorf("Stock should match the ledger, got %+v", drift)
+		}
+	}
+}
+
+func TestCreateSaleCombinesDuplicateLines(t *testing.T) {
+	db := testDB(t)
+
+	f := newSaleFixture(t, db, 3)
+	product := f.products[0]
+	router := saleRouter(f.user.ID)
+
+	body, _ := json.Marshal(CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{
+		{ProductID: product.ID, Quantity: qty(2)},
+		{ProductID: product.ID, Quantity: qty(2)},
+	}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sales", bytes.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Two lines of 2 should exceed a stock of 3, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var reloaded models.Product
+	db.First(&reloaded, product.ID)
+	if !reloaded.Stock.Equal(qty(3)) {
+		t.Errorf("A rejected sale must not change stock, got %s", reloaded.Stock)
+	}
+}
+
+func TestCreateSaleUsesTerminalWarehouse(t *testing.T) {
+	db := testDB(t)
+
+	f := newSaleFixture(t, db, 2)
+	product := f.products[0]
+	other := models.Warehouse{Code: f.warehouse.Code + "-other", Name: f.warehouse.Name + "-other"}
+	db.Create(&other)
+	f.receive(t, db, product.ID, other.ID, 5)
+	router := saleRouter(f.user.ID)
+
+	// The terminal's warehouse has only 2, even though 7 are on hand overall.
+	body, _ := json.Marshal(CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{{ProductID: product.ID, Quantity: qty(3)}}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sales", bytes.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("A sale beyond the terminal's stock should fail, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body, _ = json.Marshal(CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{{ProductID: product.ID, Quantity: qty(2)}}})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sales", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the sale to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if got := stockAt(db, product.ID, f.warehouse.ID); !got.IsZero() {
+		t.Errorf("Expected the terminal's warehouse to be sold out, got %s", got)
+	}
+	if got := stockAt(db, product.ID, other.ID); !got.Equal(qty(5)) {
+		t.Errorf("The other warehouse should be untouched, got %s", got)
+	}
+	var reloaded models.Product
+	db.First(&reloaded, product.ID)
+	if !reloaded.Stock.Equal(qty(5)) {
+		t.Errorf("Expected a total stock of 5, got %s", reloaded.Stock)
+	}
+}
@@ -1,26 +1,209 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/listing"
 	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/edwinjordan/erp_golang/pkg/money"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProductRequest struct {
-	Name        string  `json:"name" binding:"required"`
-	Description string  `json:"description"`
-	CategoryID  uint    `json:"category_id" binding:"required"`
-	UnitID      uint    `json:"unit_id" binding:"required"`
-	Price       float64 `json:"price" binding:"required,min=0"`
-	Stock       int     `json:"stock" binding:"min=0"`
+	// SKU must be unique. A new product without one gets a generated SKU;
+	// an update without one keeps the current SKU.
+	SKU         string `json:"sku" binding:"max=64"`
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	CategoryID  uint   `json:"category_id" binding:"required"`
+	// UnitID is the base unit, which stock is kept in.
+	UnitID uint          `json:"unit_id" binding:"required"`
+	Price  *money.Amount `json:"price" binding:"required"`
+	// Units are the other units the product is bought or sold in. On
+	// update they replace the product's units; leaving them out keeps the
+	// current ones.
+	Units []ProductUnitRequest `json:"units" binding:"omitempty,dive"`
+	// Stock is the opening stock of a new product. Afterwards stock only
+	// changes through stock movements, so an update may repeat the current
+	// value but not change it.
+	Stock *measure.Quantity `json:"stock" binding:"omitempty,min=0"`
+	// ReorderPoint and ReorderQuantity apply at every warehouse without an
+	// override; leaving ReorderPoint out disables low stock checks.
+	ReorderPoint    *measure.Quantity `json:"reorder_point" binding:"omitempty,min=0"`
+	ReorderQuantity *measure.Quantity `json:"reorder_quantity" binding:"omitempty,min=0"`
+	// TrackLots makes receipts name a lot and sales take lots first
+	// expired, first out.
+	TrackLots bool `json:"track_lots"`
+	// Serialized makes receipts and sales name the serial number of every
+	// unit.
+	Serialized bool `json:"serialized"`
+	// Barcodes are EAN-13 or UPC-A codes. On update they replace the
+	// product's barcodes; leaving them out keeps the current ones.
+	Barcodes []string `json:"barcodes"`
 }
 
+type StockLevelReorderRequest struct {
+	// Nil values fall back to the product's reorder settings.
+	ReorderPoint    *measure.Quantity `json:"reorder_point" binding:"omitempty,min=0"`
+	ReorderQuantity *measure.Quantity `json:"reorder_quantity" binding:"omitempty,min=0"`
+}
+
+// stockLevelsShown selects the stock levels listed with a product: those
+// holding stock or carrying a reorder override.
+const stockLevelsShown = "quantity <> 0 OR reorder_point IS NOT NULL OR reorder_quantity IS NOT NULL"
+
+// productListing is what GET /api/products sorts and filters by.
+var productListing = listing.Spec{
+	Sorts:       map[string]string{"id": "id", "name": "name", "price": "price", "stock": "stock", "created_at": "created_at"},
+	DefaultSort: "id",
+	Filters: map[string]listing.Filter{
+		"category_id": listing.Equal("category_id", listing.Uint),
+		"unit_id":     listing.Equal("unit_id", listing.Uint),
+		"min_price":   listing.AtLeast("price", listing.Decimal),
+		"max_price":   listing.AtMost("price", listing.Decimal),
+		"min_stock":   listing.AtLeast("stock", listing.Decimal),
+		"max_stock":   listing.AtMost("stock", listing.Decimal),
+		"parent_id":   listing.Equal("parent_id", listing.Uint),
+	},
+	Preload: preloadProduct,
+}
+
+// GetProducts lists products a page at a time with their total stock and
+// stock per warehouse. With ?warehouse_id only products stocked at that
+// warehouse are listed, each with just that warehouse's stock level. With
+// ?group_variants=true variants are listed under their parents instead of
+// on their own.
 func GetProducts(c *gin.Context) {
+	query := database.DB
+	spec := productListing
+	if c.Query("group_variants") == "true" {
+		if c.Query("warehouse_id") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "group_variants cannot be combined with warehouse_id"})
+			return
+		}
+		query = query.Where("parent_id IS NULL")
+		spec.Preload = func(db *gorm.DB) *gorm.DB { return preloadVariants(preloadProduct(db)) }
+	}
+	if value := c.Query("warehouse_id"); value != "" {
+		warehouseID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse_id"})
+			return
+		}
+		query = query.Where("id IN (?)", database.DB.Model(&models.StockLevel{}).Select("product_id").
+			Where("warehouse_id = ? AND quantity > 0", warehouseID))
+		spec.Preload = func(db *gorm.DB) *gorm.DB {
+			return db.Preload("Category").Preload("Unit").Preload("Units.Unit").
+				Preload("StockLevels", "warehouse_id = ?", warehouseID).Preload("StockLevels.Warehouse").
+				Preload("Barcodes", func(db *gorm.DB) *gorm.DB { return db.Order("id") })
+		}
+	}
+
 	var products []models.Product
-	if err := database.DB.Preload("Category").Preload("Unit").Find(&products).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+	page, err := listing.Find(c, query, spec, &products)
+	if err != nil {
+		listError(c, err, "Failed to fetch products")
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+const (
+	// searchLimit and searchMaxLimit are the default and largest number
+	// of products a search returns.
+	searchLimit    = 20
+	searchMaxLimit = 50
+	// searchSimilarity is the trigram word similarity from which a
+	// mistyped name still matches; pg_trgm's default of 0.6 misses most
+	// typos in short names.
+	searchSimilarity = "0.3"
+)
+
+// searchTerms splits a search into its words, dropping punctuation.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// prefixQuery builds a tsquery matching documents that contain every term,
+// the last one as a prefix since it may still be being typed.
+func prefixQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	copy(parts, terms)
+	parts[len(parts)-1] += ":*"
+	return strings.Join(parts, " & ")
+}
+
+// SearchProducts finds products for ?q, ranked by full-text relevance over
+// name and description plus trigram similarity to the name, so partial and
+// mistyped names still match. It returns at most ?limit products without
+// relations, to be cheap enough to call on every keystroke.
+func SearchProducts(c *gin.Context) {
+	q := c.Query("q")
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	limit := searchLimit
+	if raw := c.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > searchMaxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", searchMaxLimit)})
+			return
+		}
+		limit = value
+	}
+
+	tsquery := prefixQuery(terms)
+	text := strings.Join(terms, " ")
+	products := []models.Product{}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SET LOCAL pg_trgm.word_similarity_threshold = " + searchSimilarity).Error; err != nil {
+			return err
+		}
+		// Exact SKU and barcode matches are looked up on their own indexes and
+		// come first; OR-ing them into the ranked query would scan the table.
+		exact, err := matchIdentifier(tx, strings.TrimSpace(q))
+		if err != nil {
+			return err
+		}
+		if len(exact) > 0 {
+			if err := tx.Order("id").Find(&products, exact).Error; err != nil {
+				return err
+			}
+		}
+		if len(products) >= limit {
+			products = products[:limit]
+			return nil
+		}
+
+		ranked := []models.Product{}
+		query := tx.Model(&models.Product{}).
+			Where("("+database.ProductSearchDocument+") @@ to_tsquery('simple', ?) OR ? <% name", tsquery, text)
+		if len(exact) > 0 {
+			query = query.Where("id NOT IN ?", exact)
+		}
+		err = query.Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "ts_rank(" + database.ProductSearchDocument + ", to_tsquery('simple', ?)) + word_similarity(?, name) DESC, name, id",
+			Vars: []interface{}{tsquery, text},
+		}}).Limit(limit - len(products)).Find(&ranked).Error
+		products = append(products, ranked...)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
 		return
 	}
 	c.JSON(http.StatusOK, products)
@@ -29,7 +212,7 @@ func GetProducts(c *gin.Context) {
 func GetProduct(c *gin.Context) {
 	id := c.Param("id")
 	var product models.Product
-	if err := database.DB.Preload("Category").Preload("Unit").First(&product, id).Error; err != nil {
+	if err := preloadVariants(preloadProduct(database.DB)).First(&product, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
@@ -43,22 +226,74 @@ func CreateProduct(c *gin.Context) {
 		return
 	}
 
+	if !validPrice(c, *req.Price) {
+		return
+	}
+	if req.TrackLots && req.Stock != nil && !req.Stock.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Lot-tracked products cannot have opening stock; receive it with a lot number"})
+		return
+	}
+	if req.Serialized && req.Stock != nil && !req.Stock.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Serialized products cannot have opening stock; receive it with serial numbers"})
+		return
+	}
+	codes, ok := checkIdentifiers(c, req, 0)
+	if !ok {
+		return
+	}
+	units, ok := checkProductUnits(c, req)
+	if !ok {
+		return
+	}
+
 	product := models.Product{
 		Name:        req.Name,
 		Description: req.Description,
 		CategoryID:  req.CategoryID,
 		UnitID:      req.UnitID,
-		Price:       req.Price,
-		Stock:       req.Stock,
+		Price:       *req.Price,
+
+		ReorderPoint:    req.ReorderPoint,
+		ReorderQuantity: req.ReorderQuantity,
+		TrackLots:       req.TrackLots,
+		Serialized:      req.Serialized,
+		Units:           units,
+	}
+	if req.SKU != "" {
+		product.SKU = &req.SKU
+	}
+	for _, code := range codes {
+		product.Barcodes = append(product.Barcodes, models.ProductBarcode{Code: code})
 	}
 
-	if err := database.DB.Create(&product).Error; err != nil {
+	userID := c.GetUint("userID")
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		if product.SKU == nil {
+			if err := tx.Model(&product).Update("sku", models.DefaultSKU(product.ID)).Error; err != nil {
+				return err
+			}
+		}
+		if req.Stock == nil || req.Stock.IsZero() {
+			return nil
+		}
+		return inventory.Post(tx, &models.StockMovement{
+			ProductID: product.ID,
+			Type:      models.MovementReceipt,
+			Quantity:  *req.Stock,
+			Reason:    "Opening stock",
+			UserID:    &userID,
+		})
+	})
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create product"})
 		return
 	}
 
 	// Load relations
-	database.DB.Preload("Category").Preload("Unit").First(&product, product.ID)
+	preloadProduct(database.DB).First(&product, product.ID)
 
 	c.JSON(http.StatusCreated, product)
 }
@@ -77,29 +312,250 @@ func UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	if !validPrice(c, *req.Price) {
+		return
+	}
+
+	if req.Stock != nil && !req.Stock.Equal(product.Stock) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Stock cannot be edited directly; record a stock receipt or adjustment instead"})
+		return
+	}
+	if product.HasVariants && len(req.Barcodes) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Barcodes belong to variants, not their parent"})
+		return
+	}
+	codes, ok := checkIdentifiers(c, req, product.ID)
+	if !ok {
+		return
+	}
+	units, ok := checkProductUnits(c, req)
+	if !ok {
+		return
+	}
+	if req.UnitID != product.UnitID && !changeBaseUnit(c, product, req) {
+		return
+	}
+	// A variant priced like its parent follows the parent's price.
+	if product.ParentID != nil {
+		var parent models.Product
+		if err := database.DB.Unscoped().First(&parent, *product.ParentID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+			return
+		}
+		product.PriceOverride = !req.Price.Equal(parent.Price)
+	}
+
+	if req.SKU != "" {
+		product.SKU = &req.SKU
+	}
 	product.Name = req.Name
 	product.Description = req.Description
 	product.CategoryID = req.CategoryID
 	product.UnitID = req.UnitID
-	product.Price = req.Price
-	product.Stock = req.Stock
+	product.Price = *req.Price
+	product.ReorderPoint = req.ReorderPoint
+	product.ReorderQuantity = req.ReorderQuantity
+	product.TrackLots = req.TrackLots
+	product.Serialized = req.Serialized
 
-	if err := database.DB.Save(&product).Error; err != nil {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTrackingChange(tx, product.ID, req); err != nil {
+			return err
+		}
+		// Stock and reserved are left out so a concurrent sale or
+		// reservation is not overwritten, and the variant fields so
+		// concurrent variant generation is not.
+		if err := tx.Omit("Stock", "Reserved", "HasVariants", "Attributes", "ParentID", "Options").Save(&product).Error; err != nil {
+			return err
+		}
+		if req.Units != nil {
+			if err := replaceProductUnits(tx, product.ID, units); err != nil {
+				return err
+			}
+		}
+		if product.HasVariants {
+			err := tx.Model(&models.Product{}).Where("parent_id = ? AND NOT price_override", product.ID).
+				Update("price", product.Price).Error
+			if err != nil {
+				return err
+			}
+		}
+		if req.Barcodes == nil {
+			return nil
+		}
+		return replaceBarcodes(tx, product.ID, codes)
+	})
+	if errors.Is(err, errSerializedInUse) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Serial tracking cannot be changed while the product has stock or serial numbers in stock"})
+		return
+	}
+	if errors.Is(err, errLotsInUse) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Lot tracking cannot be changed while the product has stock or lot balances"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update product"})
 		return
 	}
 
 	// Load relations
-	database.DB.Preload("Category").Preload("Unit").First(&product, product.ID)
+	preloadProduct(database.DB).First(&product, product.ID)
 
 	c.JSON(http.StatusOK, product)
 }
 
+var (
+	errSerializedInUse = errors.New("product has stock or serial numbers in stock")
+	errLotsInUse       = errors.New("product has stock or lot balances")
+)
+
+// checkTrackingChange locks the product, as posting stock does, and refuses
+// to switch serial or lot tracking while stock exists that was counted the
+// other way.
+func checkTrackingChange(tx *gorm.DB, productID uint, req ProductRequest) error {
+	var current models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "stock", "reserved", "serialized", "track_lots").
+		First(&current, productID).Error; err != nil {
+		return err
+	}
+	hasStock := !current.Stock.IsZero() || !current.Reserved.IsZero()
+
+	if current.Serialized != req.Serialized {
+		if hasStock {
+			return errSerializedInUse
+		}
+		var serials int64
+		if err := tx.Model(&models.SerialNumber{}).Where("product_id = ? AND status = ?", productID, models.SerialInStock).
+			Count(&serials).Error; err != nil {
+			return err
+		}
+		if serials > 0 {
+			return errSerializedInUse
+		}
+	}
+
+	if current.TrackLots != req.TrackLots {
+		if hasStock {
+			return errLotsInUse
+		}
+		var balances int64
+		if err := tx.Model(&models.LotLevel{}).Joins("JOIN lots ON lots.id = lot_levels.lot_id").
+			Where("lots.product_id = ? AND lot_levels.quantity <> 0", productID).Count(&balances).Error; err != nil {
+			return err
+		}
+		if balances > 0 {
+			return errLotsInUse
+		}
+	}
+	return nil
+}
+
+// changeBaseUnit checks that the product's base unit can become the
+// request's: its stock must be expressible in the new unit, and the new unit
+// must not stay one of the product's alternate units. On failure it writes
+// the response and returns false.
+func changeBaseUnit(c *gin.Context, product models.Product, req ProductRequest) bool {
+	var unit models.Unit
+	if err := database.DB.First(&unit, req.UnitID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unit not found"})
+		return false
+	}
+	if !unit.AllowFractions && (!product.Stock.IsWhole() || !product.Reserved.IsWhole()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Product has fractional stock; " + unit.Name + " must allow fractions"})
+		return false
+	}
+	if req.Units == nil {
+		var count int64
+		if err := database.DB.Model(&models.ProductUnit{}).
+			Where("product_id = ? AND unit_id = ?", product.ID, req.UnitID).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+			return false
+		}
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The base unit cannot also be an alternate unit"})
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteProduct deletes a product, with its variants if it is a parent,
+// and frees their barcodes for other products.
 func DeleteProduct(c *gin.Context) {
 	id := c.Param("id")
-	if err := database.DB.Delete(&models.Product{}, id).Error; err != nil {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		ids := tx.Model(&models.Product{}).Select("id").Where("id = ? OR parent_id = ?", id, id)
+		if err := tx.Where("product_id IN (?)", ids).Delete(&models.ProductBarcode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("product_id IN (?)", ids).Delete(&models.ProductUnit{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ? OR parent_id = ?", id, id).Delete(&models.Product{}).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
 }
+
+// UpdateStockLevelReorder sets the reorder point and quantity of a product at
+// one warehouse, overriding the product's own values there.
+func UpdateStockLevelReorder(c *gin.Context) {
+	var req StockLevelReorderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := database.DB.First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	var warehouse models.Warehouse
+	if err := database.DB.First(&warehouse, c.Param("warehouseId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	}
+
+	// The level may not exist yet for a warehouse that has never held the
+	// product; create it empty so the override has somewhere to live.
+	level := models.StockLevel{ProductID: product.ID, WarehouseID: warehouse.ID}
+	err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&level).Error
+	if err == nil {
+		err = database.DB.Model(&models.StockLevel{}).
+			Where("product_id = ? AND warehouse_id = ?", level.ProductID, level.WarehouseID).
+			Updates(map[string]interface{}{"reorder_point": req.ReorderPoint, "reorder_quantity": req.ReorderQuantity}).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update stock level"})
+		return
+	}
+
+	database.DB.Preload("Warehouse").Where("product_id = ? AND warehouse_id = ?", level.ProductID, level.WarehouseID).First(&level)
+	c.JSON(http.StatusOK, level)
+}
+
+// preloadProduct loads a product's category, units and stock levels.
+func preloadProduct(db *gorm.DB) *gorm.DB {
+	return db.Preload("Category").Preload("Unit").Preload("Units.Unit").
+		Preload("StockLevels", stockLevelsShown).Preload("StockLevels.Warehouse").
+		Preload("Barcodes", func(db *gorm.DB) *gorm.DB { return db.Order("id") })
+}
+
+// validPrice writes a 400 response and returns false if price is negative or
+// has more decimal places than the currency's minor unit.
+func validPrice(c *gin.Context, price money.Amount) bool {
+	if price.IsNegative() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Price must not be negative"})
+		return false
+	}
+	currency := money.DefaultCurrency()
+	if !currency.IsRounded(price) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Price has more decimal places than %s allows", currency.Code)})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/edwinjordan/erp_golang/pkg/money"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ProductUnitRequest offers a product in another unit, such as a carton of
+// 12 pieces.
+type ProductUnitRequest struct {
+	UnitID uint `json:"unit_id" binding:"required"`
+	// Factor is the quantity of the product's base unit in one of this unit.
+	Factor      measure.Quantity `json:"factor" binding:"gt=0"`
+	ForPurchase bool             `json:"for_purchase"`
+	ForSale     bool             `json:"for_sale"`
+	// Price is the sale price of one of this unit; without it the unit
+	// sells at the product's price times Factor.
+	Price *money.Amount `json:"price"`
+}
+
+// checkProductUnits checks the request's units against its base unit and
+// returns them as product units. On failure it writes the response and
+// returns false.
+func checkProductUnits(c *gin.Context, req ProductRequest) ([]models.ProductUnit, bool) {
+	ids := []uint{req.UnitID}
+	for _, unit := range req.Units {
+		ids = append(ids, unit.UnitID)
+	}
+	var found []models.Unit
+	if err := database.DB.Where("id IN ?", ids).Find(&found).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check units"})
+		return nil, false
+	}
+	units := make(map[uint]models.Unit, len(found))
+	for _, unit := range found {
+		units[unit.ID] = unit
+	}
+	base, ok := units[req.UnitID]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unit not found"})
+		return nil, false
+	}
+
+	productUnits := make([]models.ProductUnit, 0, len(req.Units))
+	seen := make(map[uint]bool, len(req.Units))
+	for _, unit := range req.Units {
+		var message string
+		switch {
+		case unit.UnitID == req.UnitID:
+			message = "The base unit cannot also be an alternate unit"
+		case seen[unit.UnitID]:
+			message = fmt.Sprintf("Unit %d is given twice", unit.UnitID)
+		case units[unit.UnitID].ID == 0:
+			message = fmt.Sprintf("Unit %d not found", unit.UnitID)
+		case !unit.ForPurchase && !unit.ForSale:
+			message = "Unit " + units[unit.UnitID].Name + " must be for purchase, for sale or both"
+		case !base.AllowFractions && !unit.Factor.IsWhole():
+			message = "Unit " + units[unit.UnitID].Name + " must hold a whole number of " + base.Name
+		}
+		if message != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": message})
+			return nil, false
+		}
+		if unit.Price != nil && !validPrice(c, *unit.Price) {
+			return nil, false
+		}
+		seen[unit.UnitID] = true
+		productUnits = append(productUnits, models.ProductUnit{
+			UnitID:      unit.UnitID,
+			Factor:      unit.Factor,
+			ForPurchase: unit.ForPurchase,
+			ForSale:     unit.ForSale,
+			Price:       unit.Price,
+		})
+	}
+	return productUnits, true
+}
+
+// replaceProductUnits makes units the product's alternate units.
+func replaceProductUnits(tx *gorm.DB, productID uint, units []models.ProductUnit) error {
+	if err := tx.Where("product_id = ?", productID).Delete(&models.ProductUnit{}).Error; err != nil {
+		return err
+	}
+	if len(units) == 0 {
+		return nil
+	}
+	for i := range units {
+		units[i].ID = 0
+		units[i].ProductID = productID
+	}
+	return tx.Create(&units).Error
+}
+
+// baseQuantity converts quantity, given in unit unitID of the product, to
+// the product's base unit. A nil unitID means the base unit. units are the
+// product's alternate units, with their Unit loaded; forSale picks whether
+// the unit must be offered for sale or for purchase. It returns the
+// alternate unit used, nil for the base unit, or a message if the quantity
+// cannot be converted.
+func baseQuantity(product models.Product, units []models.ProductUnit, unitID *uint, quantity measure.Quantity, forSale bool) (measure.Quantity, *models.ProductUnit, string) {
+	if unitID == nil || *unitID == product.UnitID {
+		return quantity, nil, ""
+	}
+	var unit *models.ProductUnit
+	for i := range units {
+		if units[i].UnitID == *unitID {
+			unit = &units[i]
+			break
+		}
+	}
+	switch {
+	case forSale && (unit == nil || !unit.ForSale):
+		return measure.Zero, nil, "Product is not sold in that unit: " + product.Name
+	case !forSale && (unit == nil || !unit.ForPurchase):
+		return measure.Zero, nil, "Product is not bought in that unit: " + product.Name
+	case !unit.Unit.AllowFractions && !quantity.IsWhole():
+		return measure.Zero, nil, "Quantity must be a whole number of " + unit.Unit.Name + ": " + product.Name
+	}
+	base := quantity.Mul(unit.Factor)
+	if !base.IsExact() {
+		return measure.Zero, nil, fmt.Sprintf("Quantity has more than %d decimal places in the base unit: %s", measure.Places, product.Name)
+	}
+	return base, unit, ""
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/config"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/edwinjordan/erp_golang/pkg/money"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+func TestBaseQuantity(t *testing.T) {
+	rice := models.Product{Name: "Rice", UnitID: 1}
+	units := []models.ProductUnit{
+		{UnitID: 2, Unit: models.Unit{Name: "Bag"}, Factor: qty(5), ForSale: true},
+		{UnitID: 3, Unit: models.Unit{Name: "Sack"}, Factor: qty(25), ForPurchase: true},
+		{UnitID: 4, Unit: models.Unit{Name: "Pound", AllowFractions: true}, Factor: measure.MustParse("0.454"), ForSale: true},
+	}
+	unit := func(id uint) *uint { return &id }
+
+	tests := []struct {
+		name     string
+		unitID   *uint
+		quantity string
+		forSale  bool
+		want     string
+		wantUnit uint
+	}{
+		{"base unit by default", nil, "2.5", true, "2.5", 0},
+		{"base unit by ID", unit(1), "3", false, "3", 0},
+		{"sale unit", unit(2), "2", true, "10", 2},
+		{"purchase unit", unit(3), "2", false, "50", 3},
+		{"fractional sale unit", unit(4), "0.5", true, "0.227", 4},
+		{"fraction of a whole unit", unit(2), "1.5", true, "", 0},
+		{"purchase unit sold", unit(3), "1", true, "", 0},
+		{"sale unit bought", unit(2), "1", false, "", 0},
+		{"unknown unit", unit(9), "1", true, "", 0},
+		{"too precise in the base unit", unit(4), "0.25", true, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, used, message := baseQuantity(rice, units, tt.unitID, measure.MustParse(tt.quantity), tt.forSale)
+			if tt.want == "" {
+				if message == "" {
+					t.Errorf("baseQuantity() = %s, want it refused", base)
+				}
+				return
+			}
+			if message != "" || base.String() != tt.want {
+				t.Errorf("baseQuantity() = %s, %q, want %s", base, message, tt.want)
+			}
+			if (used == nil && tt.wantUnit != 0) || (used != nil && used.UnitID != tt.wantUnit) {
+				t.Errorf("baseQuantity() used unit %+v, want %d", used, tt.wantUnit)
+			}
+		})
+	}
+}
+
+func TestQuantityBinding(t *testing.T) {
+	tests := []struct {
+		body string
+		ok   bool
+	}{
+		{`{"product_id": 1, "quantity": 2}`, true},
+		{`{"product_id": 1, "quantity": 1.25}`, true},
+		{`{"product_id": 1, "quantity": "0.5"}`, true},
+		{`{"product_id": 1, "quantity": 0}`, false},
+		{`{"product_id": 1, "quantity": -1}`, false},
+		{`{"product_id": 1, "quantity": 1.2345}`, false},
+		{`{"product_id": 1}`, false},
+	}
+	for _, tt := range tests {
+		var req SaleItemRequest
+		if err := binding.JSON.BindBody([]byte(tt.body), &req); (err == nil) != tt.ok {
+			t.Errorf("Binding %s: got error %v, want ok %v", tt.body, err, tt.ok)
+		}
+	}
+}
+
+func TestSellInUnits(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	// The units are removed after the fixture's products, which use them.
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	kg := models.Unit{Name: "unit-test-kg-" + suffix, AllowFractions: true}
+	bag := models.Unit{Name: "unit-test-bag-" + suffix}
+	sack := models.Unit{Name: "unit-test-sack-" + suffix}
+	for _, unit := range []*models.Unit{&kg, &bag, &sack} {
+		db.Create(unit)
+	}
+	t.Cleanup(func() { db.Unscoped().Delete(&models.Unit{}, []uint{kg.ID, bag.ID, sack.ID}) })
+
+	f := newSaleFixture(t, db, 0)
+	rice := f.products[0]
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.PUT("/products/:id", UpdateProduct)
+	router.PUT("/units/:id", UpdateUnit)
+	router.POST("/receipts", ReceiveStock)
+	router.POST("/sales", CreateSale)
+	call := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	bagPrice := money.MustParse("11.00")
+	update := ProductRequest{Name: rice.Name, CategoryID: rice.CategoryID, UnitID: kg.ID, Price: &rice.Price, Units: []ProductUnitRequest{
+		{UnitID: bag.ID, Factor: qty(5), ForSale: true, Price: &bagPrice},
+		{UnitID: sack.ID, Factor: qty(25), ForPurchase: true},
+	}}
+	path := fmt.Sprintf("/products/%d", rice.ID)
+	var updated models.Product
+	if code := call(http.MethodPut, path, update, &updated); code != http.StatusOK || len(updated.Units) != 2 {
+		t.Fatalf("Expected the product to get its units, got %d: %+v", code, updated.Units)
+	}
+	update.Units = append(update.Units, ProductUnitRequest{UnitID: kg.ID, Factor: qty(1), ForSale: true})
+	if code := call(http.MethodPut, path, update, nil); code != http.StatusBadRequest {
+		t.Errorf("The base unit cannot also be an alternate unit, got %d", code)
+	}
+
+	receipt := StockReceiptRequest{ProductID: rice.ID, WarehouseID: f.warehouse.ID, Quantity: qty(2), UnitID: &sack.ID}
+	if code := call(http.MethodPost, "/receipts", receipt, nil); code != http.StatusCreated {
+		t.Fatalf("Expected 2 sacks to be received, got %d", code)
+	}
+	if got := stockAt(db, rice.ID, f.warehouse.ID); !got.Equal(qty(50)) {
+		t.Fatalf("Expected 2 sacks to be 50 kg, got %s", got)
+	}
+
+	sale := CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{
+		{ProductID: rice.ID, Quantity: measure.MustParse("1.2")},
+		{ProductID: rice.ID, Quantity: qty(2), UnitID: &bag.ID},
+	}}
+	var sold models.Sale
+	if code := call(http.MethodPost, "/sales", sale, &sold); code != http.StatusCreated || len(sold.SaleItems) != 2 {
+		t.Fatalf("Expected the sale to be created, got %d: %+v", code, sold)
+	}
+	loose, bagged := sold.SaleItems[0], sold.SaleItems[1]
+	if !loose.Quantity.Equal(measure.MustParse("1.2")) || loose.Subtotal.String() != "3.00" {
+		t.Errorf("Expected 1.2 kg for 3.00, got %s for %s", loose.Quantity, loose.Subtotal)
+	}
+	if !bagged.Quantity.Equal(qty(10)) || !bagged.UnitQuantity.Equal(qty(2)) || bagged.UnitID == nil || *bagged.UnitID != bag.ID ||
+		!bagged.Price.Equal(bagPrice) || bagged.Subtotal.String() != "22.00" {
+		t.Errorf("Expected 2 bags of 5 kg at the bag price, got %+v", bagged)
+	}
+	if got := stockAt(db, rice.ID, f.warehouse.ID); !got.Equal(measure.MustParse("38.8")) {
+		t.Errorf("Expected 50 - 1.2 - 10 = 38.8 kg left, got %s", got)
+	}
+
+	sale.Items = []SaleItemRequest{{ProductID: rice.ID, Quantity: measure.MustParse("0.5"), UnitID: &bag.ID}}
+	if code := call(http.MethodPost, "/sales", sale, nil); code != http.StatusBadRequest {
+		t.Errorf("Half a bag should be refused, got %d", code)
+	}
+	sale.Items = []SaleItemRequest{{ProductID: rice.ID, Quantity: qty(1), UnitID: &sack.ID}}
+	if code := call(http.MethodPost, "/sales", sale, nil); code != http.StatusBadRequest {
+		t.Errorf("A purchase unit should not be sold, got %d", code)
+	}
+
+	unitPath := fmt.Sprintf("/units/%d", kg.ID)
+	if code := call(http.MethodPut, unitPath, UnitRequest{Name: kg.Name}, nil); code != http.StatusConflict {
+		t.Errorf("A unit holding fractional stock must keep allowing fractions, got %d", code)
+	}
+}
+
+func TestUnitKeepsFractionsForFractionalFactors(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	litre := models.Unit{Name: "unit-test-litre-" + suffix, AllowFractions: true}
+	cup := models.Unit{Name: "unit-test-cup-" + suffix}
+	for _, unit := range []*models.Unit{&litre, &cup} {
+		db.Create(unit)
+	}
+	t.Cleanup(func() { db.Unscoped().Delete(&models.Unit{}, []uint{litre.ID, cup.ID}) })
+
+	f := newSaleFixture(t, db, 0)
+	milk := f.products[0]
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.PUT("/products/:id", UpdateProduct)
+	router.PUT("/units/:id", UpdateUnit)
+	call := func(path string, body interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, path, bytes.NewReader(raw)))
+		return w.Code
+	}
+
+	// Milk has no stock, but a cup is a quarter of a litre.
+	update := ProductRequest{Name: milk.Name, CategoryID: milk.CategoryID, UnitID: litre.ID, Price: &milk.Price, Units: []ProductUnitRequest{
+		{UnitID: cup.ID, Factor: measure.MustParse("0.25"), ForSale: true},
+	}}
+	if code := call(fmt.Sprintf("/products/%d", milk.ID), update); code != http.StatusOK {
+		t.Fatalf("Expected the product to get its units, got %d", code)
+	}
+	if code := call(fmt.Sprintf("/units/%d", litre.ID), UnitRequest{Name: litre.Name}); code != http.StatusConflict {
+		t.Errorf("A unit with alternate units worth a fraction of it must keep allowing fractions, got %d", code)
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ReservationLineRequest struct {
+	ProductID uint             `json:"product_id" binding:"required"`
+	Quantity  measure.Quantity `json:"quantity" binding:"required,gt=0"`
+}
+
+type ReservationRequest struct {
+	// WarehouseID defaults to the default warehouse.
+	WarehouseID uint   `json:"warehouse_id"`
+	Reference   string `json:"reference"`
+	Notes       string `json:"notes"`
+	// ExpiresAt defaults to RESERVATION_TTL from now.
+	ExpiresAt *time.Time               `json:"expires_at"`
+	Lines     []ReservationLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// preloadReservation loads a reservation's warehouse and lines.
+func preloadReservation(db *gorm.DB) *gorm.DB {
+	return db.Preload("Warehouse").
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).Preload("Lines.Product")
+}
+
+// GetReservations lists stock reservations, newest first, optionally
+// filtered by ?status, ?warehouse_id and ?reference.
+func GetReservations(c *gin.Context) {
+	query := preloadReservation(database.DB).Order("id DESC")
+	for _, filter := range []string{"status", "warehouse_id", "reference"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+
+	var reservations []models.StockReservation
+	if err := query.Find(&reservations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reservations"})
+		return
+	}
+	c.JSON(http.StatusOK, reservations)
+}
+
+func GetReservation(c *gin.Context) {
+	id := c.Param("id")
+	var reservation models.StockReservation
+	if err := preloadReservation(database.DB).First(&reservation, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reservation not found"})
+		return
+	}
+	c.JSON(http.StatusOK, reservation)
+}
+
+// CreateReservation holds stock for an unpaid order until it is sold,
+// released or expires.
+func CreateReservation(c *gin.Context) {
+	var req ReservationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	expiresAt := time.Now().Add(appConfig.ReservationTTL)
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	}
+
+	var reservation models.StockReservation
+	err := database.Transaction(database.DB, func(tx *gorm.DB) error {
+		reservation = models.StockReservation{
+			WarehouseID: req.WarehouseID,
+			Reference:   req.Reference,
+			Notes:       req.Notes,
+			ExpiresAt:   expiresAt,
+			CreatedByID: c.GetUint("userID"),
+		}
+		for _, line := range req.Lines {
+			reservation.Lines = append(reservation.Lines, models.StockReservationLine{ProductID: line.ProductID, Quantity: line.Quantity})
+		}
+		return inventory.Reserve(tx, &reservation)
+	})
+	if err != nil {
+		inventoryError(c, err, "Failed to create reservation")
+		return
+	}
+
+	// Load relations
+	preloadReservation(database.DB).First(&reservation, reservation.ID)
+
+	c.JSON(http.StatusCreated, reservation)
+}
+
+// ReleaseReservation cancels an active reservation, making its stock
+// available again.
+func ReleaseReservation(c *gin.Context) {
+	var reservation models.StockReservation
+	err := database.Transaction(database.DB, func(tx *gorm.DB) error {
+		reservation = models.StockReservation{}
+		if err := inventory.LockReservation(tx, c.Param("id"), &reservation); err != nil {
+			return err
+		}
+		return inventory.EndReservation(tx, &reservation, models.ReservationReleased)
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reservation not found"})
+		return
+	case errors.Is(err, inventory.ErrReservationStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": "Reservation is not active"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release reservation"})
+		return
+	}
+
+	preloadReservation(database.DB).First(&reservation, reservation.ID)
+	c.JSON(http.StatusOK, reservation)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/config"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/gin-gonic/gin"
+)
+
+func TestReservationsHoldStockFromSales(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{ReservationTTL: time.Hour}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 5)
+	product := f.products[0]
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.POST("/sales", CreateSale)
+	router.POST("/reservations", CreateReservation)
+	router.POST("/reservations/:id/release", ReleaseReservation)
+	call := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+	reserve := func(quantity int) (models.StockReservation, int) {
+		t.Helper()
+		var reservation models.StockReservation
+		req := ReservationRequest{WarehouseID: f.warehouse.ID, Lines: []ReservationLineRequest{{ProductID: product.ID, Quantity: qty(int64(quantity))}}}
+		code := call(http.MethodPost, "/reservations", req, &reservation)
+		return reservation, code
+	}
+	sell := func(quantity int, reservationID *uint) int {
+		t.Helper()
+		req := CreateSaleRequest{TerminalID: &f.terminal.ID, ReservationID: reservationID, Items: []SaleItemRequest{{ProductID: product.ID, Quantity: qty(int64(quantity))}}}
+		return call(http.MethodPost, "/sales", req, nil)
+	}
+	available := func() (measure.Quantity, measure.Quantity) {
+		t.Helper()
+		var reloaded models.Product
+		db.First(&reloaded, product.ID)
+		return reloaded.Stock, reloaded.Available
+	}
+
+	held, code := reserve(3)
+	if code != http.StatusCreated || held.Status != models.ReservationActive {
+		t.Fatalf("Expected the reservation to be created, got %d: %+v", code, held)
+	}
+	if stock, avail := available(); !stock.Equal(qty(5)) || !avail.Equal(qty(2)) {
+		t.Errorf("Expected 5 on hand and 2 available, got %s and %s", stock, avail)
+	}
+
+	if code := sell(3, nil); code != http.StatusBadRequest {
+		t.Errorf("Reserved stock should not be sold to others, got %d", code)
+	}
+	if code := sell(2, nil); code != http.StatusCreated {
+		t.Fatalf("Expected the unreserved stock to sell, got %d", code)
+	}
+	if _, code := reserve(1); code != http.StatusBadRequest {
+		t.Errorf("Nothing is left to reserve, got %d", code)
+	}
+
+	if code := sell(3, &held.ID); code != http.StatusCreated {
+		t.Fatalf("Expected the reservation to be fulfilled, got %d", code)
+	}
+	db.First(&held, held.ID)
+	if held.Status != models.ReservationFulfilled || held.SaleID == nil {
+		t.Errorf("Expected a fulfilled reservation with its sale, got %+v", held)
+	}
+	if stock, avail := available(); !stock.IsZero() || !avail.IsZero() {
+		t.Errorf("Expected nothing left, got %s on hand and %s available", stock, avail)
+	}
+	if code := sell(1, &held.ID); code != http.StatusConflict {
+		t.Errorf("A fulfilled reservation cannot be used again, got %d", code)
+	}
+
+	f.receive(t, db, product.ID, f.warehouse.ID, 4)
+	released, _ := reserve(1)
+	path := fmt.Sprintf("/reservations/%d/release", released.ID)
+	if code := call(http.MethodPost, path, nil, nil); code != http.StatusOK {
+		t.Errorf("Expected the reservation to be released, got %d", code)
+	}
+	if code := call(http.MethodPost, path, nil, nil); code != http.StatusConflict {
+		t.Errorf("Releasing twice should conflict, got %d", code)
+	}
+
+	expired, _ := reserve(4)
+	db.Model(&expired).Update("expires_at", time.Now().Add(-time.Minute))
+	if code := sell(4, &expired.ID); code != http.StatusConflict {
+		t.Errorf("An expired reservation cannot be fulfilled, got %d", code)
+	}
+	if _, err := inventory.ReleaseExpired(context.Background(), db); err != nil {
+		t.Fatalf("Failed to release expired reservations: %v", err)
+	}
+	db.First(&expired, expired.ID)
+	if expired.Status != models.ReservationExpired {
+		t.Errorf("Expected the reservation to expire, got %q", expired.Status)
+	}
+	if stock, avail := available(); !stock.Equal(qty(4)) || !avail.Equal(qty(4)) {
+		t.Errorf("Expired holds should be released, got %s on hand and %s available", stock, avail)
+	}
+}
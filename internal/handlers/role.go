@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/middleware"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RoleRequest struct {
+	Name          string `json:"name" binding:"required"`
+	Description   string `json:"description"`
+	RequireMFA    bool   `json:"require_mfa"`
+	PermissionIDs []uint `json:"permission_ids"`
+}
+
+type RolePermissionsRequest struct {
+	PermissionIDs []uint `json:"permission_ids" binding:"required,min=1"`
+}
+
+type CloneRoleRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+func GetRoles(c *gin.Context) {
+	var roles []models.Role
+	if err := database.DB.Preload("Permissions").Find(&roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+func GetRole(c *gin.Context) {
+	id := c.Param("id")
+	var role models.Role
+	if err := database.DB.Preload("Permissions").First(&role, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+func CreateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	permissions, ok := findPermissions(c, req.PermissionIDs)
+	if !ok {
+		return
+	}
+
+	role := models.Role{
+		Name:        req.Name,
+		Description: req.Description,
+		RequireMFA:  req.RequireMFA,
+		Permissions: permissions,
+	}
+
+	if err := database.DB.Create(&role).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create role"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole changes a role's details and, when permission_ids is given,
+// replaces its permissions with them.
+func UpdateRole(c *gin.Context) {
+	id := c.Param("id")
+	var role models.Role
+	if err := database.DB.Preload("Permissions").First(&role, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if role.IsSystem && req.Name != role.Name {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Built-in roles cannot be renamed"})
+		return
+	}
+
+	var permissions []models.Permission
+	if req.PermissionIDs != nil {
+		var ok bool
+		if permissions, ok = findPermissions(c, req.PermissionIDs); !ok {
+			return
+		}
+		if role.Name == models.RoleAdmin {
+			kept := uniqueIDs(req.PermissionIDs)
+			for _, permission := range role.Permissions {
+				if _, ok := kept[permission.ID]; !ok {
+					c.JSON(http.StatusForbidden, gin.H{"error": "Permissions of the admin role cannot be removed"})
+					return
+				}
+			}
+		}
+	}
+
+	role.Name = req.Name
+	role.Description = req.Description
+	role.RequireMFA = req.RequireMFA
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Permissions").Save(&role).Error; err != nil {
+			return err
+		}
+		if req.PermissionIDs == nil {
+			return nil
+		}
+		if len(permissions) == 0 {
+			return tx.Model(&role).Association("Permissions").Clear()
+		}
+		return tx.Model(&role).Association("Permissions").Replace(permissions)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	if req.PermissionIDs != nil {
+		middleware.InvalidateRolePermissions(role.ID)
+	}
+	database.DB.Preload("Permissions").First(&role, role.ID)
+
+	c.JSON(http.StatusOK, role)
+}
+
+func DeleteRole(c *gin.Context) {
+	id := c.Param("id")
+	var role models.Role
+	if err := database.DB.First(&role, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	if role.IsSystem {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Built-in roles cannot be deleted"})
+		return
+	}
+
+	var userCount int64
+	database.DB.Model(&models.User{}).Where("role_id = ?", role.ID).Count(&userCount)
+	if userCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Role is still assigned to users"})
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+
+	middleware.InvalidateRolePermissions(role.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// AddRolePermissions attaches permissions to a role, leaving existing ones in
+// place.
+func AddRolePermissions(c *gin.Context) {
+	id := c.Param("id")
+	var role models.Role
+	if err := database.DB.First(&role, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	var req RolePermissionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	permissions, ok := findPermissions(c, req.PermissionIDs)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Model(&role).Association("Permissions").Append(permissions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to attach permissions"})
+		return
+	}
+
+	middleware.InvalidateRolePermissions(role.ID)
+	database.DB.Preload("Permissions").First(&role, role.ID)
+
+	c.JSON(http.StatusOK, role)
+}
+
+// RemoveRolePermission detaches a single permission from a role.
+func RemoveRolePermission(c *gin.Context) {
+	id := c.Param("id")
+	var role models.Role
+	if err := database.DB.First(&role, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	if role.Name == models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permissions of the admin role cannot be removed"})
+		return
+	}
+
+	var permission models.Permission
+	if err := database.DB.First(&permission, c.Param("permissionId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+		return
+	}
+
+	if err := database.DB.Model(&role).Association("Permissions").Delete(&permission); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to detach permission"})
+		return
+	}
+
+	middleware.InvalidateRolePermissions(role.ID)
+	database.DB.Preload("Permissions").First(&role, role.ID)
+
+	c.JSON(http.StatusOK, role)
+}
+
+// CloneRole creates a new, non-built-in role with the same permissions as an
+// existing one.
+func CloneRole(c *gin.Context) {
+	id := c.Param("id")
+	var source models.Role
+	if err := database.DB.Preload("Permissions").First(&source, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	var req CloneRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Description == "" {
+		req.Description = source.Description
+	}
+
+	role := models.Role{
+		Name:        req.Name,
+		Description: req.Description,
+		RequireMFA:  source.RequireMFA,
+		Permissions: source.Permissions,
+	}
+
+	if err := database.DB.Create(&role).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to clone role"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// GetRoleUsers lists the users that hold a role.
+func GetRoleUsers(c *gin.Context) {
+	id := c.Param("id")
+	var role models.Role
+	if err := database.DB.First(&role, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	var users []models.User
+	if err := database.DB.Where("role_id = ?", role.ID).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// findPermissions loads the permissions with the given IDs, writing a 400
+// response and returning false if any of them does not exist.
+func findPermissions(c *gin.Context, ids []uint) ([]models.Permission, bool) {
+	var permissions []models.Permission
+	if len(ids) == 0 {
+		return permissions, true
+	}
+
+	if err := database.DB.Where("id IN ?", ids).Find(&permissions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch permissions"})
+		return nil, false
+	}
+
+	if len(permissions) != len(uniqueIDs(ids)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "One or more permissions do not exist"})
+		return nil, false
+	}
+
+	return permissions, true
+}
+
+func uniqueIDs(ids []uint) map[uint]struct{} {
+	set := make(map[uint]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set
+}
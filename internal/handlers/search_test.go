@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestPrefixQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"lapt", "lapt:*"},
+		{"Wireless  mou", "wireless & mou:*"},
+		{"usb-c: cable!", "usb & c & cable:*"},
+		{"it's & | !", "it & s:*"},
+	}
+	for _, tt := range tests {
+		if got := prefixQuery(searchTerms(tt.q)); got != tt.want {
+			t.Errorf("prefixQuery(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+	if terms := searchTerms(" &!:* "); len(terms) != 0 {
+		t.Errorf("Expected punctuation alone to give no terms, got %v", terms)
+	}
+}
+
+func TestSearchProducts(t *testing.T) {
+	db := testDB(t)
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		t.Skipf("pg_trgm is not available: %v", err)
+	}
+
+	f := newSaleFixture(t, db, 0, 0)
+	laptop, mouse := f.products[0], f.products[1]
+	db.Model(&laptop).Updates(map[string]interface{}{"name": "Laptop Pro 15 " + laptop.Name, "description": "Aluminium notebook"})
+	db.Model(&mouse).Updates(map[string]interface{}{"name": "Wireless Mouse " + mouse.Name, "description": "For the laptop"})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/products/search", SearchProducts)
+	search := func(q string) ([]models.Product, int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/search?"+url.Values{"q": {q}, "limit": {"50"}}.Encode(), nil))
+		var products []models.Product
+		json.Unmarshal(w.Body.Bytes(), &products)
+		return products, w.Code
+	}
+	position := func(products []models.Product, id uint) int {
+		for i, product := range products {
+			if product.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	products, code := search("lapt")
+	if code != http.StatusOK {
+		t.Fatalf("Expected the search to succeed, got %d", code)
+	}
+	if i, j := position(products, laptop.ID), position(products, mouse.ID); i < 0 || j < 0 || i > j {
+		t.Errorf("Expected the laptop, then the mouse describing a laptop, got positions %d and %d", i, j)
+	}
+	if products, _ := search("wireles mose"); position(products, mouse.ID) < 0 {
+		t.Errorf("Expected a mistyped name to find the mouse, got %+v", products)
+	}
+	if products, _ := search("notebook"); position(products, laptop.ID) < 0 {
+		t.Errorf("Expected the description to be searched, got %+v", products)
+	}
+	sku := fmt.Sprintf("SKU-%d", mouse.ID)
+	db.Model(&mouse).Update("sku", sku)
+	if products, _ := search(strings.ToLower(sku)); position(products, mouse.ID) != 0 {
+		t.Errorf("Expected an exact SKU to come first, got %+v", products)
+	}
+	if _, code := search("!!"); code != http.StatusBadRequest {
+		t.Errorf("A search without words should be refused, got %d", code)
+	}
+}
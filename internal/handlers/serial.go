@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SerialReturnRequest struct {
+	// WarehouseID defaults to the warehouse the serial was sold from.
+	WarehouseID uint `json:"warehouse_id"`
+}
+
+// checkSerials returns why serials cannot be received or sold as quantity
+// units of product, or "" if they can: serialized products need one distinct
+// serial per unit and other products none. seen carries the serials already
+// given for the product elsewhere in the same request; it may be nil.
+func checkSerials(product models.Product, quantity measure.Quantity, serials []string, seen map[string]bool) string {
+	if !product.Serialized {
+		if len(serials) > 0 {
+			return "Product is not serialized: " + product.Name
+		}
+		return ""
+	}
+	if !quantity.IsWhole() || len(serials) != quantity.Count() {
+		return "Give one serial number per unit of product: " + product.Name
+	}
+	if seen == nil {
+		seen = make(map[string]bool, len(serials))
+	}
+	for _, serial := range serials {
+		if seen[serial] {
+			return "Duplicate serial number: " + serial
+		}
+		seen[serial] = true
+	}
+	return ""
+}
+
+// serialLine is the product, quantity and serial numbers of a line of a
+// transfer, adjustment or cycle count.
+type serialLine struct {
+	ProductID uint
+	Quantity  measure.Quantity
+	Serials   []string
+}
+
+// checkLineSerials checks the serial numbers of each line with checkSerials,
+// taking a negative quantity as that many units removed. On failure it
+// writes the response and returns false.
+func checkLineSerials(c *gin.Context, lines []serialLine) bool {
+	productIDs := make([]uint, len(lines))
+	for i, line := range lines {
+		productIDs[i] = line.ProductID
+	}
+	var found []models.Product
+	if err := database.DB.Select("id", "name", "serialized").Where("id IN ?", productIDs).Find(&found).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check serial numbers"})
+		return false
+	}
+	products := make(map[uint]models.Product, len(found))
+	for _, product := range found {
+		products[product.ID] = product
+	}
+
+	seen := make(map[uint]map[string]bool)
+	for _, line := range lines {
+		product, ok := products[line.ProductID]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Product not found"})
+			return false
+		}
+		if seen[product.ID] == nil {
+			seen[product.ID] = make(map[string]bool)
+		}
+		quantity := line.Quantity
+		if quantity.IsNegative() {
+			quantity = quantity.Neg()
+		}
+		if message := checkSerials(product, quantity, line.Serials, seen[product.ID]); message != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": message})
+			return false
+		}
+	}
+	return true
+}
+
+// serialOf returns the serial number named by a serial error.
+func serialOf(err error) string {
+	message := err.Error()
+	return message[strings.LastIndex(message, ": ")+2:]
+}
+
+// preloadSerial loads a serial number's history, oldest first.
+func preloadSerial(db *gorm.DB) *gorm.DB {
+	return db.Preload("Events", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).Preload("Events.Warehouse")
+}
+
+// GetSerials looks serial numbers up, with their history, by ?serial and
+// optionally ?product_id, ?status, ?warehouse_id. Without ?serial, serial
+// numbers are listed newest first without history.
+func GetSerials(c *gin.Context) {
+	query := database.DB.Order("id DESC")
+	if serial := c.Query("serial"); serial != "" {
+		query = preloadSerial(query).Where("serial = ?", serial)
+	}
+	for _, filter := range []string{"product_id", "status", "warehouse_id"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+
+	var serials []models.SerialNumber
+	if err := query.Find(&serials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch serial numbers"})
+		return
+	}
+	c.JSON(http.StatusOK, serials)
+}
+
+// GetSerial returns a serial number with its full history: when and where
+// it was received, sold and returned.
+func GetSerial(c *gin.Context) {
+	id := c.Param("id")
+	var serial models.SerialNumber
+	if err := preloadSerial(database.DB).First(&serial, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Serial number not found"})
+		return
+	}
+	c.JSON(http.StatusOK, serial)
+}
+
+// ReturnSerial takes a sold serial number back into stock, such as a
+// customer return or a unit exchanged under warranty.
+func ReturnSerial(c *gin.Context) {
+	var req SerialReturnRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Serial number not found"})
+		return
+	}
+
+	userID := c.GetUint("userID")
+	var serial *models.SerialNumber
+	err = database.Transaction(database.DB, func(tx *gorm.DB) error {
+		var err error
+		serial, err = inventory.ReturnSerial(tx, uint(id), req.WarehouseID, &userID)
+		return err
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Serial number not found"})
+		return
+	case errors.Is(err, inventory.ErrSerialStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": "Serial number is not sold"})
+		return
+	case err != nil:
+		inventoryError(c, err, "Failed to return serial number")
+		return
+	}
+
+	preloadSerial(database.DB).First(serial, serial.ID)
+	c.JSON(http.StatusOK, serial)
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edwinjordan/erp_golang/internal/config"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckSerials(t *testing.T) {
+	serialized := models.Product{Name: "Laptop", Serialized: true}
+	plain := models.Product{Name: "Mouse"}
+
+	tests := []struct {
+		name    string
+		product models.Product
+		serials []string
+		ok      bool
+	}{
+		{"one per unit", serialized, []string{"A", "B"}, true},
+		{"missing serial", serialized, []string{"A"}, false},
+		{"duplicate serial", serialized, []string{"A", "A"}, false},
+		{"plain product", plain, nil, true},
+		{"serials on plain product", plain, []string{"A", "B"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if message := checkSerials(tt.product, qty(2), tt.serials, nil); (message == "") != tt.ok {
+				t.Errorf("checkSerials() = %q, want ok %v", message, tt.ok)
+			}
+		})
+	}
+
+	seen := map[string]bool{}
+	checkSerials(serialized, qty(1), []string{"A"}, seen)
+	if message := checkSerials(serialized, qty(1), []string{"A"}, seen); message == "" {
+		t.Error("A serial given on two lines of the same request should be refused")
+	}
+	if message := checkSerials(serialized, measure.MustParse("1.5"), []string{"A"}, nil); message == "" {
+		t.Error("A fraction of a serialized product should be refused")
+	}
+}
+
+func TestSerialHistory(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 0)
+	product := f.products[0]
+	db.Model(&product).Update("serialized", true)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.POST("/receipts", ReceiveStock)
+	router.POST("/sales", CreateSale)
+	router.GET("/serials", GetSerials)
+	router.POST("/serials/:id/return", ReturnSerial)
+	call := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+	sell := func(serials ...string) (models.Sale, int) {
+		t.Helper()
+		var sale models.Sale
+		req := CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{{ProductID: product.ID, Quantity: qty(int64(len(serials))), Serials: serials}}}
+		code := call(http.MethodPost, "/sales", req, &sale)
+		return sale, code
+	}
+
+	receipt := StockReceiptRequest{ProductID: product.ID, WarehouseID: f.warehouse.ID, Quantity: qty(2), Serials: []string{"SN-1", "SN-2"}}
+	if code := call(http.MethodPost, "/receipts", receipt, nil); code != http.StatusCreated {
+		t.Fatalf("Expected the serials to be received, got %d", code)
+	}
+	receipt.Quantity, receipt.Serials = qty(1), []string{"SN-1"}
+	if code := call(http.MethodPost, "/receipts", receipt, nil); code != http.StatusConflict {
+		t.Errorf("Receiving a serial twice should conflict, got %d", code)
+	}
+
+	if code := call(http.MethodPost, "/sales", CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{{ProductID: product.ID, Quantity: qty(1)}}}, nil); code != http.StatusBadRequest {
+		t.Errorf("A sale without serials should be refused, got %d", code)
+	}
+	if _, code := sell("SN-9"); code != http.StatusBadRequest {
+		t.Errorf("An unknown serial should be refused, got %d", code)
+	}
+	sale, code := sell("SN-1")
+	if code != http.StatusCreated || len(sale.SaleItems) != 1 || len(sale.SaleItems[0].Serials) != 1 ||
+		sale.SaleItems[0].Serials[0].SerialNumber.Serial != "SN-1" {
+		t.Fatalf("Expected the sale to record SN-1, got %d: %+v", code, sale)
+	}
+	if _, code := sell("SN-1"); code != http.StatusBadRequest {
+		t.Errorf("A sold serial cannot be sold again, got %d", code)
+	}
+
+	var serials []models.SerialNumber
+	call(http.MethodGet, "/serials?serial=SN-1&product_id="+fmt.Sprint(product.ID), nil, &serials)
+	if len(serials) != 1 || serials[0].Status != models.SerialSold {
+		t.Fatalf("Expected SN-1 to be sold, got %+v", serials)
+	}
+	path := fmt.Sprintf("/serials/%d/return", serials[0].ID)
+	var returned models.SerialNumber
+	if code := call(http.MethodPost, path, nil, &returned); code != http.StatusOK || returned.Status != models.SerialInStock {
+		t.Fatalf("Expected SN-1 to be returned, got %d: %+v", code, returned)
+	}
+	if code := call(http.MethodPost, path, nil, nil); code != http.StatusConflict {
+		t.Errorf("Returning an unsold serial should conflict, got %d", code)
+	}
+
+	var history []string
+	for _, event := range returned.Events {
+		history = append(history, event.Type)
+		if event.Type != models.SerialEventReceived && (event.ReferenceID == nil || *event.ReferenceID != sale.ID) {
+			t.Errorf("Expected the %s event to reference the sale, got %+v", event.Type, event)
+		}
+	}
+	if fmt.Sprint(history) != "[received sold returned]" {
+		t.Errorf("Expected the full history, got %v", history)
+	}
+	if stock := stockAt(db, product.ID, f.warehouse.ID); !stock.Equal(qty(2)) {
+		t.Errorf("Expected the return to restock the warehouse, got %s", stock)
+	}
+}
+
+func TestSerialsFollowTransfersAndAdjustments(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 0)
+	product := f.products[0]
+	db.Model(&product).Update("serialized", true)
+	store := models.Warehouse{Code: f.warehouse.Code + "-store", Name: f.warehouse.Name + "-store"}
+	db.Create(&store)
+	warehouseIDs := []uint{f.warehouse.ID, store.ID}
+	t.Cleanup(func() {
+		transferIDs := db.Model(&models.Transfer{}).Select("id").Where("from_warehouse_id IN ?", warehouseIDs)
+		transferLineIDs := db.Model(&models.TransferLine{}).Select("id").Where("transfer_id IN (?)", transferIDs)
+		db.Where("transfer_line_id IN (?)", transferLineIDs).Delete(&models.TransferLineSerial{})
+		db.Where("transfer_id IN (?)", transferIDs).Delete(&models.TransferLine{})
+		db.Where("from_warehouse_id IN ?", warehouseIDs).Delete(&models.Transfer{})
+		adjustmentIDs := db.Model(&models.StockAdjustment{}).Select("id").Where("warehouse_id IN ?", warehouseIDs)
+		adjustmentLineIDs := db.Model(&models.StockAdjustmentLine{}).Select("id").Where("adjustment_id IN (?)", adjustmentIDs)
+		db.Where("adjustment_line_id IN (?)", adjustmentLineIDs).Delete(&models.StockAdjustmentSerial{})
+		db.Where("adjustment_id IN (?)", adjustmentIDs).Delete(&models.StockAdjustmentLine{})
+		db.Where("warehouse_id IN ?", warehouseIDs).Delete(&models.StockAdjustment{})
+		countIDs := db.Model(&models.CycleCount{}).Select("id").Where("warehouse_id IN ?", warehouseIDs)
+		countLineIDs := db.Model(&models.CycleCountLine{}).Select("id").Where("cycle_count_id IN (?)", countIDs)
+		db.Where("cycle_count_line_id IN (?)", countLineIDs).Delete(&models.CycleCountSerial{})
+		db.Where("cycle_count_id IN (?)", countIDs).Delete(&models.CycleCountLine{})
+		db.Where("warehouse_id IN ?", warehouseIDs).Delete(&models.CycleCount{})
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.POST("/receipts", ReceiveStock)
+	router.POST("/sales", CreateSale)
+	router.GET("/serials", GetSerials)
+	router.POST("/transfers", CreateTransfer)
+	router.POST("/transfers/:id/ship", ShipTransfer)
+	router.POST("/transfers/:id/receive", ReceiveTransfer)
+	router.POST("/adjustments", CreateAdjustment)
+	router.POST("/adjustments/:id/post", PostAdjustment)
+	router.POST("/cycle-counts", CreateCycleCount)
+	router.PUT("/cycle-counts/:id/counts", RecordCounts)
+	router.POST("/cycle-counts/:id/approve", ApproveCycleCount)
+	call := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+	where := func(serial string) models.SerialNumber {
+		t.Helper()
+		var serials []models.SerialNumber
+		call(http.MethodGet, fmt.Sprintf("/serials?serial=%s&product_id=%d", serial, product.ID), nil, &serials)
+		if len(serials) != 1 {
+			t.Fatalf("Expected to find %s, got %+v", serial, serials)
+		}
+		return serials[0]
+	}
+	inStockAt := func(serial string, warehouseID uint) bool {
+		number := where(serial)
+		return number.Status == models.SerialInStock && number.WarehouseID != nil && *number.WarehouseID == warehouseID
+	}
+	sell := func(serial string) int {
+		req := CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{{ProductID: product.ID, Quantity: qty(1), Serials: []string{serial}}}}
+		return call(http.MethodPost, "/sales", req, nil)
+	}
+
+	receipt := StockReceiptRequest{ProductID: product.ID, WarehouseID: f.warehouse.ID, Quantity: qty(3), Serials: []string{"SN-1", "SN-2", "SN-3"}}
+	if code := call(http.MethodPost, "/receipts", receipt, nil); code != http.StatusCreated || !inStockAt("SN-1", f.warehouse.ID) {
+		t.Fatalf("Expected the serials to be received into the warehouse, got %d", code)
+	}
+
+	request := TransferRequest{FromWarehouseID: f.warehouse.ID, ToWarehouseID: store.ID,
+		Lines: []TransferLineRequest{{ProductID: product.ID, Quantity: qty(2)}}}
+	if code := call(http.MethodPost, "/transfers", request, nil); code != http.StatusBadRequest {
+		t.Errorf("A transfer of a serialized product without serials should be refused, got %d", code)
+	}
+	request.Lines[0].Serials = []string{"SN-1", "SN-2"}
+	var transfer models.Transfer
+	if code := call(http.MethodPost, "/transfers", request, &transfer); code != http.StatusCreated {
+		t.Fatalf("Expected the transfer to be created, got %d", code)
+	}
+	if code := call(http.MethodPost, fmt.Sprintf("/transfers/%d/ship", transfer.ID), nil, nil); code != http.StatusOK {
+		t.Fatalf("Expected the transfer to ship, got %d", code)
+	}
+	if number := where("SN-1"); number.Status != models.SerialInTransit || number.WarehouseID != nil {
+		t.Errorf("Expected SN-1 to be in transit, got %+v", number)
+	}
+	if code := sell("SN-1"); code != http.StatusBadRequest {
+		t.Errorf("A serial in transit cannot be sold, got %d", code)
+	}
+
+	receivePath := fmt.Sprintf("/transfers/%d/receive", transfer.ID)
+	line := TransferReceiptLineRequest{LineID: transfer.Lines[0].ID, Quantity: qty(1), Discrepancy: qty(1), DiscrepancyReason: "Lost",
+		Serials: []string{"SN-3"}, DiscrepancySerials: []string{"SN-2"}}
+	if code := call(http.MethodPost, receivePath, TransferReceiptRequest{Lines: []TransferReceiptLineRequest{line}}, nil); code != http.StatusBadRequest {
+		t.Errorf("A serial not shipped on the line should be refused, got %d", code)
+	}
+	line.Serials = []string{"SN-1"}
+	if code := call(http.MethodPost, receivePath, TransferReceiptRequest{Lines: []TransferReceiptLineRequest{line}}, nil); code != http.StatusOK {
+		t.Fatalf("Expected the transfer to be received, got %d", code)
+	}
+	if !inStockAt("SN-1", store.ID) || where("SN-2").Status != models.SerialWrittenOff {
+		t.Errorf("Expected SN-1 at the store and SN-2 written off, got %+v and %+v", where("SN-1"), where("SN-2"))
+	}
+	if code := sell("SN-1"); code != http.StatusBadRequest {
+		t.Errorf("A serial stocked at another warehouse cannot be sold, got %d", code)
+	}
+
+	adjustment := StockAdjustmentRequest{WarehouseID: f.warehouse.ID, ReasonCode: models.AdjustmentReasonDamage,
+		Lines: []StockAdjustmentLineRequest{{ProductID: product.ID, Quantity: qty(-1)}}}
+	if code := call(http.MethodPost, "/adjustments", adjustment, nil); code != http.StatusBadRequest {
+		t.Errorf("Writing off a serialized product without serials should be refused, got %d", code)
+	}
+	adjustment.Lines[0].Serials = []string{"SN-3"}
+	var drafted models.StockAdjustment
+	if code := call(http.MethodPost, "/adjustments", adjustment, &drafted); code != http.StatusCreated {
+		t.Fatalf("Expected the adjustment to be created, got %d", code)
+	}
+	if code := call(http.MethodPost, fmt.Sprintf("/adjustments/%d/post", drafted.ID), nil, nil); code != http.StatusOK {
+		t.Fatalf("Expected the adjustment to post, got %d", code)
+	}
+	if number := where("SN-3"); number.Status != models.SerialWrittenOff || number.WarehouseID != nil {
+		t.Errorf("Expected SN-3 to be written off, got %+v", number)
+	}
+	if got := stockAt(db, product.ID, f.warehouse.ID); !got.IsZero() {
+		t.Errorf("Expected no stock left at the source, got %s", got)
+	}
+
+	// Counting SN-2 at the store finds it; SN-1, not counted, is written off.
+	var count models.CycleCount
+	if code := call(http.MethodPost, "/cycle-counts", CycleCountRequest{WarehouseID: store.ID, ProductIDs: []uint{product.ID}}, &count); code != http.StatusCreated {
+		t.Fatalf("Expected the count to open, got %d", code)
+	}
+	countPath := fmt.Sprintf("/cycle-counts/%d", count.ID)
+	one := qty(1)
+	counts := CycleCountCountsRequest{Counts: []CountRequest{{ProductID: product.ID, CountedQuantity: &one}}}
+	if code := call(http.MethodPut, countPath+"/counts", counts, nil); code != http.StatusBadRequest {
+		t.Errorf("Counting a serialized product without serials should be refused, got %d", code)
+	}
+	counts.Counts[0].Serials = []string{"SN-2"}
+	if code := call(http.MethodPut, countPath+"/counts", counts, nil); code != http.StatusOK {
+		t.Fatalf("Expected the count to be recorded, got %d", code)
+	}
+	if code := call(http.MethodPost, countPath+"/approve", nil, nil); code != http.StatusOK {
+		t.Fatalf("Expected the count to be approved, got %d", code)
+	}
+	if !inStockAt("SN-2", store.ID) || where("SN-1").Status != models.SerialWrittenOff {
+		t.Errorf("Expected SN-2 found at the store and SN-1 written off, got %+v and %+v", where("SN-2"), where("SN-1"))
+	}
+	if got := stockAt(db, product.ID, store.ID); !got.Equal(qty(1)) {
+		t.Errorf("Expected 1 at the store, got %s", got)
+	}
+}
+
+func TestSerializedNeedsEmptyStockToChange(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 2, 0)
+	stocked, empty := f.products[0], f.products[1]
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.PUT("/products/:id", UpdateProduct)
+	router.POST("/receipts", ReceiveStock)
+	call := func(method, path string, body interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		return w.Code
+	}
+	setSerialized := func(product models.Product, serialized bool) int {
+		t.Helper()
+		return call(http.MethodPut, fmt.Sprintf("/products/%d", product.ID), ProductRequest{
+			Name: product.Name, CategoryID: product.CategoryID, UnitID: product.UnitID, Price: &product.Price, Serialized: serialized})
+	}
+
+	if code := setSerialized(stocked, true); code != http.StatusConflict {
+		t.Errorf("Stock received without serials cannot become serialized, got %d", code)
+	}
+	if code := setSerialized(empty, true); code != http.StatusOK {
+		t.Fatalf("A product without stock can become serialized, got %d", code)
+	}
+
+	receipt := StockReceiptRequest{ProductID: empty.ID, WarehouseID: f.warehouse.ID, Quantity: qty(1), Serials: []string{"SN-1"}}
+	if code := call(http.MethodPost, "/receipts", receipt); code != http.StatusCreated {
+		t.Fatalf("Expected the serial to be received, got %d", code)
+	}
+	if code := setSerialized(empty, false); code != http.StatusConflict {
+		t.Errorf("A product with serials in stock cannot stop being serialized, got %d", code)
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+var errRefreshTokenReused = errors.New("refresh token reused")
+
+// issueTokens creates an access token and a refresh token starting a new
+// token family for the user.
+func issueTokens(c *gin.Context, user models.User) (AuthResponse, error) {
+	familyID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	return issueTokensInFamily(database.DB, c, user, familyID)
+}
+
+func issueTokensInFamily(tx *gorm.DB, c *gin.Context, user models.User, familyID string) (AuthResponse, error) {
+	accessToken, err := utils.GenerateToken(user.ID, user.RoleID)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	refreshToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	record := models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(appConfig.RefreshTokenTTL),
+		UserAgent: c.Request.UserAgent(),
+		IPAddress: c.ClientIP(),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return AuthResponse{}, err
+	}
+
+	return AuthResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(utils.AccessTokenTTL().Seconds()),
+		User:         user,
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. The presented refresh token can only be used once.
+func Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var current models.RefreshToken
+	if err := database.DB.Where("token_hash = ?", utils.HashToken(req.RefreshToken)).First(&current).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	// A revoked token being presented again means it was stolen or replayed;
+	// revoke every token descended from the same login.
+	if current.RevokedAt != nil {
+		revokeRefreshFamily(database.DB, current.FamilyID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Preload("Role").First(&user, current.UserID).Error; err != nil || user.IsDisabled() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	var response AuthResponse
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&current).Where("revoked_at IS NULL").Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+
+		var err error
+		response, err = issueTokensInFamily(tx, c, user, current.FamilyID)
+		return err
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		revokeRefreshFamily(database.DB, current.FamilyID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Logout revokes the access token used for the request and, if given, the
+// refresh token family it belongs to.
+func Logout(c *gin.Context) {
+	var req LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	claims := c.MustGet("claims").(*utils.Claims)
+	if err := revokeAccessToken(claims); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	if req.RefreshToken != "" {
+		var token models.RefreshToken
+		err := database.DB.Where("token_hash = ? AND user_id = ?", utils.HashToken(req.RefreshToken), claims.UserID).
+			First(&token).Error
+		if err == nil {
+			revokeRefreshFamily(database.DB, token.FamilyID)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll ends every session of the current user.
+func LogoutAll(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	if err := revokeAllSessions(database.DB, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
+
+// LogoutUserEverywhere lets an administrator end every session of a user.
+func LogoutUserEverywhere(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := revokeAllSessions(database.DB, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions of the user have been revoked"})
+}
+
+func revokeAccessToken(claims *utils.Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	// Expired revocations no longer matter; prune them while we are here.
+	database.DB.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{})
+
+	return database.DB.Create(&models.RevokedToken{
+		JTI:       claims.ID,
+		UserID:    claims.UserID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}).Error
+}
+
+func revokeRefreshFamily(tx *gorm.DB, familyID string) {
+	tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now())
+}
+
+// revokeAllSessions invalidates every access and refresh token of a user.
+func revokeAllSessions(tx *gorm.DB, userID uint) error {
+	now := time.Now()
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("sessions_revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+	})
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+type TerminalRequest struct {
+	Code        string `json:"code" binding:"required"`
+	Name        string `json:"name" binding:"required"`
+	WarehouseID uint   `json:"warehouse_id" binding:"required"`
+}
+
+func GetTerminals(c *gin.Context) {
+	query := database.DB.Preload("Warehouse").Order("id")
+	if warehouseID := c.Query("warehouse_id"); warehouseID != "" {
+		query = query.Where("warehouse_id = ?", warehouseID)
+	}
+
+	var terminals []models.Terminal
+	if err := query.Find(&terminals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch terminals"})
+		return
+	}
+	c.JSON(http.StatusOK, terminals)
+}
+
+func GetTerminal(c *gin.Context) {
+	id := c.Param("id")
+	var terminal models.Terminal
+	if err := database.DB.Preload("Warehouse").First(&terminal, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Terminal not found"})
+		return
+	}
+	c.JSON(http.StatusOK, terminal)
+}
+
+func CreateTerminal(c *gin.Context) {
+	var req TerminalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !warehouseExists(c, req.WarehouseID) {
+		return
+	}
+
+	terminal := models.Terminal{
+		Code:        req.Code,
+		Name:        req.Name,
+		WarehouseID: req.WarehouseID,
+	}
+
+	if err := database.DB.Create(&terminal).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create terminal"})
+		return
+	}
+
+	// Load relations
+	database.DB.Preload("Warehouse").First(&terminal, terminal.ID)
+
+	c.JSON(http.StatusCreated, terminal)
+}
+
+func UpdateTerminal(c *gin.Context) {
+	id := c.Param("id")
+	var terminal models.Terminal
+	if err := database.DB.First(&terminal, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Terminal not found"})
+		return
+	}
+
+	var req TerminalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !warehouseExists(c, req.WarehouseID) {
+		return
+	}
+
+	terminal.Code = req.Code
+	terminal.Name = req.Name
+	terminal.WarehouseID = req.WarehouseID
+	terminal.Warehouse = models.Warehouse{}
+
+	if err := database.DB.Save(&terminal).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update terminal"})
+		return
+	}
+
+	// Load relations
+	database.DB.Preload("Warehouse").First(&terminal, terminal.ID)
+
+	c.JSON(http.StatusOK, terminal)
+}
+
+func DeleteTerminal(c *gin.Context) {
+	id := c.Param("id")
+	if err := database.DB.Delete(&models.Terminal{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete terminal"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Terminal deleted successfully"})
+}
+
+// warehouseExists writes a 400 response and returns false if there is no
+// warehouse with the given ID.
+func warehouseExists(c *gin.Context, id uint) bool {
+	var count int64
+	database.DB.Model(&models.Warehouse{}).Where("id = ?", id).Count(&count)
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Warehouse not found"})
+		return false
+	}
+	return true
+}
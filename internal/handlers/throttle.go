@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/internal/throttle"
+	"github.com/gin-gonic/gin"
+)
+
+// Login attempt reasons recorded in the audit log.
+const (
+	loginReasonSuccess         = "success"
+	loginReasonUnknownUser     = "unknown_user"
+	loginReasonInvalidPassword = "invalid_password"
+	loginReasonInvalidMFA      = "invalid_mfa_code"
+	loginReasonDisabled        = "disabled"
+	loginReasonThrottled       = "throttled"
+)
+
+var (
+	userLimiter = throttle.NewLimiter(throttle.NewMemoryStore(), throttle.Policy{MaxFailures: 5, LockoutDuration: 15 * time.Minute})
+	ipLimiter   = throttle.NewLimiter(throttle.NewMemoryStore(), throttle.Policy{MaxFailures: 50, LockoutDuration: 15 * time.Minute})
+)
+
+type UnlockRequest struct {
+	IPAddress string `json:"ip_address" binding:"required"`
+}
+
+// SetLoginLimiters replaces the limiters that throttle failed logins per
+// username and per client IP.
+func SetLoginLimiters(user, ip *throttle.Limiter) {
+	userLimiter = user
+	ipLimiter = ip
+}
+
+func userThrottleKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// ipThrottleKey keys the per-IP limiter. Callers pass c.ClientIP(), which
+// only follows X-Forwarded-For from the router's trusted proxies.
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginThrottled writes a 429 response and returns true if the username or
+// the client IP has to wait before trying again. Store errors fail open so an
+// outage of the throttle store does not lock everybody out.
+func loginThrottled(c *gin.Context, username string) bool {
+	wait, _ := userLimiter.RetryAfter(c.Request.Context(), userThrottleKey(username))
+	if ipWait, _ := ipLimiter.RetryAfter(c.Request.Context(), ipThrottleKey(c.ClientIP())); ipWait > wait {
+		wait = ipWait
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	recordLoginAttempt(c, username, nil, false, loginReasonThrottled)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+	return true
+}
+
+// loginFailed counts a failed attempt against the username and the client IP.
+func loginFailed(c *gin.Context, username string, userID *uint, reason string) {
+	userLimiter.Fail(c.Request.Context(), userThrottleKey(username))
+	ipLimiter.Fail(c.Request.Context(), ipThrottleKey(c.ClientIP()))
+	recordLoginAttempt(c, username, userID, false, reason)
+}
+
+// loginSucceeded clears the failures of the username. Failures of the IP are
+// kept so one valid account cannot be used to reset an IP's budget.
+func loginSucceeded(c *gin.Context, user models.User) {
+	userLimiter.Reset(c.Request.Context(), userThrottleKey(user.Username))
+	recordLoginAttempt(c, user.Username, &user.ID, true, loginReasonSuccess)
+}
+
+func recordLoginAttempt(c *gin.Context, username string, userID *uint, success bool, reason string) {
+	database.DB.Create(&models.LoginAttempt{
+		Username:  username,
+		UserID:    userID,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Success:   success,
+		Reason:    reason,
+	})
+}
+
+// UnlockUser lifts a login lockout of a user.
+func UnlockUser(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := userLimiter.Reset(c.Request.Context(), userThrottleKey(user.Username)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
+
+// UnlockIP lifts a login lockout of a client IP.
+func UnlockIP(c *gin.Context) {
+	var req UnlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ipLimiter.Reset(c.Request.Context(), ipThrottleKey(req.IPAddress)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock IP address"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("IP address %s unlocked", req.IPAddress)})
+}
+
+// GetLoginAttempts returns the most recent login attempts, optionally
+// filtered by username, user_id, ip_address and success.
+func GetLoginAttempts(c *gin.Context) {
+	query := database.DB.Order("created_at DESC, id DESC")
+
+	if username := c.Query("username"); username != "" {
+		query = query.Where("LOWER(username) = ?", strings.ToLower(username))
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if ip := c.Query("ip_address"); ip != "" {
+		query = query.Where("ip_address = ?", ip)
+	}
+	if success := c.Query("success"); success != "" {
+		value, err := strconv.ParseBool(success)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "success must be true or false"})
+			return
+		}
+		query = query.Where("success = ?", value)
+	}
+
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = value
+	}
+
+	var attempts []models.LoginAttempt
+	if err := query.Limit(limit).Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login attempts"})
+		return
+	}
+	c.JSON(http.StatusOK, attempts)
+}
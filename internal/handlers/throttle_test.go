@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/internal/throttle"
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoginThrottleIgnoresForwardedForFromUntrustedPeer(t *testing.T) {
+	db := testDB(t)
+	if err := db.AutoMigrate(&models.LoginAttempt{}); err != nil {
+		t.Fatalf("Failed to migrate login attempts: %v", err)
+	}
+
+	previousUser, previousIP := userLimiter, ipLimiter
+	policy := throttle.Policy{MaxFailures: 3, LockoutDuration: time.Minute}
+	SetLoginLimiters(throttle.NewLimiter(throttle.NewMemoryStore(), policy), throttle.NewLimiter(throttle.NewMemoryStore(), policy))
+	t.Cleanup(func() { SetLoginLimiters(previousUser, previousIP) })
+
+	prefix := fmt.Sprintf("throttle-%d-", time.Now().UnixNano())
+	t.Cleanup(func() { db.Where("username LIKE ?", prefix+"%").Delete(&models.LoginAttempt{}) })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies(nil); err != nil {
+		t.Fatalf("SetTrustedProxies: %v", err)
+	}
+	router.POST("/login", Login)
+	login := func(attempt int) int {
+		t.Helper()
+		// A fresh username each time keeps the per-user limiter out of the way.
+		raw, _ := json.Marshal(LoginRequest{Username: fmt.Sprintf("%s%d", prefix, attempt), Password: "wrong"})
+		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "198.51.100.7:50000"
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d", attempt+1))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		if code := login(attempt); code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d should fail with 401, got %d", attempt, code)
+		}
+	}
+	if code := login(3); code != http.StatusTooManyRequests {
+		t.Errorf("A new X-Forwarded-For should not reset the peer's budget, got %d", code)
+	}
+
+	var forged int64
+	db.Model(&models.LoginAttempt{}).Where("username LIKE ? AND ip_address <> ?", prefix+"%", "198.51.100.7").Count(&forged)
+	if forged != 0 {
+		t.Errorf("Login attempts should record the peer address, %d used the forwarded one", forged)
+	}
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TransferLineRequest struct {
+	ProductID uint             `json:"product_id" binding:"required"`
+	Quantity  measure.Quantity `json:"quantity" binding:"required,gt=0"`
+	// Serials names the units shipped, one per unit, for serialized
+	// products.
+	Serials []string `json:"serials"`
+}
+
+type TransferRequest struct {
+	FromWarehouseID uint                  `json:"from_warehouse_id" binding:"required"`
+	ToWarehouseID   uint                  `json:"to_warehouse_id" binding:"required"`
+	Notes           string                `json:"notes"`
+	Lines           []TransferLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+type TransferReceiptLineRequest struct {
+	LineID            uint             `json:"line_id" binding:"required"`
+	Quantity          measure.Quantity `json:"quantity" binding:"min=0"`
+	Discrepancy       measure.Quantity `json:"discrepancy" binding:"min=0"`
+	DiscrepancyReason string           `json:"discrepancy_reason"`
+	// Serials and DiscrepancySerials name the units that arrived and those
+	// written off, for lines shipped with serial numbers.
+	Serials            []string `json:"serials"`
+	DiscrepancySerials []string `json:"discrepancy_serials"`
+}
+
+type TransferReceiptRequest struct {
+	Lines []TransferReceiptLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// preloadTransfer loads a transfer's warehouses and lines.
+func preloadTransfer(db *gorm.DB) *gorm.DB {
+	return db.Preload("FromWarehouse").Preload("ToWarehouse").
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).Preload("Lines.Product").Preload("Lines.Serials")
+}
+
+// GetTransfers lists transfers, newest first. ?status filters by status and
+// ?warehouse_id by either end.
+func GetTransfers(c *gin.Context) {
+	query := preloadTransfer(database.DB).Order("id DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if warehouseID := c.Query("warehouse_id"); warehouseID != "" {
+		query = query.Where("from_warehouse_id = ? OR to_warehouse_id = ?", warehouseID, warehouseID)
+	}
+
+	var transfers []models.Transfer
+	if err := query.Find(&transfers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transfers"})
+		return
+	}
+	c.JSON(http.StatusOK, transfers)
+}
+
+func GetTransfer(c *gin.Context) {
+	id := c.Param("id")
+	var transfer models.Transfer
+	if err := preloadTransfer(database.DB).First(&transfer, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+	c.JSON(http.StatusOK, transfer)
+}
+
+// CreateTransfer creates a draft transfer. Nothing moves until it is shipped.
+func CreateTransfer(c *gin.Context) {
+	var req TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validTransfer(c, req) {
+		return
+	}
+
+	transfer := models.Transfer{
+		FromWarehouseID: req.FromWarehouseID,
+		ToWarehouseID:   req.ToWarehouseID,
+		Status:          models.TransferDraft,
+		Notes:           req.Notes,
+		Lines:           transferLines(req.Lines),
+		CreatedByID:     c.GetUint("userID"),
+	}
+
+	if err := database.DB.Create(&transfer).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create transfer"})
+		return
+	}
+
+	// Load relations
+	preloadTransfer(database.DB).First(&transfer, transfer.ID)
+
+	c.JSON(http.StatusCreated, transfer)
+}
+
+// UpdateTransfer replaces the warehouses, notes and lines of a draft transfer.
+func UpdateTransfer(c *gin.Context) {
+	var req TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validTransfer(c, req) {
+		return
+	}
+
+	var transfer models.Transfer
+	err := database.Transaction(database.DB, func(tx *gorm.DB) error {
+		if err := lockTransfer(tx, c.Param("id"), &transfer); err != nil {
+			return err
+		}
+		if transfer.Status != models.TransferDraft {
+			return inventory.ErrTransferStatus
+		}
+
+		lineIDs := tx.Model(&models.TransferLine{}).Select("id").Where("transfer_id = ?", transfer.ID)
+		if err := tx.Where("transfer_line_id IN (?)", lineIDs).Delete(&models.TransferLineSerial{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("transfer_id = ?", transfer.ID).Delete(&models.TransferLine{}).Error; err != nil {
+			return err
+		}
+		transfer.FromWarehouseID = req.FromWarehouseID
+		transfer.ToWarehouseID = req.ToWarehouseID
+		transfer.Notes = req.Notes
+		transfer.Lines = transferLines(req.Lines)
+		return tx.Save(&transfer).Error
+	})
+	if err != nil {
+		transferError(c, err, "Failed to update transfer")
+		return
+	}
+
+	// Load relations
+	preloadTransfer(database.DB).First(&transfer, transfer.ID)
+
+	c.JSON(http.StatusOK, transfer)
+}
+
+// ShipTransfer takes a draft transfer's stock out of the source warehouse.
+func ShipTransfer(c *gin.Context) {
+	userID := c.GetUint("userID")
+	updateTransfer(c, "Failed to ship transfer", func(tx *gorm.DB, transfer *models.Transfer) error {
+		return inventory.Ship(tx, transfer, userID)
+	})
+}
+
+// ReceiveTransfer books arrived stock into the destination warehouse and
+// records any discrepancies.
+func ReceiveTransfer(c *gin.Context) {
+	var req TransferReceiptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	receipts := make([]inventory.LineReceipt, len(req.Lines))
+	for i, line := range req.Lines {
+		receipts[i] = inventory.LineReceipt{
+			LineID:             line.LineID,
+			Quantity:           line.Quantity,
+			Discrepancy:        line.Discrepancy,
+			DiscrepancyReason:  line.DiscrepancyReason,
+			Serials:            line.Serials,
+			DiscrepancySerials: line.DiscrepancySerials,
+		}
+	}
+
+	userID := c.GetUint("userID")
+	updateTransfer(c, "Failed to receive transfer", func(tx *gorm.DB, transfer *models.Transfer) error {
+		return inventory.Receive(tx, transfer, receipts, userID)
+	})
+}
+
+// CancelTransfer cancels a transfer that has not shipped.
+func CancelTransfer(c *gin.Context) {
+	updateTransfer(c, "Failed to cancel transfer", func(tx *gorm.DB, transfer *models.Transfer) error {
+		if transfer.Status != models.TransferDraft {
+			return inventory.ErrTransferStatus
+		}
+		transfer.Status = models.TransferCancelled
+		return tx.Model(transfer).Update("status", transfer.Status).Error
+	})
+}
+
+// GetInTransit lists stock shipped and not yet received, per product and
+// route. ?warehouse_id limits it to transfers from or to that warehouse.
+func GetInTransit(c *gin.Context) {
+	query := database.DB
+	if warehouseID := c.Query("warehouse_id"); warehouseID != "" {
+		query = query.Where("transfers.from_warehouse_id = ? OR transfers.to_warehouse_id = ?", warehouseID, warehouseID)
+	}
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("transfer_lines.product_id = ?", productID)
+	}
+
+	quantities, err := inventory.InTransitQuantities(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch in-transit stock"})
+		return
+	}
+	c.JSON(http.StatusOK, quantities)
+}
+
+// updateTransfer locks the transfer named by the id parameter, applies fn in
+// a transaction and responds with the reloaded transfer.
+func updateTransfer(c *gin.Context, message string, fn func(tx *gorm.DB, transfer *models.Transfer) error) {
+	var transfer models.Transfer
+	err := database.Transaction(database.DB, func(tx *gorm.DB) error {
+		transfer = models.Transfer{}
+		if err := lockTransfer(tx, c.Param("id"), &transfer); err != nil {
+			return err
+		}
+		return fn(tx, &transfer)
+	})
+	if err != nil {
+		transferError(c, err, message)
+		return
+	}
+
+	preloadTransfer(database.DB).First(&transfer, transfer.ID)
+	c.JSON(http.StatusOK, transfer)
+}
+
+// lockTransfer loads a transfer with its lines and their serials and locks it
+// for the rest of tx, so two requests cannot ship or receive it at once.
+func lockTransfer(tx *gorm.DB, id string, transfer *models.Transfer) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).Preload("Lines.Serials").
+		First(transfer, id).Error
+}
+
+// transferError writes the response for an error from a transfer step.
+func transferError(c *gin.Context, err error, message string) {
+	var receiptErr *inventory.ReceiptError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+	case errors.Is(err, inventory.ErrTransferStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": "Transfer is not in a valid state for this action"})
+	case errors.As(err, &receiptErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": receiptErr.Error()})
+	default:
+		inventoryError(c, err, message)
+	}
+}
+
+// validTransfer writes a 400 response and returns false unless the transfer
+// is between two different, existing warehouses and gives the serial numbers
+// of serialized products.
+func validTransfer(c *gin.Context, req TransferRequest) bool {
+	if req.FromWarehouseID == req.ToWarehouseID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Source and destination warehouses must differ"})
+		return false
+	}
+	if !warehouseExists(c, req.FromWarehouseID) || !warehouseExists(c, req.ToWarehouseID) {
+		return false
+	}
+	lines := make([]serialLine, len(req.Lines))
+	for i, line := range req.Lines {
+		lines[i] = serialLine{line.ProductID, line.Quantity, line.Serials}
+	}
+	return checkLineSerials(c, lines)
+}
+
+func transferLines(requests []TransferLineRequest) []models.TransferLine {
+	lines := make([]models.TransferLine, len(requests))
+	for i, line := range requests {
+		lines[i] = models.TransferLine{ProductID: line.ProductID, Quantity: line.Quantity}
+		for _, serial := range line.Serials {
+			lines[i].Serials = append(lines[i].Serials, models.TransferLineSerial{Serial: serial})
+		}
+	}
+	return lines
+}
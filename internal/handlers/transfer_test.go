@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/inventory"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func transferRouter(userID uint) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", userID) })
+	router.POST("/transfers", CreateTransfer)
+	router.POST("/transfers/:id/ship", ShipTransfer)
+	router.POST("/transfers/:id/receive", ReceiveTransfer)
+	return router
+}
+
+func TestTransferShipAndPartialReceipt(t *testing.T) {
+	db := testDB(t)
+
+	f := newSaleFixture(t, db, 10)
+	product := f.products[0]
+	store := models.Warehouse{Code: f.warehouse.Code + "-store", Name: f.warehouse.Name + "-store"}
+	db.Create(&store)
+	router := transferRouter(f.user.ID)
+
+	call := func(path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	var transfer models.Transfer
+	code := call("/transfers", TransferRequest{
+		FromWarehouseID: f.warehouse.ID,
+		ToWarehouseID:   store.ID,
+		Lines:           []TransferLineRequest{{ProductID: product.ID, Quantity: qty(6)}},
+	}, &transfer)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected the transfer to be created, got %d", code)
+	}
+	t.Cleanup(func() {
+		db.Where("transfer_id = ?", transfer.ID).Delete(&models.TransferLine{})
+		db.Delete(&models.Transfer{}, transfer.ID)
+	})
+	if got := stockAt(db, product.ID, f.warehouse.ID); !got.Equal(qty(10)) {
+		t.Errorf("A draft transfer must not move stock, got %s at the source", got)
+	}
+
+	if code := call(fmt.Sprintf("/transfers/%d/ship", transfer.ID), nil, &transfer); code != http.StatusOK {
+		t.Fatalf("Expected the transfer to ship, got %d", code)
+	}
+	if code := call(fmt.Sprintf("/transfers/%d/ship", transfer.ID), nil, nil); code != http.StatusConflict {
+		t.Errorf("Shipping twice should conflict, got %d", code)
+	}
+	if got := stockAt(db, product.ID, f.warehouse.ID); !got.Equal(qty(4)) {
+		t.Errorf("Expected 4 left at the source, got %s", got)
+	}
+	if !transfer.Lines[0].InTransit.Equal(qty(6)) {
+		t.Errorf("Expected 6 in transit, got %s", transfer.Lines[0].InTransit)
+	}
+
+	lineID := transfer.Lines[0].ID
+	code = call(fmt.Sprintf("/transfers/%d/receive", transfer.ID), TransferReceiptRequest{
+		Lines: []TransferReceiptLineRequest{{LineID: lineID, Quantity: qty(3), Discrepancy: qty(1), DiscrepancyReason: "Crushed box"}},
+	}, &transfer)
+	if code != http.StatusOK || transfer.Status != models.TransferPartiallyReceived {
+		t.Fatalf("Expected a partial receipt, got %d with status %q", code, transfer.Status)
+	}
+	if got := stockAt(db, product.ID, store.ID); !got.Equal(qty(3)) {
+		t.Errorf("Expected 3 at the destination, got %s", got)
+	}
+	if !transfer.Lines[0].InTransit.Equal(qty(2)) {
+		t.Errorf("Expected 2 still in transit, got %s", transfer.Lines[0].InTransit)
+	}
+
+	code = call(fmt.Sprintf("/transfers/%d/receive", transfer.ID), TransferReceiptRequest{
+		Lines: []TransferReceiptLineRequest{{LineID: lineID, Quantity: qty(3)}},
+	}, nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("Receiving more than is outstanding should fail, got %d", code)
+	}
+
+	code = call(fmt.Sprintf("/transfers/%d/receive", transfer.ID), TransferReceiptRequest{
+		Lines: []TransferReceiptLineRequest{{LineID: lineID, Quantity: qty(2)}},
+	}, &transfer)
+	if code != http.StatusOK || transfer.Status != models.TransferReceived || transfer.ReceivedAt == nil {
+		t.Errorf("Expected the transfer to be received, got %d with status %q", code, transfer.Status)
+	}
+
+	var movements []models.StockMovement
+	db.Where("reference_type = ? AND reference_id = ?", models.ReferenceTransfer, transfer.ID).Order("id").Find(&movements)
+	if len(movements) != 3 || !movements[0].Quantity.Equal(qty(-6)) || !movements[1].Quantity.Equal(qty(3)) || !movements[2].Quantity.Equal(qty(2)) {
+		t.Errorf("Unexpected transfer movements %+v", movements)
+	}
+
+	drifts, _ := inventory.CheckConsistency(db)
+	for _, drift := range drifts {
+		if drift.ProductID == product.ID {
+			t.Errorf("Stock should match the ledger, got %+v", drift)
+		}
+	}
+}
+
+func TestTransferCannotShipReservedStock(t *testing.T) {
+	db := testDB(t)
+
+	f := newSaleFixture(t, db, 10)
+	product := f.products[0]
+	store := models.Warehouse{Code: f.warehouse.Code + "-store", Name: f.warehouse.Name + "-store"}
+	db.Create(&store)
+	router := transferRouter(f.user.ID)
+
+	call := func(path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	reservation := models.StockReservation{WarehouseID: f.warehouse.ID, ExpiresAt: time.Now().Add(time.Hour), CreatedByID: f.user.ID,
+		Lines: []models.StockReservationLine{{ProductID: product.ID, Quantity: qty(8)}}}
+	if err := db.Transaction(func(tx *gorm.DB) error { return inventory.Reserve(tx, &reservation) }); err != nil {
+		t.Fatalf("Failed to reserve stock: %v", err)
+	}
+
+	var transfer models.Transfer
+	code := call("/transfers", TransferRequest{
+		FromWarehouseID: f.warehouse.ID,
+		ToWarehouseID:   store.ID,
+		Lines:           []TransferLineRequest{{ProductID: product.ID, Quantity: qty(3)}},
+	}, &transfer)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected the transfer to be created, got %d", code)
+	}
+	t.Cleanup(func() {
+		db.Where("transfer_id = ?", transfer.ID).Delete(&models.TransferLine{})
+		db.Delete(&models.Transfer{}, transfer.ID)
+	})
+
+	if code := call(fmt.Sprintf("/transfers/%d/ship", transfer.ID), nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Shipping 3 with only 2 unreserved should fail, got %d", code)
+	}
+	if got := stockAt(db, product.ID, f.warehouse.ID); !got.Equal(qty(10)) {
+		t.Errorf("A refused shipment must not move stock, got %s at the source", got)
+	}
+
+	adjustment := models.StockMovement{ProductID: product.ID, WarehouseID: f.warehouse.ID, Type: models.MovementAdjustment, Quantity: qty(-3)}
+	if err := inventory.Post(db, &adjustment); !errors.Is(err, inventory.ErrInsufficientStock) {
+		t.Errorf("An adjustment should not take reserved stock, got %v", err)
+	}
+	adjustment = models.StockMovement{ProductID: product.ID, WarehouseID: f.warehouse.ID, Type: models.MovementAdjustment, Quantity: qty(-2)}
+	if err := inventory.Post(db, &adjustment); err != nil {
+		t.Errorf("An adjustment of unreserved stock should post, got %v", err)
+	}
+}
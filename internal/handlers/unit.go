@@ -4,22 +4,34 @@ import (
 	"net/http"
 
 	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/listing"
 	"github.com/edwinjordan/erp_golang/internal/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type UnitRequest struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description"`
+	// AllowFractions lets quantities in the unit have decimals.
+	AllowFractions bool `json:"allow_fractions"`
 }
 
+// unitListing is what GET /api/units sorts by.
+var unitListing = listing.Spec{
+	Sorts:       map[string]string{"id": "id", "name": "name", "created_at": "created_at"},
+	DefaultSort: "id",
+}
+
+// GetUnits lists units a page at a time.
 func GetUnits(c *gin.Context) {
 	var units []models.Unit
-	if err := database.DB.Find(&units).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch units"})
+	page, err := listing.Find(c, database.DB, unitListing, &units)
+	if err != nil {
+		listError(c, err, "Failed to fetch units")
 		return
 	}
-	c.JSON(http.StatusOK, units)
+	c.JSON(http.StatusOK, page)
 }
 
 func GetUnit(c *gin.Context) {
@@ -40,8 +52,9 @@ func CreateUnit(c *gin.Context) {
 	}
 
 	unit := models.Unit{
-		Name:        req.Name,
-		Description: req.Description,
+		Name:           req.Name,
+		Description:    req.Description,
+		AllowFractions: req.AllowFractions,
 	}
 
 	if err := database.DB.Create(&unit).Error; err != nil {
@@ -66,8 +79,23 @@ func UpdateUnit(c *gin.Context) {
 		return
 	}
 
+	// A unit products keep fractional quantities in must go on allowing
+	// fractions.
+	if unit.AllowFractions && !req.AllowFractions {
+		conflict, err := fractionsInUse(unit.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update unit"})
+			return
+		}
+		if conflict != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": conflict})
+			return
+		}
+	}
+
 	unit.Name = req.Name
 	unit.Description = req.Description
+	unit.AllowFractions = req.AllowFractions
 
 	if err := database.DB.Save(&unit).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update unit"})
@@ -77,6 +105,44 @@ func UpdateUnit(c *gin.Context) {
 	c.JSON(http.StatusOK, unit)
 }
 
+// fractionsInUse describes why the products kept in a unit need it to allow
+// fractions, or returns "" if they do not: fractional stock anywhere it is
+// kept, or an alternate unit worth a fraction of it.
+func fractionsInUse(unitID uint) (string, error) {
+	products := database.DB.Model(&models.Product{}).Select("id").Where("unit_id = ?", unitID)
+	checks := []struct {
+		query   *gorm.DB
+		message string
+	}{
+		{database.DB.Model(&models.Product{}).
+			Where("unit_id = ? AND (stock <> TRUNC(stock) OR reserved <> TRUNC(reserved))", unitID),
+			"Products in this unit have fractional stock"},
+		{database.DB.Model(&models.StockLevel{}).
+			Where("product_id IN (?) AND quantity <> TRUNC(quantity)", products),
+			"Products in this unit have fractional stock at a warehouse"},
+		{database.DB.Model(&models.LotLevel{}).Joins("JOIN lots ON lots.id = lot_levels.lot_id").
+			Where("lots.product_id IN (?) AND lot_levels.quantity <> TRUNC(lot_levels.quantity)", products),
+			"Products in this unit have fractional lot balances"},
+		{database.DB.Model(&models.TransferLine{}).Joins("JOIN transfers ON transfers.id = transfer_lines.transfer_id").
+			Where("transfer_lines.product_id IN (?) AND transfers.status IN ?", products, []string{models.TransferDraft, models.TransferShipped}).
+			Where("(transfer_lines.quantity <> TRUNC(transfer_lines.quantity) OR transfer_lines.received_quantity <> TRUNC(transfer_lines.received_quantity))"),
+			"Products in this unit have fractional quantities on open transfers"},
+		{database.DB.Model(&models.ProductUnit{}).
+			Where("product_id IN (?) AND factor <> TRUNC(factor)", products),
+			"Products in this unit have alternate units worth a fraction of it"},
+	}
+	for _, check := range checks {
+		var count int64
+		if err := check.query.Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return check.message, nil
+		}
+	}
+	return "", nil
+}
+
 func DeleteUnit(c *gin.Context) {
 	id := c.Param("id")
 	if err := database.DB.Delete(&models.Unit{}, id).Error; err != nil {
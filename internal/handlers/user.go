@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/middleware"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/mailer"
+	"github.com/edwinjordan/erp_golang/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+	RoleID   uint   `json:"role_id" binding:"required"`
+}
+
+type InviteUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	RoleID   uint   `json:"role_id" binding:"required"`
+}
+
+type InviteResponse struct {
+	User        models.User `json:"user"`
+	InviteToken string      `json:"invite_token"`
+	ExpiresAt   time.Time   `json:"expires_at"`
+}
+
+type UpdateUserRoleRequest struct {
+	RoleID uint `json:"role_id" binding:"required"`
+}
+
+type AcceptInviteRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func GetUsers(c *gin.Context) {
+	var users []models.User
+	if err := database.DB.Preload("Role").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func GetUser(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+	if err := database.DB.Preload("Role").First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func CreateUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !roleExists(c, req.RoleID) || !roleWithinCaller(c, req.RoleID, "Cannot assign a role with permissions you do not hold") {
+		return
+	}
+
+	if err := passwordPolicy.Validate(req.Password, req.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := models.User{
+		Username: req.Username,
+		Email:    req.Email,
+		RoleID:   req.RoleID,
+	}
+
+	if err := user.HashPassword(req.Password); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	if err := database.DB.Create(&user).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username or email already exists"})
+		return
+	}
+
+	database.DB.Preload("Role").First(&user, user.ID)
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// InviteUser creates an account without a usable password and emails a
+// single-use token the invitee exchanges for a password at
+// /invitations/accept. The token is also returned so it can be handed over
+// directly.
+func InviteUser(c *gin.Context) {
+	var req InviteUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !roleExists(c, req.RoleID) || !roleWithinCaller(c, req.RoleID, "Cannot assign a role with permissions you do not hold") {
+		return
+	}
+
+	inviteToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invite token"})
+		return
+	}
+
+	// Nobody knows this password, so the account cannot be used until the
+	// invitation is accepted.
+	placeholder, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invite token"})
+		return
+	}
+
+	user := models.User{
+		Username: req.Username,
+		Email:    req.Email,
+		RoleID:   req.RoleID,
+	}
+	if err := user.HashPassword(placeholder); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	expiresAt := time.Now().Add(appConfig.InviteTTL)
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.UserToken{
+			UserID:    user.ID,
+			Purpose:   models.TokenPurposeInvite,
+			TokenHash: utils.HashToken(inviteToken),
+			ExpiresAt: expiresAt,
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username or email already exists"})
+		return
+	}
+
+	database.DB.Preload("Role").First(&user, user.ID)
+
+	sendMail(mailer.Message{
+		To:      []string{user.Email},
+		Subject: "You have been invited to the ERP",
+		Body: fmt.Sprintf("Hello %s,\n\nAn account has been created for you. Use the link below to choose a password. "+
+			"It expires in %s.\n\n%s\n", user.Username, appConfig.InviteTTL, appLink("/accept-invitation", inviteToken)),
+	})
+
+	c.JSON(http.StatusCreated, InviteResponse{
+		User:        user,
+		InviteToken: inviteToken,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// AcceptInvite sets the password of an invited user. It is a public endpoint;
+// the invite token is the credential.
+func AcceptInvite(c *gin.Context) {
+	var req AcceptInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, user, ok := redeemableToken(req.Token, models.TokenPurposeInvite)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return
+	}
+
+	if err := passwordPolicy.Validate(req.Password, user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := redeemToken(tx, &token); err != nil {
+			return err
+		}
+		return setPassword(tx, &user, req.Password)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation accepted, you can now log in"})
+}
+
+func UpdateUserRole(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req UpdateUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !roleExists(c, req.RoleID) || !roleWithinCaller(c, req.RoleID, "Cannot assign a role with permissions you do not hold") {
+		return
+	}
+
+	if isCurrentUser(c, user.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot change your own role"})
+		return
+	}
+
+	if !roleWithinCaller(c, user.RoleID, "Cannot change the role of a user with permissions you do not hold") {
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("role_id", req.RoleID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	database.DB.Preload("Role").First(&user, user.ID)
+
+	c.JSON(http.StatusOK, user)
+}
+
+func DisableUser(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if isCurrentUser(c, user.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot disable your own account"})
+		return
+	}
+
+	if !user.IsDisabled() {
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Update("disabled_at", time.Now()).Error; err != nil {
+				return err
+			}
+			return revokeAllSessions(tx, user.ID)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable user"})
+			return
+		}
+	}
+
+	database.DB.Preload("Role").First(&user, user.ID)
+
+	c.JSON(http.StatusOK, user)
+}
+
+func EnableUser(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("disabled_at", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable user"})
+		return
+	}
+
+	database.DB.Preload("Role").First(&user, user.ID)
+
+	c.JSON(http.StatusOK, user)
+}
+
+// roleExists writes a 400 response and returns false if the role does not
+// exist.
+func roleExists(c *gin.Context, roleID uint) bool {
+	var count int64
+	database.DB.Model(&models.Role{}).Where("id = ?", roleID).Count(&count)
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role not found"})
+		return false
+	}
+	return true
+}
+
+// roleWithinCaller writes a 403 response with message and returns false if
+// the role grants a permission the caller's role does not, so managing users
+// cannot be used to hand out or take away more than the caller holds.
+func roleWithinCaller(c *gin.Context, roleID uint, message string) bool {
+	granted, err := middleware.RolePermissions(roleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions"})
+		return false
+	}
+	held, err := middleware.RolePermissions(c.MustGet("roleID").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions"})
+		return false
+	}
+	for name := range granted {
+		if _, ok := held[name]; !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": message})
+			return false
+		}
+	}
+	return true
+}
+
+func isCurrentUser(c *gin.Context, userID uint) bool {
+	current, exists := c.Get("userID")
+	return exists && current.(uint) == userID
+}
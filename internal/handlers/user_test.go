@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/middleware"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestUserRolesCannotExceedCaller(t *testing.T) {
+	db := testDB(t)
+	middleware.InvalidateAllPermissions()
+	t.Cleanup(middleware.InvalidateAllPermissions)
+
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	read := models.Permission{Name: "users:read-" + suffix}
+	write := models.Permission{Name: "users:write-" + suffix}
+	db.Create(&read)
+	db.Create(&write)
+	manager := models.Role{Name: "manager-" + suffix, Permissions: []models.Permission{read, write}}
+	clerk := models.Role{Name: "clerk-" + suffix, Permissions: []models.Permission{read}}
+	admin := models.Role{Name: "admin-" + suffix, Permissions: []models.Permission{read, write}}
+	db.Create(&manager)
+	db.Create(&clerk)
+	db.Create(&admin)
+	// The caller is a clerk who, for the test, may manage users.
+	caller := models.User{Username: "caller-" + suffix, Email: "caller-" + suffix + "@example.com", RoleID: clerk.ID}
+	boss := models.User{Username: "boss-" + suffix, Email: "boss-" + suffix + "@example.com", RoleID: admin.ID}
+	peer := models.User{Username: "peer-" + suffix, Email: "peer-" + suffix + "@example.com", RoleID: clerk.ID}
+	for _, user := range []*models.User{&caller, &boss, &peer} {
+		user.HashPassword("not-a-real-password")
+		db.Create(user)
+	}
+	t.Cleanup(func() {
+		db.Unscoped().Where("username LIKE ?", "%-"+suffix).Delete(&models.User{})
+		for _, role := range []models.Role{manager, clerk, admin} {
+			db.Model(&role).Association("Permissions").Clear()
+			db.Unscoped().Delete(&role)
+		}
+		db.Unscoped().Delete(&read)
+		db.Unscoped().Delete(&write)
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("userID", caller.ID)
+		c.Set("roleID", caller.RoleID)
+	})
+	router.POST("/users", CreateUser)
+	router.PUT("/users/:id/role", UpdateUserRole)
+	call := func(method, path string, body interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		return w.Code
+	}
+
+	create := CreateUserRequest{Username: "new-" + suffix, Email: "new-" + suffix + "@example.com", Password: "long-enough-passphrase", RoleID: manager.ID}
+	if code := call(http.MethodPost, "/users", create); code != http.StatusForbidden {
+		t.Errorf("Creating a user with more permissions than the caller should be forbidden, got %d", code)
+	}
+	create.RoleID = clerk.ID
+	if code := call(http.MethodPost, "/users", create); code != http.StatusCreated {
+		t.Errorf("Creating a user with the caller's permissions should succeed, got %d", code)
+	}
+
+	if code := call(http.MethodPut, fmt.Sprintf("/users/%d/role", peer.ID), UpdateUserRoleRequest{RoleID: manager.ID}); code != http.StatusForbidden {
+		t.Errorf("Promoting a user beyond the caller should be forbidden, got %d", code)
+	}
+	if code := call(http.MethodPut, fmt.Sprintf("/users/%d/role", boss.ID), UpdateUserRoleRequest{RoleID: clerk.ID}); code != http.StatusForbidden {
+		t.Errorf("Demoting a user who holds more than the caller should be forbidden, got %d", code)
+	}
+	var reloaded models.User
+	db.First(&reloaded, boss.ID)
+	if reloaded.RoleID != admin.ID {
+		t.Errorf("The demotion should not have been applied, role is %d", reloaded.RoleID)
+	}
+}
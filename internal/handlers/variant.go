@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxVariants caps the combinations a parent product's attributes may
+// give, so a typo in a value list cannot create thousands of products.
+const maxVariants = 500
+
+// VariantsRequest defines a parent product's attributes. The values are
+// added to the attribute's current ones; attributes themselves cannot be
+// added or removed once the product has variants.
+type VariantsRequest struct {
+	Attributes []AttributeRequest `json:"attributes" binding:"required,min=1,max=3,dive"`
+}
+
+type AttributeRequest struct {
+	Name   string   `json:"name" binding:"required,max=50"`
+	Values []string `json:"values" binding:"required,min=1,dive,required,max=50"`
+}
+
+// variantError carries the response for a variant generation the
+// transaction refuses.
+type variantError struct {
+	status  int
+	message string
+}
+
+func (e *variantError) Error() string { return e.message }
+
+// mergeAttributes adds the requested attributes' values to the current
+// ones. Values matching a current one regardless of case keep the current
+// spelling. It returns a message if the request is invalid.
+func mergeAttributes(current []models.ProductAttribute, requested []AttributeRequest) ([]models.ProductAttribute, string) {
+	if len(current) > 0 && len(requested) != len(current) {
+		return nil, "Attributes cannot be added to or removed from a product with variants"
+	}
+
+	merged := make([]models.ProductAttribute, len(requested))
+	combinations := 1
+	for i, attribute := range requested {
+		name := strings.TrimSpace(attribute.Name)
+		var values []string
+		if len(current) > 0 {
+			if !strings.EqualFold(name, current[i].Name) {
+				return nil, fmt.Sprintf("Attribute %d must be %s", i+1, current[i].Name)
+			}
+			name = current[i].Name
+			values = append(values, current[i].Values...)
+		}
+		for _, other := range merged[:i] {
+			if strings.EqualFold(name, other.Name) {
+				return nil, "Duplicate attribute " + name
+			}
+		}
+		for _, value := range attribute.Values {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				return nil, "Attribute " + name + " has an empty value"
+			}
+			if !containsFold(values, value) {
+				values = append(values, value)
+			}
+		}
+		merged[i] = models.ProductAttribute{Name: name, Values: values}
+		combinations *= len(values)
+		if combinations > maxVariants {
+			return nil, fmt.Sprintf("A product can have at most %d variants", maxVariants)
+		}
+	}
+	return merged, ""
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// variantOptions returns every combination of the attributes' values, the
+// first attribute varying slowest.
+func variantOptions(attributes []models.ProductAttribute) [][]string {
+	combinations := [][]string{nil}
+	for _, attribute := range attributes {
+		next := make([][]string, 0, len(combinations)*len(attribute.Values))
+		for _, options := range combinations {
+			for _, value := range attribute.Values {
+				next = append(next, append(append([]string(nil), options...), value))
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// variantSKU builds a variant's SKU from its parent's and its options,
+// each upper-cased with everything but letters and digits dropped, such as
+// SHIRT-M-NAVYBLUE.
+func variantSKU(parentSKU string, options []string) string {
+	parts := []string{parentSKU}
+	for _, option := range options {
+		parts = append(parts, strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToUpper(r)
+			}
+			return -1
+		}, option))
+	}
+	return strings.Join(parts, "-")
+}
+
+// GenerateVariants defines a product's attributes and creates a variant for
+// every combination of their values that has none yet. A product without
+// stock or barcodes becomes a parent the first time; later calls add
+// values and create the variants they give. Variants copy the parent's
+// details, price and units, and get a SKU built from the parent's and their
+// options. Deleted variants are not recreated.
+func GenerateVariants(c *gin.Context) {
+	var req VariantsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var parent models.Product
+	var created int
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// The parent is locked so stock cannot be posted to it while it
+		// becomes a parent, and concurrent calls do not create the same
+		// variants twice.
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Barcodes").Preload("Units").First(&parent, c.Param("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &variantError{http.StatusNotFound, "Product not found"}
+			}
+			return err
+		}
+		if parent.ParentID != nil {
+			return &variantError{http.StatusBadRequest, "A variant cannot have variants"}
+		}
+		if !parent.HasVariants {
+			if !parent.Stock.IsZero() || !parent.Reserved.IsZero() {
+				return &variantError{http.StatusConflict, "Only a product without stock can have variants"}
+			}
+			if len(parent.Barcodes) > 0 {
+				return &variantError{http.StatusConflict, "Barcodes belong to variants; remove the product's barcodes first"}
+			}
+		}
+		attributes, message := mergeAttributes(parent.Attributes, req.Attributes)
+		if message != "" {
+			return &variantError{http.StatusBadRequest, message}
+		}
+
+		var existing []models.Product
+		if err := tx.Unscoped().Select("id", "options").Where("parent_id = ?", parent.ID).Find(&existing).Error; err != nil {
+			return err
+		}
+		exists := make(map[string]bool, len(existing))
+		for _, variant := range existing {
+			exists[strings.Join(variant.Options, "\x1f")] = true
+		}
+
+		parentSKU := models.DefaultSKU(parent.ID)
+		if parent.SKU != nil {
+			parentSKU = *parent.SKU
+		}
+		var variants []models.Product
+		var skus []string
+		for _, options := range variantOptions(attributes) {
+			if exists[strings.Join(options, "\x1f")] {
+				continue
+			}
+			sku := variantSKU(parentSKU, options)
+			if len(sku) > 64 {
+				return &variantError{http.StatusBadRequest, "Variant SKU is longer than 64 characters: " + sku}
+			}
+			skus = append(skus, sku)
+			variants = append(variants, models.Product{
+				Name:            parent.Name + " - " + strings.Join(options, " / "),
+				Description:     parent.Description,
+				CategoryID:      parent.CategoryID,
+				UnitID:          parent.UnitID,
+				Price:           parent.Price,
+				TrackLots:       parent.TrackLots,
+				Serialized:      parent.Serialized,
+				ReorderPoint:    parent.ReorderPoint,
+				ReorderQuantity: parent.ReorderQuantity,
+				ParentID:        &parent.ID,
+				Options:         options,
+			})
+		}
+		// The SKUs point into skus once it has stopped growing.
+		for i := range variants {
+			variants[i].SKU = &skus[i]
+		}
+
+		if len(skus) > 0 {
+			var taken []string
+			if err := tx.Model(&models.Product{}).Where("sku IN ?", skus).Pluck("sku", &taken).Error; err != nil {
+				return err
+			}
+			if len(taken) > 0 {
+				return &variantError{http.StatusConflict, "SKU already in use: " + taken[0]}
+			}
+			if err := tx.Create(&variants).Error; err != nil {
+				return err
+			}
+			if len(parent.Units) > 0 {
+				for _, variant := range variants {
+					units := append([]models.ProductUnit(nil), parent.Units...)
+					if err := replaceProductUnits(tx, variant.ID, units); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		created = len(variants)
+
+		return tx.Model(&models.Product{}).Where("id = ?", parent.ID).Select("HasVariants", "Attributes").
+			Updates(models.Product{HasVariants: true, Attributes: attributes}).Error
+	})
+
+	var variantErr *variantError
+	if errors.As(err, &variantErr) {
+		c.JSON(variantErr.status, gin.H{"error": variantErr.message})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate variants"})
+		return
+	}
+
+	id := parent.ID
+	parent = models.Product{}
+	preloadVariants(preloadProduct(database.DB)).First(&parent, id)
+	status := http.StatusOK
+	if created > 0 {
+		status = http.StatusCreated
+	}
+	c.JSON(status, parent)
+}
+
+// preloadVariants loads a parent product's variants with their stock
+// levels and barcodes.
+func preloadVariants(db *gorm.DB) *gorm.DB {
+	return db.Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Variants.Category").Preload("Variants.Unit").Preload("Variants.Units.Unit").
+		Preload("Variants.StockLevels", stockLevelsShown).Preload("Variants.StockLevels.Warehouse").
+		Preload("Variants.Barcodes", func(db *gorm.DB) *gorm.DB { return db.Order("id") })
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/edwinjordan/erp_golang/internal/config"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/money"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMergeAttributes(t *testing.T) {
+	current := []models.ProductAttribute{{Name: "Size", Values: []string{"S", "M"}}, {Name: "Colour", Values: []string{"Red"}}}
+
+	merged, message := mergeAttributes(current, []AttributeRequest{{Name: "size", Values: []string{"m", "L"}}, {Name: "Colour", Values: []string{"Blue"}}})
+	want := []models.ProductAttribute{{Name: "Size", Values: []string{"S", "M", "L"}}, {Name: "Colour", Values: []string{"Red", "Blue"}}}
+	if message != "" || !reflect.DeepEqual(merged, want) {
+		t.Errorf("mergeAttributes() = %v, %q, want %v", merged, message, want)
+	}
+
+	many := make([]string, 30)
+	for i := range many {
+		many[i] = fmt.Sprint(i)
+	}
+	tests := []struct {
+		name      string
+		current   []models.ProductAttribute
+		requested []AttributeRequest
+	}{
+		{"attribute removed", current, []AttributeRequest{{Name: "Size", Values: []string{"S"}}}},
+		{"attribute renamed", current, []AttributeRequest{{Name: "Fit", Values: []string{"S"}}, {Name: "Colour", Values: []string{"Red"}}}},
+		{"duplicate attribute", nil, []AttributeRequest{{Name: "Size", Values: []string{"S"}}, {Name: "size", Values: []string{"M"}}}},
+		{"blank value", nil, []AttributeRequest{{Name: "Size", Values: []string{" "}}}},
+		{"too many variants", nil, []AttributeRequest{{Name: "A", Values: many}, {Name: "B", Values: many}}},
+	}
+	for _, tt := range tests {
+		if _, message := mergeAttributes(tt.current, tt.requested); message == "" {
+			t.Errorf("%s: expected the attributes to be refused", tt.name)
+		}
+	}
+}
+
+func TestVariantOptions(t *testing.T) {
+	options := variantOptions([]models.ProductAttribute{{Name: "Size", Values: []string{"S", "M"}}, {Name: "Colour", Values: []string{"Red", "Navy Blue"}}})
+	want := [][]string{{"S", "Red"}, {"S", "Navy Blue"}, {"M", "Red"}, {"M", "Navy Blue"}}
+	if !reflect.DeepEqual(options, want) {
+		t.Errorf("variantOptions() = %v, want %v", options, want)
+	}
+	if sku := variantSKU("SHIRT", options[3]); sku != "SHIRT-M-NAVYBLUE" {
+		t.Errorf("variantSKU() = %q, want SHIRT-M-NAVYBLUE", sku)
+	}
+}
+
+func TestGenerateVariants(t *testing.T) {
+	db := testDB(t)
+
+	previous := appConfig
+	appConfig = &config.Config{}
+	t.Cleanup(func() { appConfig = previous })
+
+	f := newSaleFixture(t, db, 0)
+	shirt := f.products[0]
+	sku := fmt.Sprintf("SHIRT%d", shirt.ID)
+	db.Model(&shirt).Update("sku", sku)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", f.user.ID) })
+	router.POST("/products/:id/variants", GenerateVariants)
+	router.POST("/products/:id/barcode", GenerateBarcode)
+	router.PUT("/products/:id", UpdateProduct)
+	router.GET("/products", GetProducts)
+	router.POST("/sales", CreateSale)
+	call := func(method, path string, body interface{}, out interface{}) int {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(raw)))
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+	path := fmt.Sprintf("/products/%d/variants", shirt.ID)
+
+	var parent models.Product
+	req := VariantsRequest{Attributes: []AttributeRequest{{Name: "Size", Values: []string{"S", "M"}}, {Name: "Colour", Values: []string{"Red", "Blue"}}}}
+	if code := call(http.MethodPost, path, req, &parent); code != http.StatusCreated || !parent.HasVariants || len(parent.Variants) != 4 {
+		t.Fatalf("Expected 4 variants, got %d: %+v", code, parent)
+	}
+	if v := parent.Variants[3]; *v.SKU != sku+"-M-BLUE" || !reflect.DeepEqual(v.Options, []string{"M", "Blue"}) || !v.Price.Equal(shirt.Price) {
+		t.Errorf("Expected the M / Blue variant at the parent's price, got %+v", v)
+	}
+
+	req.Attributes[0].Values = []string{"L"}
+	if code := call(http.MethodPost, path, req, &parent); code != http.StatusCreated || len(parent.Variants) != 6 {
+		t.Fatalf("Expected 2 more variants for size L, got %d: %d variants", code, len(parent.Variants))
+	}
+	if code := call(http.MethodPost, path, req, &parent); code != http.StatusOK || len(parent.Variants) != 6 {
+		t.Errorf("Expected nothing new from the same values, got %d: %d variants", code, len(parent.Variants))
+	}
+	if code := call(http.MethodPost, fmt.Sprintf("/products/%d/barcode", shirt.ID), nil, nil); code != http.StatusBadRequest {
+		t.Errorf("A parent product should not get a barcode, got %d", code)
+	}
+
+	small, large := parent.Variants[0], parent.Variants[5]
+	update := func(id uint, price string) int {
+		return call(http.MethodPut, fmt.Sprintf("/products/%d", id), ProductRequest{
+			Name: "x", CategoryID: shirt.CategoryID, UnitID: shirt.UnitID, Price: moneyPtr(price),
+		}, nil)
+	}
+	if code := update(large.ID, "4.00"); code != http.StatusOK {
+		t.Fatalf("Expected the variant price to be overridden, got %d", code)
+	}
+	if code := update(shirt.ID, "3.00"); code != http.StatusOK {
+		t.Fatalf("Expected the parent price to change, got %d", code)
+	}
+	db.First(&small, small.ID)
+	db.First(&large, large.ID)
+	if small.Price.String() != "3.00" || large.Price.String() != "4.00" {
+		t.Errorf("Expected only the variant without an override to follow the parent, got %s and %s", small.Price, large.Price)
+	}
+
+	f.receive(t, db, small.ID, f.warehouse.ID, 1)
+	sale := CreateSaleRequest{TerminalID: &f.terminal.ID, Items: []SaleItemRequest{{ProductID: shirt.ID, Quantity: qty(1)}}}
+	if code := call(http.MethodPost, "/sales", sale, nil); code != http.StatusBadRequest {
+		t.Errorf("Selling a parent should be refused, got %d", code)
+	}
+	sale.Items[0].ProductID = small.ID
+	if code := call(http.MethodPost, "/sales", sale, nil); code != http.StatusCreated {
+		t.Errorf("Expected the variant to sell, got %d", code)
+	}
+
+	var page struct {
+		Data []models.Product `json:"data"`
+	}
+	call(http.MethodGet, fmt.Sprintf("/products?group_variants=true&category_id=%d", shirt.CategoryID), nil, &page)
+	if len(page.Data) != 1 || page.Data[0].ID != shirt.ID || len(page.Data[0].Variants) != 6 {
+		t.Errorf("Expected the parent with its variants, got %+v", page.Data)
+	}
+}
+
+func moneyPtr(s string) *money.Amount {
+	amount := money.MustParse(s)
+	return &amount
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type WarehouseRequest struct {
+	Code    string `json:"code" binding:"required"`
+	Name    string `json:"name" binding:"required"`
+	Address string `json:"address"`
+	// IsDefault makes this the default warehouse in place of the current one.
+	IsDefault bool `json:"is_default"`
+}
+
+func GetWarehouses(c *gin.Context) {
+	var warehouses []models.Warehouse
+	if err := database.DB.Order("id").Find(&warehouses).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch warehouses"})
+		return
+	}
+	c.JSON(http.StatusOK, warehouses)
+}
+
+func GetWarehouse(c *gin.Context) {
+	id := c.Param("id")
+	var warehouse models.Warehouse
+	if err := database.DB.First(&warehouse, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	}
+	c.JSON(http.StatusOK, warehouse)
+}
+
+func CreateWarehouse(c *gin.Context) {
+	var req WarehouseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	warehouse := models.Warehouse{
+		Code:      req.Code,
+		Name:      req.Name,
+		Address:   req.Address,
+		IsDefault: req.IsDefault,
+	}
+
+	if err := saveWarehouse(&warehouse); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create warehouse"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, warehouse)
+}
+
+func UpdateWarehouse(c *gin.Context) {
+	id := c.Param("id")
+	var warehouse models.Warehouse
+	if err := database.DB.First(&warehouse, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	}
+
+	var req WarehouseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if warehouse.IsDefault && !req.IsDefault {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Make another warehouse the default instead"})
+		return
+	}
+
+	warehouse.Code = req.Code
+	warehouse.Name = req.Name
+	warehouse.Address = req.Address
+	warehouse.IsDefault = req.IsDefault
+
+	if err := saveWarehouse(&warehouse); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update warehouse"})
+		return
+	}
+
+	c.JSON(http.StatusOK, warehouse)
+}
+
+// saveWarehouse saves warehouse and, if it is the default, clears the flag
+// on every other warehouse.
+func saveWarehouse(warehouse *models.Warehouse) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(warehouse).Error; err != nil {
+			return err
+		}
+		if !warehouse.IsDefault {
+			return nil
+		}
+		return tx.Model(&models.Warehouse{}).Where("id <> ? AND is_default", warehouse.ID).
+			Update("is_default", false).Error
+	})
+}
+
+// DeleteWarehouse deletes an empty warehouse. The default warehouse and
+// warehouses that hold stock or have terminals assigned are kept.
+func DeleteWarehouse(c *gin.Context) {
+	id := c.Param("id")
+	var warehouse models.Warehouse
+	if err := database.DB.First(&warehouse, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	}
+
+	if warehouse.IsDefault {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The default warehouse cannot be deleted"})
+		return
+	}
+
+	var stocked, terminals int64
+	database.DB.Model(&models.StockLevel{}).Where("warehouse_id = ? AND quantity <> 0", warehouse.ID).Count(&stocked)
+	database.DB.Model(&models.Terminal{}).Where("warehouse_id = ?", warehouse.ID).Count(&terminals)
+	if stocked > 0 || terminals > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Warehouse still holds stock or has terminals assigned"})
+		return
+	}
+
+	if err := database.DB.Delete(&warehouse).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete warehouse"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Warehouse deleted successfully"})
+}
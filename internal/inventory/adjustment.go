@@ -0,0 +1,199 @@
+package inventory
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrAdjustmentStatus is returned when posting an adjustment that is
+	// not a draft.
+	ErrAdjustmentStatus = errors.New("adjustment is not a draft")
+	// ErrCycleCountStatus is returned when changing a cycle count that is
+	// no longer open.
+	ErrCycleCountStatus = errors.New("cycle count is not open")
+	// ErrUncounted is returned when approving a cycle count with products
+	// that have not been counted.
+	ErrUncounted = errors.New("cycle count has uncounted products")
+)
+
+// PostAdjustment applies every line of a draft adjustment as an adjustment
+// movement, taking stock out of lot-tracked products first-expired-first-out,
+// writes off or finds the serial numbers of serialized products and marks it
+// posted. The adjustment must have been loaded with its lines and their
+// serials and locked by tx.
+func PostAdjustment(tx *gorm.DB, adjustment *models.StockAdjustment, userID uint) error {
+	if adjustment.Status != models.AdjustmentDraft {
+		return ErrAdjustmentStatus
+	}
+
+	lines := append([]models.StockAdjustmentLine(nil), adjustment.Lines...)
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].ProductID < lines[j].ProductID })
+	productIDs := make([]uint, len(lines))
+	for i, line := range lines {
+		productIDs[i] = line.ProductID
+	}
+	serialized, err := serializedProducts(tx, productIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if !serialsMatch(serialized[line.ProductID], line.Quantity, len(line.Serials)) {
+			return ErrSerialCount
+		}
+		movement := models.StockMovement{
+			ProductID:     line.ProductID,
+			WarehouseID:   adjustment.WarehouseID,
+			Type:          models.MovementAdjustment,
+			Quantity:      line.Quantity,
+			Reason:        adjustment.ReasonCode,
+			ReferenceType: models.ReferenceAdjustment,
+			ReferenceID:   &adjustment.ID,
+			UserID:        &userID,
+		}
+		if _, err := PostFEFO(tx, &movement, false); err != nil {
+			return err
+		}
+		serials := make([]string, len(line.Serials))
+		for i, serial := range line.Serials {
+			serials[i] = serial.Serial
+		}
+		if err := AdjustSerials(tx, &movement, serials); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	adjustment.Status = models.AdjustmentPosted
+	adjustment.PostedAt = &now
+	adjustment.PostedByID = &userID
+	return tx.Model(adjustment).Select("status", "posted_at", "posted_by_id").Updates(adjustment).Error
+}
+
+// OnHand returns the stock of each product at a warehouse. Products without
+// a stock level there are missing from the map.
+func OnHand(db *gorm.DB, warehouseID uint, productIDs []uint) (map[uint]measure.Quantity, error) {
+	var levels []models.StockLevel
+	if err := db.Where("warehouse_id = ? AND product_id IN ?", warehouseID, productIDs).Find(&levels).Error; err != nil {
+		return nil, err
+	}
+	onHand := make(map[uint]measure.Quantity, len(levels))
+	for _, level := range levels {
+		onHand[level.ProductID] = level.Quantity
+	}
+	return onHand, nil
+}
+
+// ApproveCycleCount turns the variances of an open, fully counted cycle
+// count into a posted count_correction adjustment and marks the count
+// approved. Serialized products are corrected by serial number instead:
+// those in stock at the warehouse but not counted are written off and those
+// counted but not in stock are found. No adjustment is made when nothing
+// differs. The count must have been loaded with its lines and their serials
+// and locked by tx.
+func ApproveCycleCount(tx *gorm.DB, count *models.CycleCount, userID uint) error {
+	if count.Status != models.CycleCountOpen {
+		return ErrCycleCountStatus
+	}
+
+	productIDs := make([]uint, len(count.Lines))
+	for i, line := range count.Lines {
+		productIDs[i] = line.ProductID
+	}
+	serialized, err := serializedProducts(tx, productIDs)
+	if err != nil {
+		return err
+	}
+
+	adjustment := models.StockAdjustment{
+		WarehouseID:  count.WarehouseID,
+		ReasonCode:   models.AdjustmentReasonCountCorrection,
+		Notes:        count.Notes,
+		Status:       models.AdjustmentDraft,
+		CycleCountID: &count.ID,
+		CreatedByID:  userID,
+	}
+	for _, line := range count.Lines {
+		if line.CountedQuantity == nil {
+			return ErrUncounted
+		}
+		if serialized[line.ProductID] {
+			if !serialsMatch(true, *line.CountedQuantity, len(line.Serials)) {
+				return ErrSerialCount
+			}
+			corrections, err := serialCorrections(tx, count.WarehouseID, line)
+			if err != nil {
+				return err
+			}
+			adjustment.Lines = append(adjustment.Lines, corrections...)
+			continue
+		}
+		if variance := line.CountedQuantity.Sub(line.ExpectedQuantity); !variance.IsZero() {
+			adjustment.Lines = append(adjustment.Lines, models.StockAdjustmentLine{ProductID: line.ProductID, Quantity: variance})
+		}
+	}
+
+	if len(adjustment.Lines) > 0 {
+		if err := tx.Create(&adjustment).Error; err != nil {
+			return err
+		}
+		if err := PostAdjustment(tx, &adjustment, userID); err != nil {
+			return err
+		}
+		count.AdjustmentID = &adjustment.ID
+	}
+
+	now := time.Now()
+	count.Status = models.CycleCountApproved
+	count.ApprovedAt = &now
+	count.ApprovedByID = &userID
+	return tx.Model(count).Select("status", "approved_at", "approved_by_id", "adjustment_id").Updates(count).Error
+}
+
+// serialCorrections returns the adjustment lines that bring the serial
+// numbers in stock at the warehouse in line with those counted on line: one
+// writing off the ones not counted and one finding the ones not in stock.
+func serialCorrections(tx *gorm.DB, warehouseID uint, line models.CycleCountLine) ([]models.StockAdjustmentLine, error) {
+	var inStock []string
+	err := tx.Model(&models.SerialNumber{}).
+		Where("product_id = ? AND warehouse_id = ? AND status = ?", line.ProductID, warehouseID, models.SerialInStock).
+		Order("serial").Pluck("serial", &inStock).Error
+	if err != nil {
+		return nil, err
+	}
+	counted := make(map[string]bool, len(line.Serials))
+	for _, serial := range line.Serials {
+		counted[serial.Serial] = true
+	}
+
+	missing := models.StockAdjustmentLine{ProductID: line.ProductID}
+	for _, serial := range inStock {
+		if !counted[serial] {
+			missing.Serials = append(missing.Serials, models.StockAdjustmentSerial{Serial: serial})
+		}
+		delete(counted, serial)
+	}
+	found := models.StockAdjustmentLine{ProductID: line.ProductID}
+	for _, serial := range line.Serials {
+		if counted[serial.Serial] {
+			found.Serials = append(found.Serials, models.StockAdjustmentSerial{Serial: serial.Serial})
+		}
+	}
+
+	var lines []models.StockAdjustmentLine
+	if len(missing.Serials) > 0 {
+		missing.Quantity = measure.FromInt(-int64(len(missing.Serials)))
+		lines = append(lines, missing)
+	}
+	if len(found.Serials) > 0 {
+		found.Quantity = measure.FromInt(int64(len(found.Serials)))
+		lines = append(lines, found)
+	}
+	return lines, nil
+}
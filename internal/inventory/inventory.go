@@ -0,0 +1,229 @@
+// Package inventory keeps stock and the StockMovement ledger in step. Every
+// change to stock is posted as a movement at a warehouse; StockLevel and
+// Product.Stock are caches of the ledger totals per warehouse and overall,
+// which the consistency check compares against.
+package inventory
+
+import (
+	"errors"
+
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInsufficientStock is returned when an outgoing movement would take
+	// stock at the warehouse below what active reservations hold there.
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrProductNotFound is returned when the movement's product does not
+	// exist or has been deleted.
+	ErrProductNotFound = errors.New("product not found")
+	// ErrWarehouseNotFound is returned when the movement's warehouse does not
+	// exist or has been deleted.
+	ErrWarehouseNotFound = errors.New("warehouse not found")
+	// ErrNoDefaultWarehouse is returned when no warehouse is marked default.
+	ErrNoDefaultWarehouse = errors.New("no default warehouse")
+	// ErrZeroQuantity is returned for a movement that changes nothing.
+	ErrZeroQuantity = errors.New("movement quantity must not be zero")
+	// ErrFractionalQuantity is returned for a movement of a fraction of a
+	// product whose base unit is counted whole.
+	ErrFractionalQuantity = errors.New("quantity must be whole in the product's unit")
+	// ErrHasVariants is returned for a movement of a parent product, whose
+	// stock is kept by its variants.
+	ErrHasVariants = errors.New("product has variants")
+)
+
+// DefaultWarehouseID returns the ID of the default warehouse.
+func DefaultWarehouseID(db *gorm.DB) (uint, error) {
+	var warehouse models.Warehouse
+	err := db.Where("is_default = ?", true).Order("id").First(&warehouse).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, ErrNoDefaultWarehouse
+	}
+	return warehouse.ID, err
+}
+
+// Post applies m.Quantity to the product's stock at m.WarehouseID, or at the
+// default warehouse if it is zero, and appends m to the ledger, filling in
+// m.Balance. With m.LotID set the lot's stock there changes too. The stock
+// updates are conditional, so an outgoing movement never takes stock at the
+// warehouse below what reservations hold, nor the lot's below zero. A sale
+// fulfilling a reservation ends it before posting. Post should run inside a
+// transaction so a failed caller leaves neither the stock change nor the
+// movement behind.
+func Post(tx *gorm.DB, m *models.StockMovement) error {
+	if m.Quantity.IsZero() {
+		return ErrZeroQuantity
+	}
+	if m.WarehouseID == 0 {
+		id, err := DefaultWarehouseID(tx)
+		if err != nil {
+			return err
+		}
+		m.WarehouseID = id
+	}
+
+	var product struct {
+		HasVariants    bool
+		AllowFractions bool
+	}
+	result := tx.Table("products").Select("products.has_variants, COALESCE(units.allow_fractions, FALSE) AS allow_fractions").
+		Joins("LEFT JOIN units ON units.id = products.unit_id").
+		Where("products.id = ? AND products.deleted_at IS NULL", m.ProductID).Scan(&product)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrProductNotFound
+	}
+	if product.HasVariants {
+		return ErrHasVariants
+	}
+	if !product.AllowFractions && !m.Quantity.IsWhole() {
+		return ErrFractionalQuantity
+	}
+	var count int64
+	if err := tx.Model(&models.Warehouse{}).Where("id = ?", m.WarehouseID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrWarehouseNotFound
+	}
+	if m.LotID != nil {
+		if err := tx.Model(&models.Lot{}).Where("id = ? AND product_id = ?", *m.LotID, m.ProductID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrLotNotFound
+		}
+	}
+
+	// The product row is updated first, which locks it, so every change to
+	// its stock levels is serialized on the product and concurrent postings
+	// cannot deadlock on the level rows.
+	if err := tx.Model(&models.Product{}).Where("id = ?", m.ProductID).
+		UpdateColumn("stock", gorm.Expr("stock + ?", m.Quantity)).Error; err != nil {
+		return err
+	}
+
+	level := models.StockLevel{ProductID: m.ProductID, WarehouseID: m.WarehouseID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&level).Error; err != nil {
+		return err
+	}
+
+	guard := "quantity + ? >= 0"
+	if m.Quantity.IsNegative() {
+		guard = "quantity - reserved + ? >= 0"
+	}
+	result = tx.Model(&models.StockLevel{}).
+		Where("product_id = ? AND warehouse_id = ? AND "+guard, m.ProductID, m.WarehouseID, m.Quantity).
+		Updates(map[string]interface{}{"quantity": gorm.Expr("quantity + ?", m.Quantity), "updated_at": gorm.Expr("NOW()")})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInsufficientStock
+	}
+
+	if m.LotID != nil {
+		lotLevel := models.LotLevel{LotID: *m.LotID, WarehouseID: m.WarehouseID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&lotLevel).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&models.LotLevel{}).
+			Where("lot_id = ? AND warehouse_id = ? AND quantity + ? >= 0", *m.LotID, m.WarehouseID, m.Quantity).
+			Updates(map[string]interface{}{"quantity": gorm.Expr("quantity + ?", m.Quantity), "updated_at": gorm.Expr("NOW()")})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInsufficientStock
+		}
+	}
+
+	// The level row stays locked by the update until the transaction ends.
+	if err := tx.Model(&models.StockLevel{}).Where("product_id = ? AND warehouse_id = ?", m.ProductID, m.WarehouseID).
+		Pluck("quantity", &m.Balance).Error; err != nil {
+		return err
+	}
+	return tx.Create(m).Error
+}
+
+// Drift is stock that disagrees with the ledger. WarehouseID is nil when the
+// drift is in a product's total stock rather than at one warehouse.
+type Drift struct {
+	ProductID   uint             `json:"product_id"`
+	WarehouseID *uint            `json:"warehouse_id"`
+	Name        string           `json:"name"`
+	Stock       measure.Quantity `json:"stock"`
+	Ledger      measure.Quantity `json:"ledger"`
+	// Difference is Stock minus Ledger.
+	Difference measure.Quantity `json:"difference"`
+}
+
+// CheckConsistency returns every stock level and product total that is not
+// the sum of its movements.
+func CheckConsistency(db *gorm.DB) ([]Drift, error) {
+	drifts := []Drift{}
+	err := db.Raw(`SELECT p.id AS product_id, COALESCE(l.warehouse_id, m.warehouse_id) AS warehouse_id, p.name,
+			COALESCE(l.quantity, 0) AS stock,
+			COALESCE(m.quantity, 0) AS ledger,
+			COALESCE(l.quantity, 0) - COALESCE(m.quantity, 0) AS difference
+		FROM stock_levels l
+		FULL OUTER JOIN (
+			SELECT product_id, warehouse_id, SUM(quantity) AS quantity
+			FROM stock_movements GROUP BY product_id, warehouse_id
+		) m ON m.product_id = l.product_id AND m.warehouse_id = l.warehouse_id
+		JOIN products p ON p.id = COALESCE(l.product_id, m.product_id)
+		WHERE p.deleted_at IS NULL AND COALESCE(l.quantity, 0) <> COALESCE(m.quantity, 0)
+		ORDER BY p.id, 2`).Scan(&drifts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var totals []Drift
+	err = db.Raw(`SELECT p.id AS product_id, p.name, p.stock,
+			COALESCE(SUM(m.quantity), 0) AS ledger,
+			p.stock - COALESCE(SUM(m.quantity), 0) AS difference
+		FROM products p
+		LEFT JOIN stock_movements m ON m.product_id = p.id
+		WHERE p.deleted_at IS NULL
+		GROUP BY p.id, p.name, p.stock
+		HAVING p.stock <> COALESCE(SUM(m.quantity), 0)
+		ORDER BY p.id`).Scan(&totals).Error
+	return append(drifts, totals...), err
+}
+
+// Reconcile resets every drifting stock level and product total to its
+// ledger total, treating the ledger as the source of truth, and returns what
+// it changed.
+func Reconcile(tx *gorm.DB) ([]Drift, error) {
+	drifts, err := CheckConsistency(tx)
+	if err != nil || len(drifts) == 0 {
+		return drifts, err
+	}
+
+	ids := make([]uint, len(drifts))
+	for i, d := range drifts {
+		ids[i] = d.ProductID
+	}
+	if err := tx.Exec(`INSERT INTO stock_levels (product_id, warehouse_id, quantity, updated_at)
+		SELECT product_id, warehouse_id, SUM(quantity), NOW()
+		FROM stock_movements WHERE product_id IN ?
+		GROUP BY product_id, warehouse_id
+		ON CONFLICT (product_id, warehouse_id) DO UPDATE SET quantity = EXCLUDED.quantity, updated_at = NOW()`, ids).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Exec(`UPDATE stock_levels SET quantity = 0, updated_at = NOW()
+		WHERE product_id IN ? AND quantity <> 0 AND NOT EXISTS (
+			SELECT 1 FROM stock_movements m
+			WHERE m.product_id = stock_levels.product_id AND m.warehouse_id = stock_levels.warehouse_id)`, ids).Error; err != nil {
+		return nil, err
+	}
+	err = tx.Exec(`UPDATE products SET stock = COALESCE(
+			(SELECT SUM(quantity) FROM stock_movements WHERE product_id = products.id), 0)
+		WHERE id IN ?`, ids).Error
+	return drifts, err
+}
@@ -0,0 +1,206 @@
+package inventory
+
+import (
+	"errors"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrLotNotFound is returned when a movement's lot does not exist or
+	// belongs to another product.
+	ErrLotNotFound = errors.New("lot not found")
+	// ErrLotExpiryMismatch is returned when receiving into an existing lot
+	// with a different expiry date.
+	ErrLotExpiryMismatch = errors.New("lot exists with a different expiry date")
+	// ErrLotExpired is returned when stock is only short because the rest is
+	// in expired lots, which may not be sold.
+	ErrLotExpired = errors.New("only expired lots are left")
+)
+
+// FindOrCreateLot returns the product's lot with the given number, creating
+// it with the expiry date if it does not exist yet.
+func FindOrCreateLot(tx *gorm.DB, productID uint, lotNumber string, expiryDate *time.Time) (*models.Lot, error) {
+	lot := models.Lot{ProductID: productID, LotNumber: lotNumber, ExpiryDate: expiryDate}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&lot).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("product_id = ? AND lot_number = ?", productID, lotNumber).First(&lot).Error; err != nil {
+		return nil, err
+	}
+	if !sameDate(lot.ExpiryDate, expiryDate) {
+		return nil, ErrLotExpiryMismatch
+	}
+	return &lot, nil
+}
+
+func sameDate(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
+
+// lotStock is a lot's stock at one warehouse.
+type lotStock struct {
+	LotID      uint
+	ExpiryDate *time.Time
+	Quantity   measure.Quantity
+}
+
+// PostFEFO posts an outgoing movement of a lot-tracked product as one
+// movement per lot, taking the lots that expire first, then any stock in no
+// lot. With skipExpired, expired lots are left alone. Movements that name a
+// lot, incoming movements and products without lot tracking are posted as
+// they are. m is only a template; the movements posted are returned.
+//
+// Like Post it locks the product first, so the lots cannot change between
+// choosing them and posting.
+func PostFEFO(tx *gorm.DB, m *models.StockMovement, skipExpired bool) ([]models.StockMovement, error) {
+	if !m.Quantity.IsNegative() || m.LotID != nil {
+		return postOne(tx, m)
+	}
+
+	var product models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "track_lots").First(&product, m.ProductID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	if !product.TrackLots {
+		return postOne(tx, m)
+	}
+	if m.WarehouseID == 0 {
+		id, err := DefaultWarehouseID(tx)
+		if err != nil {
+			return nil, err
+		}
+		m.WarehouseID = id
+	}
+
+	var lots []lotStock
+	if err := tx.Table("lot_levels").
+		Select("lot_levels.lot_id, lots.expiry_date, lot_levels.quantity").
+		Joins("JOIN lots ON lots.id = lot_levels.lot_id").
+		Where("lots.product_id = ? AND lot_levels.warehouse_id = ? AND lot_levels.quantity > 0", m.ProductID, m.WarehouseID).
+		Order("lots.expiry_date IS NULL, lots.expiry_date, lots.id").
+		Scan(&lots).Error; err != nil {
+		return nil, err
+	}
+	onHand, err := OnHand(tx, m.WarehouseID, []uint{m.ProductID})
+	if err != nil {
+		return nil, err
+	}
+
+	// Plan the split before posting anything, so a shortfall is reported
+	// as the right error.
+	needed := m.Quantity.Neg()
+	unlotted := onHand[m.ProductID]
+	expired := measure.Zero
+	var taken []lotStock
+	now := time.Now()
+	for _, lot := range lots {
+		unlotted = unlotted.Sub(lot.Quantity)
+		if skipExpired && (&models.Lot{ExpiryDate: lot.ExpiryDate}).IsExpired(now) {
+			expired = expired.Add(lot.Quantity)
+			continue
+		}
+		if needed.IsZero() {
+			continue
+		}
+		quantity := lot.Quantity
+		if quantity.Cmp(needed) > 0 {
+			quantity = needed
+		}
+		needed = needed.Sub(quantity)
+		taken = append(taken, lotStock{LotID: lot.LotID, Quantity: quantity})
+	}
+	if unlotted.IsNegative() {
+		unlotted = measure.Zero
+	}
+	if needed.Cmp(unlotted) > 0 {
+		if needed.Cmp(unlotted.Add(expired)) <= 0 {
+			return nil, ErrLotExpired
+		}
+		return nil, ErrInsufficientStock
+	}
+
+	var posted []models.StockMovement
+	post := func(quantity measure.Quantity, lotID *uint) error {
+		movement := *m
+		movement.Quantity = quantity.Neg()
+		movement.LotID = lotID
+		if err := Post(tx, &movement); err != nil {
+			return err
+		}
+		posted = append(posted, movement)
+		return nil
+	}
+	for _, lot := range taken {
+		lotID := lot.LotID
+		if err := post(lot.Quantity, &lotID); err != nil {
+			return nil, err
+		}
+	}
+	if needed.IsPositive() {
+		if err := post(needed, nil); err != nil {
+			return nil, err
+		}
+	}
+	return posted, nil
+}
+
+func postOne(tx *gorm.DB, m *models.StockMovement) ([]models.StockMovement, error) {
+	if err := Post(tx, m); err != nil {
+		return nil, err
+	}
+	return []models.StockMovement{*m}, nil
+}
+
+// ExpiringLot is a lot with stock at a warehouse that expires soon or has
+// expired.
+type ExpiringLot struct {
+	LotID         uint             `json:"lot_id"`
+	LotNumber     string           `json:"lot_number"`
+	ProductID     uint             `json:"product_id"`
+	ProductName   string           `json:"product_name"`
+	WarehouseID   uint             `json:"warehouse_id"`
+	WarehouseName string           `json:"warehouse_name"`
+	ExpiryDate    time.Time        `json:"expiry_date"`
+	Quantity      measure.Quantity `json:"quantity"`
+	// DaysLeft is negative once the lot has expired.
+	DaysLeft int  `json:"days_left"`
+	Expired  bool `json:"expired"`
+}
+
+// ExpiringLots lists the lots in stock that expire within the given number
+// of days, already expired ones included, soonest first. A warehouseID of
+// zero covers all warehouses.
+func ExpiringLots(db *gorm.DB, days int, warehouseID uint) ([]ExpiringLot, error) {
+	query := db.Table("lot_levels ll").
+		Select(`ll.lot_id, lots.lot_number, lots.product_id, p.name AS product_name,
+			ll.warehouse_id, w.name AS warehouse_name, lots.expiry_date, ll.quantity,
+			lots.expiry_date - CURRENT_DATE AS days_left`).
+		Joins("JOIN lots ON lots.id = ll.lot_id").
+		Joins("JOIN products p ON p.id = lots.product_id AND p.deleted_at IS NULL").
+		Joins("JOIN warehouses w ON w.id = ll.warehouse_id AND w.deleted_at IS NULL").
+		Where("ll.quantity > 0 AND lots.expiry_date IS NOT NULL AND lots.expiry_date <= CURRENT_DATE + CAST(? AS integer)", days).
+		Order("lots.expiry_date, ll.lot_id, ll.warehouse_id")
+	if warehouseID != 0 {
+		query = query.Where("ll.warehouse_id = ?", warehouseID)
+	}
+
+	lots := []ExpiringLot{}
+	if err := query.Scan(&lots).Error; err != nil {
+		return nil, err
+	}
+	for i := range lots {
+		lots[i].Expired = lots[i].DaysLeft < 0
+	}
+	return lots, nil
+}
@@ -0,0 +1,196 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/edwinjordan/erp_golang/pkg/notify"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReorderPolicy controls how suggested order quantities are worked out.
+type ReorderPolicy struct {
+	// VelocityDays is how many days of sales the daily velocity averages.
+	VelocityDays int
+	// CoverDays is how many days of sales an order should cover on top of
+	// the reorder point.
+	CoverDays int
+}
+
+// LowStockItem is a product at or below its reorder point at a warehouse.
+type LowStockItem struct {
+	ProductID       uint             `json:"product_id"`
+	ProductName     string           `json:"product_name"`
+	WarehouseID     uint             `json:"warehouse_id"`
+	WarehouseName   string           `json:"warehouse_name"`
+	OnHand          measure.Quantity `json:"on_hand"`
+	ReorderPoint    measure.Quantity `json:"reorder_point"`
+	ReorderQuantity measure.Quantity `json:"reorder_quantity"`
+	// InTransit is already shipped towards the warehouse.
+	InTransit measure.Quantity `json:"in_transit"`
+	// DailyVelocity is the average units sold per day at the warehouse.
+	DailyVelocity     float64          `json:"daily_velocity"`
+	SuggestedQuantity measure.Quantity `json:"suggested_quantity"`
+}
+
+// LowStock lists every product whose stock at a warehouse is at or below its
+// reorder point, with a suggested order quantity. A warehouseID of zero
+// covers all warehouses.
+//
+// The suggestion tops stock up to the reorder point plus CoverDays of sales
+// at the recent velocity, counting stock in transit, and is never less than
+// the reorder quantity.
+func LowStock(db *gorm.DB, policy ReorderPolicy, warehouseID uint) ([]LowStockItem, error) {
+	query := db.Table("stock_levels l").
+		Select(`l.product_id, p.name AS product_name, l.warehouse_id, w.name AS warehouse_name,
+			l.quantity AS on_hand,
+			COALESCE(l.reorder_point, p.reorder_point) AS reorder_point,
+			COALESCE(l.reorder_quantity, p.reorder_quantity, 0) AS reorder_quantity`).
+		Joins("JOIN products p ON p.id = l.product_id AND p.deleted_at IS NULL").
+		Joins("JOIN warehouses w ON w.id = l.warehouse_id AND w.deleted_at IS NULL").
+		Where("COALESCE(l.reorder_point, p.reorder_point) IS NOT NULL").
+		Where("l.quantity <= COALESCE(l.reorder_point, p.reorder_point)").
+		Order("l.warehouse_id, l.product_id")
+	if warehouseID != 0 {
+		query = query.Where("l.warehouse_id = ?", warehouseID)
+	}
+
+	items := []LowStockItem{}
+	if err := query.Scan(&items).Error; err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return items, nil
+	}
+
+	productIDs := make([]uint, len(items))
+	for i, item := range items {
+		productIDs[i] = item.ProductID
+	}
+	sold, err := unitsSold(db, productIDs, time.Now().AddDate(0, 0, -policy.VelocityDays))
+	if err != nil {
+		return nil, err
+	}
+	inTransit, err := InTransitQuantities(db.Where("transfer_lines.product_id IN ?", productIDs))
+	if err != nil {
+		return nil, err
+	}
+	inbound := make(map[[2]uint]measure.Quantity)
+	for _, t := range inTransit {
+		key := [2]uint{t.ProductID, t.ToWarehouseID}
+		inbound[key] = inbound[key].Add(t.Quantity)
+	}
+
+	for i := range items {
+		item := &items[i]
+		key := [2]uint{item.ProductID, item.WarehouseID}
+		if policy.VelocityDays > 0 {
+			units, _ := sold[key].Decimal().Float64()
+			item.DailyVelocity = units / float64(policy.VelocityDays)
+		}
+		item.InTransit = inbound[key]
+
+		demand := measure.FromInt(int64(math.Ceil(item.DailyVelocity * float64(policy.CoverDays))))
+		item.SuggestedQuantity = item.ReorderPoint.Add(demand).Sub(item.OnHand).Sub(item.InTransit)
+		if item.SuggestedQuantity.Cmp(item.ReorderQuantity) < 0 {
+			item.SuggestedQuantity = item.ReorderQuantity
+		}
+	}
+	return items, nil
+}
+
+// unitsSold sums the quantities of the products sold since the given time,
+// per product and warehouse.
+func unitsSold(db *gorm.DB, productIDs []uint, since time.Time) (map[[2]uint]measure.Quantity, error) {
+	var rows []struct {
+		ProductID   uint
+		WarehouseID uint
+		Quantity    measure.Quantity
+	}
+	err := db.Table("sale_items").
+		Select("sale_items.product_id, sales.warehouse_id, SUM(sale_items.quantity) AS quantity").
+		Joins("JOIN sales ON sales.id = sale_items.sale_id AND sales.deleted_at IS NULL").
+		Where("sale_items.deleted_at IS NULL AND sale_items.product_id IN ? AND sales.created_at >= ?", productIDs, since).
+		Group("sale_items.product_id, sales.warehouse_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sold := make(map[[2]uint]measure.Quantity, len(rows))
+	for _, row := range rows {
+		sold[[2]uint{row.ProductID, row.WarehouseID}] = row.Quantity
+	}
+	return sold, nil
+}
+
+// RaiseLowStockAlerts sends one alert listing the products that have become
+// low on stock since the last run. Products stay quiet while they remain
+// low, and are alerted again only after recovering and dropping once more.
+func RaiseLowStockAlerts(ctx context.Context, db *gorm.DB, policy ReorderPolicy, notifier notify.Notifier) error {
+	db = db.WithContext(ctx)
+	items, err := LowStock(db, policy, 0)
+	if err != nil {
+		return err
+	}
+
+	// The alert rows are only committed once the notification went out, so
+	// a failed delivery is retried on the next run. Another instance raising
+	// the same alerts waits on the rows and then skips them.
+	return db.Transaction(func(tx *gorm.DB) error {
+		low := make(map[[2]uint]bool, len(items))
+		var raised []LowStockItem
+		now := time.Now()
+		for _, item := range items {
+			low[[2]uint{item.ProductID, item.WarehouseID}] = true
+			alert := models.LowStockAlert{ProductID: item.ProductID, WarehouseID: item.WarehouseID, RaisedAt: now}
+			// With several API instances only the one that inserts the row
+			// sends the alert.
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&alert)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 1 {
+				raised = append(raised, item)
+			}
+		}
+
+		var alerts []models.LowStockAlert
+		if err := tx.Find(&alerts).Error; err != nil {
+			return err
+		}
+		for _, alert := range alerts {
+			if !low[[2]uint{alert.ProductID, alert.WarehouseID}] {
+				if err := tx.Delete(&alert).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(raised) == 0 {
+			return nil
+		}
+		return notifier.Notify(ctx, lowStockAlert(raised))
+	})
+}
+
+func lowStockAlert(items []LowStockItem) notify.Alert {
+	var body strings.Builder
+	body.WriteString("The following products are at or below their reorder point:\n\n")
+	for _, item := range items {
+		fmt.Fprintf(&body, "- %s at %s: %s on hand, reorder point %s, suggested order %s\n",
+			item.ProductName, item.WarehouseName, item.OnHand, item.ReorderPoint, item.SuggestedQuantity)
+	}
+
+	subject := fmt.Sprintf("%d products are low on stock", len(items))
+	if len(items) == 1 {
+		subject = fmt.Sprintf("%s is low on stock at %s", items[0].ProductName, items[0].WarehouseName)
+	}
+	return notify.Alert{Kind: "low_stock", Subject: subject, Body: body.String(), Data: items}
+}
@@ -0,0 +1,171 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrReservationStatus is returned when ending a reservation that is no
+// longer active.
+var ErrReservationStatus = errors.New("reservation is not active")
+
+// Reserve holds the quantities of an active reservation at its warehouse, or
+// at the default warehouse if WarehouseID is zero, and creates it. Like Post,
+// it locks each product before its stock level, in ascending product ID
+// order, and the hold is conditional so no more than the available stock is
+// ever reserved.
+func Reserve(tx *gorm.DB, r *models.StockReservation) error {
+	if r.WarehouseID == 0 {
+		id, err := DefaultWarehouseID(tx)
+		if err != nil {
+			return err
+		}
+		r.WarehouseID = id
+	}
+	var count int64
+	if err := tx.Model(&models.Warehouse{}).Where("id = ?", r.WarehouseID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrWarehouseNotFound
+	}
+
+	for _, entry := range sortedQuantities(r.Lines) {
+		result := tx.Model(&models.Product{}).Where("id = ?", entry.productID).
+			UpdateColumn("reserved", gorm.Expr("reserved + ?", entry.quantity))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrProductNotFound
+		}
+
+		level := models.StockLevel{ProductID: entry.productID, WarehouseID: r.WarehouseID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&level).Error; err != nil {
+			return err
+		}
+		result = tx.Model(&models.StockLevel{}).
+			Where("product_id = ? AND warehouse_id = ? AND quantity - reserved >= ?", entry.productID, r.WarehouseID, entry.quantity).
+			UpdateColumn("reserved", gorm.Expr("reserved + ?", entry.quantity))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInsufficientStock
+		}
+	}
+
+	r.Status = models.ReservationActive
+	return tx.Create(r).Error
+}
+
+// EndReservation releases the stock held by an active reservation and gives
+// it the final status: fulfilled, released or expired. The reservation must
+// have been loaded with its lines and locked by tx.
+func EndReservation(tx *gorm.DB, r *models.StockReservation, status string) error {
+	if r.Status != models.ReservationActive {
+		return ErrReservationStatus
+	}
+
+	for _, entry := range sortedQuantities(r.Lines) {
+		// Unscoped, so the hold is lifted even if the product was deleted.
+		if err := tx.Unscoped().Model(&models.Product{}).Where("id = ?", entry.productID).
+			UpdateColumn("reserved", gorm.Expr("reserved - ?", entry.quantity)).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.StockLevel{}).Where("product_id = ? AND warehouse_id = ?", entry.productID, r.WarehouseID).
+			UpdateColumn("reserved", gorm.Expr("reserved - ?", entry.quantity)).Error; err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	r.Status = status
+	r.ReleasedAt = &now
+	return tx.Model(r).Select("status", "released_at", "sale_id").Updates(r).Error
+}
+
+// Available returns the stock of each product at a warehouse that is not
+// reserved. Products without a stock level there are missing from the map.
+func Available(db *gorm.DB, warehouseID uint, productIDs []uint) (map[uint]measure.Quantity, error) {
+	var levels []models.StockLevel
+	if err := db.Where("warehouse_id = ? AND product_id IN ?", warehouseID, productIDs).Find(&levels).Error; err != nil {
+		return nil, err
+	}
+	available := make(map[uint]measure.Quantity, len(levels))
+	for _, level := range levels {
+		available[level.ProductID] = level.Available
+	}
+	return available, nil
+}
+
+// ReleaseExpired ends every active reservation past its expiry, freeing its
+// stock, and returns how many it ended. Each reservation is released in its
+// own transaction so one failure does not hold up the rest.
+func ReleaseExpired(ctx context.Context, db *gorm.DB) (int, error) {
+	db = db.WithContext(ctx)
+	var ids []uint
+	if err := db.Model(&models.StockReservation{}).
+		Where("status = ? AND expires_at <= ?", models.ReservationActive, time.Now()).
+		Order("id").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, id := range ids {
+		var ended bool
+		err := database.Transaction(db, func(tx *gorm.DB) error {
+			var r models.StockReservation
+			if err := LockReservation(tx, id, &r); err != nil {
+				return err
+			}
+			// It may have been fulfilled or released since it was listed.
+			ended = r.Status == models.ReservationActive
+			if !ended {
+				return nil
+			}
+			return EndReservation(tx, &r, models.ReservationExpired)
+		})
+		if err != nil {
+			return released, err
+		}
+		if ended {
+			released++
+		}
+	}
+	return released, nil
+}
+
+// LockReservation loads a reservation with its lines and locks it for the
+// rest of tx.
+func LockReservation(tx *gorm.DB, id interface{}, r *models.StockReservation) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Lines").First(r, id).Error
+}
+
+type productQuantity struct {
+	productID uint
+	quantity  measure.Quantity
+}
+
+// sortedQuantities sums the reservation lines per product, in the order in
+// which products are locked everywhere stock moves.
+func sortedQuantities(lines []models.StockReservationLine) []productQuantity {
+	sums := make(map[uint]measure.Quantity)
+	for _, line := range lines {
+		sums[line.ProductID] = sums[line.ProductID].Add(line.Quantity)
+	}
+	entries := make([]productQuantity, 0, len(sums))
+	for id, quantity := range sums {
+		entries = append(entries, productQuantity{id, quantity})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].productID < entries[j].productID })
+	return entries
+}
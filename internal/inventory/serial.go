@@ -0,0 +1,237 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrSerialExists is returned when receiving a serial number the
+	// product already has.
+	ErrSerialExists = errors.New("serial number already exists")
+	// ErrSerialNotInStock is returned when selling, shipping or writing off
+	// a serial number that is unknown or not in stock at the warehouse.
+	ErrSerialNotInStock = errors.New("serial number not in stock")
+	// ErrSerialNotInTransit is returned when receiving a serial number that
+	// is not in transit.
+	ErrSerialNotInTransit = errors.New("serial number not in transit")
+	// ErrSerialCount is returned when a line of a serialized product does
+	// not give one serial number per unit, or a line of another product
+	// gives any.
+	ErrSerialCount = errors.New("serial numbers do not match the quantity")
+	// ErrSerialStatus is returned when returning a serial number that is
+	// not sold.
+	ErrSerialStatus = errors.New("serial number is not sold")
+)
+
+// ReceiveSerials records the serial numbers received by the receipt m,
+// which must already be posted, one per unit.
+func ReceiveSerials(tx *gorm.DB, m *models.StockMovement, serials []string) error {
+	for _, serial := range serials {
+		if err := createSerial(tx, m, serial, models.SerialEventReceived); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SellSerials marks the serial numbers sold on a sale item and records them
+// on it. They must be in stock at the sale's warehouse. The sale's movements
+// must be posted already, so the product is locked.
+func SellSerials(tx *gorm.DB, sale *models.Sale, item *models.SaleItem, serials []string) error {
+	for _, serial := range serials {
+		number, err := moveSerial(tx, item.ProductID, serial,
+			serialState{models.SerialInStock, &sale.WarehouseID}, serialState{models.SerialSold, nil},
+			models.SerialEvent{
+				Type:          models.SerialEventSold,
+				WarehouseID:   sale.WarehouseID,
+				ReferenceType: models.ReferenceSale,
+				ReferenceID:   &sale.ID,
+				UserID:        &sale.UserID,
+			}, ErrSerialNotInStock)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&models.SaleItemSerial{SaleItemID: item.ID, SerialNumberID: number.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AdjustSerials applies the serial numbers of the adjustment movement m,
+// which must already be posted. Taking stock out writes them off; putting
+// stock in brings written off serial numbers back, or records new ones.
+func AdjustSerials(tx *gorm.DB, m *models.StockMovement, serials []string) error {
+	event := models.SerialEvent{
+		WarehouseID:   m.WarehouseID,
+		ReferenceType: m.ReferenceType,
+		ReferenceID:   m.ReferenceID,
+		UserID:        m.UserID,
+	}
+	inStock := serialState{models.SerialInStock, &m.WarehouseID}
+	writtenOff := serialState{models.SerialWrittenOff, nil}
+	for _, serial := range serials {
+		if m.Quantity.IsNegative() {
+			event.Type = models.SerialEventWrittenOff
+			if _, err := moveSerial(tx, m.ProductID, serial, inStock, writtenOff, event, ErrSerialNotInStock); err != nil {
+				return err
+			}
+			continue
+		}
+		event.Type = models.SerialEventFound
+		_, err := moveSerial(tx, m.ProductID, serial, writtenOff, inStock, event, ErrSerialExists)
+		if errors.Is(err, ErrSerialExists) {
+			err = createSerial(tx, m, serial, models.SerialEventFound)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReturnSerial takes a sold serial number back into stock at warehouseID,
+// or at the warehouse it was sold from if that is zero, posting a return
+// movement against its sale.
+func ReturnSerial(tx *gorm.DB, id uint, warehouseID uint, userID *uint) (*models.SerialNumber, error) {
+	var number models.SerialNumber
+	if err := tx.First(&number, id).Error; err != nil {
+		return nil, err
+	}
+	// The product is locked before the serial, as when selling it.
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Product{}, number.ProductID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&number, number.ID).Error; err != nil {
+		return nil, err
+	}
+	if number.Status != models.SerialSold {
+		return nil, ErrSerialStatus
+	}
+
+	var sold models.SerialEvent
+	if err := tx.Where("serial_number_id = ? AND type = ?", number.ID, models.SerialEventSold).
+		Order("id DESC").First(&sold).Error; err != nil {
+		return nil, err
+	}
+	if warehouseID == 0 {
+		warehouseID = sold.WarehouseID
+	}
+
+	movement := models.StockMovement{
+		ProductID:     number.ProductID,
+		WarehouseID:   warehouseID,
+		Type:          models.MovementReturn,
+		Quantity:      measure.FromInt(1),
+		Reason:        "Returned serial " + number.Serial,
+		ReferenceType: sold.ReferenceType,
+		ReferenceID:   sold.ReferenceID,
+		UserID:        userID,
+	}
+	if err := Post(tx, &movement); err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&number).Updates(map[string]interface{}{"status": models.SerialInStock, "warehouse_id": warehouseID}).Error; err != nil {
+		return nil, err
+	}
+	err := tx.Create(&models.SerialEvent{
+		SerialNumberID: number.ID,
+		Type:           models.SerialEventReturned,
+		WarehouseID:    warehouseID,
+		ReferenceType:  sold.ReferenceType,
+		ReferenceID:    sold.ReferenceID,
+		UserID:         userID,
+	}).Error
+	return &number, err
+}
+
+// serialState is where a serial number is: its status and, while in stock,
+// its warehouse.
+type serialState struct {
+	Status      string
+	WarehouseID *uint
+}
+
+// moveSerial moves a serial number of a product from state from to state to
+// and records event for it. If the serial number is unknown or not in state
+// from it returns notFound.
+func moveSerial(tx *gorm.DB, productID uint, serial string, from, to serialState, event models.SerialEvent, notFound error) (*models.SerialNumber, error) {
+	var number models.SerialNumber
+	err := tx.Where("product_id = ? AND serial = ?", productID, serial).First(&number).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", notFound, serial)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query := tx.Model(&number).Where("status = ?", from.Status)
+	if from.WarehouseID != nil {
+		query = query.Where("warehouse_id = ?", *from.WarehouseID)
+	}
+	result := query.Updates(map[string]interface{}{"status": to.Status, "warehouse_id": to.WarehouseID})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: %s", notFound, serial)
+	}
+	event.SerialNumberID = number.ID
+	return &number, tx.Create(&event).Error
+}
+
+// createSerial records a new serial number in stock at the warehouse of the
+// posted movement m, with an event of type eventType.
+func createSerial(tx *gorm.DB, m *models.StockMovement, serial, eventType string) error {
+	number := models.SerialNumber{ProductID: m.ProductID, Serial: serial, Status: models.SerialInStock, WarehouseID: &m.WarehouseID}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&number)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrSerialExists, serial)
+	}
+	return tx.Create(&models.SerialEvent{
+		SerialNumberID: number.ID,
+		Type:           eventType,
+		WarehouseID:    m.WarehouseID,
+		ReferenceType:  m.ReferenceType,
+		ReferenceID:    m.ReferenceID,
+		UserID:         m.UserID,
+	}).Error
+}
+
+// serializedProducts returns which of the products are serialized.
+func serializedProducts(tx *gorm.DB, productIDs []uint) (map[uint]bool, error) {
+	var ids []uint
+	if err := tx.Model(&models.Product{}).Where("id IN ? AND serialized", productIDs).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	serialized := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		serialized[id] = true
+	}
+	return serialized, nil
+}
+
+// serialsMatch reports whether count serial numbers fit a line of quantity,
+// positive or negative: one per unit for a serialized product, none
+// otherwise.
+func serialsMatch(serialized bool, quantity measure.Quantity, count int) bool {
+	if !serialized {
+		return count == 0
+	}
+	if quantity.IsNegative() {
+		quantity = quantity.Neg()
+	}
+	return quantity.IsWhole() && count == quantity.Count()
+}
@@ -0,0 +1,285 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"gorm.io/gorm"
+)
+
+// ErrTransferStatus is returned when a transfer is not in a state that
+// allows the requested step.
+var ErrTransferStatus = errors.New("transfer is not in a valid state for this action")
+
+// LineReceipt is what arrived for one transfer line. Quantity is booked into
+// the destination; Discrepancy is written off as lost or damaged in transit.
+// Lines shipped with serial numbers name them: Serials arrived and
+// DiscrepancySerials are written off.
+type LineReceipt struct {
+	LineID             uint
+	Quantity           measure.Quantity
+	Discrepancy        measure.Quantity
+	DiscrepancyReason  string
+	Serials            []string
+	DiscrepancySerials []string
+}
+
+// ReceiptError is returned when a LineReceipt does not fit its line.
+type ReceiptError struct {
+	LineID  uint
+	Message string
+}
+
+func (e *ReceiptError) Error() string {
+	return fmt.Sprintf("transfer line %d: %s", e.LineID, e.Message)
+}
+
+// Ship takes every line of a draft transfer out of the source warehouse with
+// a transfer_out movement, per lot for lot-tracked products, puts the
+// serial numbers of serialized products in transit and marks it shipped.
+// The transfer must have been loaded with its lines and their serials and
+// locked by tx.
+func Ship(tx *gorm.DB, transfer *models.Transfer, userID uint) error {
+	if transfer.Status != models.TransferDraft {
+		return ErrTransferStatus
+	}
+
+	productIDs := make([]uint, len(transfer.Lines))
+	for i, line := range transfer.Lines {
+		productIDs[i] = line.ProductID
+	}
+	serialized, err := serializedProducts(tx, productIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range linesByProduct(transfer.Lines) {
+		if !serialsMatch(serialized[line.ProductID], line.Quantity, len(line.Serials)) {
+			return ErrSerialCount
+		}
+		_, err := PostFEFO(tx, &models.StockMovement{
+			ProductID:     line.ProductID,
+			WarehouseID:   transfer.FromWarehouseID,
+			Type:          models.MovementTransferOut,
+			Quantity:      line.Quantity.Neg(),
+			ReferenceType: models.ReferenceTransfer,
+			ReferenceID:   &transfer.ID,
+			UserID:        &userID,
+		}, false)
+		if err != nil {
+			return err
+		}
+		for _, serial := range line.Serials {
+			_, err := moveSerial(tx, line.ProductID, serial.Serial,
+				serialState{models.SerialInStock, &transfer.FromWarehouseID}, serialState{models.SerialInTransit, nil},
+				models.SerialEvent{
+					Type:          models.SerialEventShipped,
+					WarehouseID:   transfer.FromWarehouseID,
+					ReferenceType: models.ReferenceTransfer,
+					ReferenceID:   &transfer.ID,
+					UserID:        &userID,
+				}, ErrSerialNotInStock)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	now := time.Now()
+	transfer.Status = models.TransferShipped
+	transfer.ShippedAt = &now
+	transfer.ShippedByID = &userID
+	return tx.Model(transfer).Select("status", "shipped_at", "shipped_by_id").Updates(transfer).Error
+}
+
+// Receive books what arrived into the destination warehouse with transfer_in
+// movements and records discrepancies. Lots arrive in the order they were
+// shipped; serial numbers that arrived are put in stock there and the rest
+// written off. Lines may be received over several calls; the transfer is
+// received once nothing is outstanding. The transfer must have been loaded
+// with its lines and their serials and locked by tx.
+func Receive(tx *gorm.DB, transfer *models.Transfer, receipts []LineReceipt, userID uint) error {
+	if !transfer.IsInTransit() {
+		return ErrTransferStatus
+	}
+
+	lines := make(map[uint]*models.TransferLine, len(transfer.Lines))
+	for i := range transfer.Lines {
+		lines[transfer.Lines[i].ID] = &transfer.Lines[i]
+	}
+
+	received := make(map[uint]measure.Quantity)
+	var arrived, lost []lineSerial
+	for _, receipt := range receipts {
+		line, ok := lines[receipt.LineID]
+		if !ok {
+			return &ReceiptError{receipt.LineID, "not part of this transfer"}
+		}
+		if receipt.Quantity.IsNegative() || receipt.Discrepancy.IsNegative() {
+			return &ReceiptError{receipt.LineID, "quantities must not be negative"}
+		}
+		if receipt.Quantity.Add(receipt.Discrepancy).Cmp(line.Outstanding()) > 0 {
+			return &ReceiptError{receipt.LineID, fmt.Sprintf("only %s outstanding", line.Outstanding())}
+		}
+		if receipt.Discrepancy.IsPositive() && receipt.DiscrepancyReason == "" {
+			return &ReceiptError{receipt.LineID, "a discrepancy needs a reason"}
+		}
+		shipped := len(line.Serials) > 0
+		if !serialsMatch(shipped, receipt.Quantity, len(receipt.Serials)) ||
+			!serialsMatch(shipped, receipt.Discrepancy, len(receipt.DiscrepancySerials)) {
+			return &ReceiptError{receipt.LineID, "give one serial number per unit received or written off"}
+		}
+		for _, serial := range append(append([]string(nil), receipt.Serials...), receipt.DiscrepancySerials...) {
+			if !line.HasSerial(serial) {
+				return &ReceiptError{receipt.LineID, "serial number " + serial + " was not shipped on this line"}
+			}
+		}
+		for _, serial := range receipt.Serials {
+			arrived = append(arrived, lineSerial{line.ProductID, serial})
+		}
+		for _, serial := range receipt.DiscrepancySerials {
+			lost = append(lost, lineSerial{line.ProductID, serial})
+		}
+
+		line.ReceivedQuantity = line.ReceivedQuantity.Add(receipt.Quantity)
+		line.DiscrepancyQuantity = line.DiscrepancyQuantity.Add(receipt.Discrepancy)
+		if receipt.DiscrepancyReason != "" {
+			line.DiscrepancyReason = receipt.DiscrepancyReason
+		}
+		received[line.ProductID] = received[line.ProductID].Add(receipt.Quantity)
+		if err := tx.Model(line).Select("received_quantity", "discrepancy_quantity", "discrepancy_reason").
+			Updates(line).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, line := range linesByProduct(transfer.Lines) {
+		quantity := received[line.ProductID]
+		if quantity.IsZero() {
+			continue
+		}
+		// Several lines may share a product; post it once.
+		delete(received, line.ProductID)
+		lots, err := lotsInTransit(tx, transfer.ID, line.ProductID)
+		if err != nil {
+			return err
+		}
+		for quantity.IsPositive() {
+			movement := models.StockMovement{
+				ProductID:     line.ProductID,
+				WarehouseID:   transfer.ToWarehouseID,
+				Type:          models.MovementTransferIn,
+				Quantity:      quantity,
+				ReferenceType: models.ReferenceTransfer,
+				ReferenceID:   &transfer.ID,
+				UserID:        &userID,
+			}
+			// Stock shipped in no lot arrives in no lot.
+			if len(lots) > 0 {
+				movement.LotID = &lots[0].LotID
+				if lots[0].Quantity.Cmp(quantity) < 0 {
+					movement.Quantity = lots[0].Quantity
+				}
+				lots = lots[1:]
+			}
+			if err := Post(tx, &movement); err != nil {
+				return err
+			}
+			quantity = quantity.Sub(movement.Quantity)
+		}
+	}
+
+	event := models.SerialEvent{
+		WarehouseID:   transfer.ToWarehouseID,
+		ReferenceType: models.ReferenceTransfer,
+		ReferenceID:   &transfer.ID,
+		UserID:        &userID,
+	}
+	inTransit := serialState{models.SerialInTransit, nil}
+	event.Type = models.SerialEventArrived
+	for _, serial := range arrived {
+		to := serialState{models.SerialInStock, &transfer.ToWarehouseID}
+		if _, err := moveSerial(tx, serial.ProductID, serial.Serial, inTransit, to, event, ErrSerialNotInTransit); err != nil {
+			return err
+		}
+	}
+	event.Type = models.SerialEventWrittenOff
+	for _, serial := range lost {
+		to := serialState{models.SerialWrittenOff, nil}
+		if _, err := moveSerial(tx, serial.ProductID, serial.Serial, inTransit, to, event, ErrSerialNotInTransit); err != nil {
+			return err
+		}
+	}
+
+	transfer.Status = models.TransferReceived
+	for _, line := range transfer.Lines {
+		if line.Outstanding().IsPositive() {
+			transfer.Status = models.TransferPartiallyReceived
+			break
+		}
+	}
+	if transfer.Status == models.TransferReceived {
+		now := time.Now()
+		transfer.ReceivedAt = &now
+	}
+	return tx.Model(transfer).Select("status", "received_at").Updates(transfer).Error
+}
+
+// InTransit is the quantity of a product shipped towards a warehouse and not
+// yet received or written off.
+type InTransit struct {
+	ProductID       uint             `json:"product_id"`
+	FromWarehouseID uint             `json:"from_warehouse_id"`
+	ToWarehouseID   uint             `json:"to_warehouse_id"`
+	Quantity        measure.Quantity `json:"quantity"`
+}
+
+// InTransitQuantities sums the outstanding quantities of shipped transfers
+// per product and route. db may carry extra conditions on transfers and
+// transfer_lines.
+func InTransitQuantities(db *gorm.DB) ([]InTransit, error) {
+	quantities := []InTransit{}
+	err := db.Table("transfer_lines").
+		Select(`transfer_lines.product_id, transfers.from_warehouse_id, transfers.to_warehouse_id,
+			SUM(transfer_lines.quantity - transfer_lines.received_quantity - transfer_lines.discrepancy_quantity) AS quantity`).
+		Joins("JOIN transfers ON transfers.id = transfer_lines.transfer_id").
+		Where("transfers.status IN ?", []string{models.TransferShipped, models.TransferPartiallyReceived}).
+		Group("transfer_lines.product_id, transfers.from_warehouse_id, transfers.to_warehouse_id").
+		Having("SUM(transfer_lines.quantity - transfer_lines.received_quantity - transfer_lines.discrepancy_quantity) > 0").
+		Order("transfer_lines.product_id").
+		Scan(&quantities).Error
+	return quantities, err
+}
+
+// lotsInTransit returns the lots of a product shipped on a transfer and not
+// yet received, in the order they were shipped.
+func lotsInTransit(tx *gorm.DB, transferID, productID uint) ([]lotStock, error) {
+	var lots []lotStock
+	err := tx.Model(&models.StockMovement{}).
+		Select("lot_id, -SUM(quantity) AS quantity").
+		Where("reference_type = ? AND reference_id = ? AND product_id = ? AND lot_id IS NOT NULL AND type IN ?",
+			models.ReferenceTransfer, transferID, productID, []string{models.MovementTransferOut, models.MovementTransferIn}).
+		Group("lot_id").
+		Having("SUM(quantity) < 0").
+		Order("MIN(id)").
+		Scan(&lots).Error
+	return lots, err
+}
+
+// lineSerial is a serial number of a transfer line's product.
+type lineSerial struct {
+	ProductID uint
+	Serial    string
+}
+
+// linesByProduct returns the lines sorted by product ID, the order in which
+// products are locked everywhere stock moves.
+func linesByProduct(lines []models.TransferLine) []models.TransferLine {
+	sorted := append([]models.TransferLine(nil), lines...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ProductID < sorted[j].ProductID })
+	return sorted
+}
@@ -0,0 +1,29 @@
+// Package jobs runs periodic background work inside the API process.
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every calls fn once per interval until ctx is done. Failures are logged
+// and the job carries on at the next tick. Each call gets a context that is
+// cancelled after one interval so a stuck run cannot pile up behind itself.
+func Every(ctx context.Context, name string, interval time.Duration, fn func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runCtx, cancel := context.WithTimeout(ctx, interval)
+			if err := fn(runCtx); err != nil {
+				log.Printf("Job %s failed: %v", name, err)
+			}
+			cancel()
+		}
+	}
+}
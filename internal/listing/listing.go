@@ -0,0 +1,292 @@
+// Package listing applies the pagination, sorting and filtering query
+// parameters shared by list endpoints. A page is selected either by
+// ?limit and ?offset or, for stable paging through changing data, by
+// ?limit and the ?cursor returned with the previous page.
+package listing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/pkg/money"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultLimit is the page size without ?limit.
+	DefaultLimit = 50
+	// MaxLimit is the largest page size ?limit accepts.
+	MaxLimit = 200
+)
+
+// Error is a list query parameter the client got wrong.
+type Error struct {
+	message string
+}
+
+func (e *Error) Error() string {
+	return e.message
+}
+
+func errorf(format string, args ...interface{}) error {
+	return &Error{fmt.Sprintf(format, args...)}
+}
+
+// Parser converts a query parameter to a query argument.
+type Parser func(value string) (interface{}, error)
+
+// Filter narrows a query by the value of its query parameter.
+type Filter func(db *gorm.DB, value string) (*gorm.DB, error)
+
+// Spec describes what a list endpoint lets clients sort and filter by.
+type Spec struct {
+	// Sorts maps the field names ?sort accepts to columns. Rows with equal
+	// values are ordered by id.
+	Sorts map[string]string
+	// DefaultSort is the sort without ?sort, a field name prefixed with
+	// "-" for descending order.
+	DefaultSort string
+	// Filters maps query parameters to the filters they apply.
+	Filters map[string]Filter
+	// Preload, if set, loads relations of the rows on a page.
+	Preload func(db *gorm.DB) *gorm.DB
+}
+
+// Pagination describes a page and how to get the next one.
+type Pagination struct {
+	// Total counts the rows matching the filters on all pages.
+	Total  int64 `json:"total"`
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+	// NextCursor fetches the next page; it is null on the last page.
+	NextCursor *string `json:"next_cursor"`
+}
+
+// Page is one page of a list response.
+type Page struct {
+	Data       interface{} `json:"data"`
+	Pagination Pagination  `json:"pagination"`
+}
+
+// cursor marks the last row of a page: its sort value and id.
+type cursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	ID    uint        `json:"id"`
+}
+
+func (c cursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(value string) (cursor, error) {
+	var c cursor
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, errorf("Invalid cursor")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&c); err != nil {
+		return c, errorf("Invalid cursor")
+	}
+	if number, ok := c.Value.(json.Number); ok {
+		c.Value = number.String()
+	}
+	return c, nil
+}
+
+// params are the parsed list query parameters.
+type params struct {
+	limit  int
+	offset int
+	sort   string
+	column string
+	desc   bool
+	after  *cursor
+}
+
+func parse(c *gin.Context, spec Spec) (params, error) {
+	p := params{limit: DefaultLimit, sort: spec.DefaultSort}
+	if raw := c.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > MaxLimit {
+			return p, errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		p.limit = value
+	}
+	if raw := c.Query("offset"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 {
+			return p, errorf("offset must be a non-negative integer")
+		}
+		p.offset = value
+	}
+	if raw := c.Query("sort"); raw != "" {
+		p.sort = raw
+	}
+
+	name := strings.TrimPrefix(p.sort, "-")
+	p.desc = name != p.sort
+	column, ok := spec.Sorts[name]
+	if !ok {
+		fields := make([]string, 0, len(spec.Sorts))
+		for field := range spec.Sorts {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		return p, errorf("sort must be one of %s, optionally prefixed with -", strings.Join(fields, ", "))
+	}
+	p.column = column
+
+	if raw := c.Query("cursor"); raw != "" {
+		if p.offset != 0 {
+			return p, errorf("Use either cursor or offset, not both")
+		}
+		after, err := decodeCursor(raw)
+		if err != nil {
+			return p, err
+		}
+		if after.Sort != p.sort {
+			return p, errorf("cursor belongs to a different sort")
+		}
+		p.after = &after
+	}
+	return p, nil
+}
+
+// Find loads the page of the rows db selects that the request's query
+// parameters ask for into dest, a pointer to a slice, and returns it with
+// its pagination. Parameter mistakes are returned as *Error.
+func Find(c *gin.Context, db *gorm.DB, spec Spec, dest interface{}) (*Page, error) {
+	p, err := parse(c, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(spec.Filters))
+	for name := range spec.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value := c.Query(name); value != "" {
+			if db, err = spec.Filters[name](db, value); err != nil {
+				return nil, errorf("Invalid %s: %s", name, value)
+			}
+		}
+	}
+	db = db.Session(&gorm.Session{})
+
+	page := &Page{Data: dest, Pagination: Pagination{Limit: p.limit, Offset: p.offset}}
+	if err := db.Model(dest).Count(&page.Pagination.Total).Error; err != nil {
+		return nil, err
+	}
+
+	query := db
+	if spec.Preload != nil {
+		query = spec.Preload(query)
+	}
+	direction, compare := "ASC", ">"
+	if p.desc {
+		direction, compare = "DESC", "<"
+	}
+	if p.after != nil {
+		query = query.Where(fmt.Sprintf("(%s, id) %s (?, ?)", p.column, compare), p.after.Value, p.after.ID)
+	}
+	result := query.Order(fmt.Sprintf("%s %s, id %s", p.column, direction, direction)).
+		Limit(p.limit + 1).Offset(p.offset).Find(dest)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	// One row more than the page was loaded to tell whether another page
+	// follows.
+	rows := reflect.ValueOf(dest).Elem()
+	if rows.IsNil() {
+		rows.Set(reflect.MakeSlice(rows.Type(), 0, 0))
+	}
+	if rows.Len() > p.limit {
+		rows.Set(rows.Slice(0, p.limit))
+		last := rows.Index(p.limit - 1)
+		schema := result.Statement.Schema
+		value, _ := schema.LookUpField(p.column).ValueOf(result.Statement.Context, last)
+		id, _ := schema.LookUpField("id").ValueOf(result.Statement.Context, last)
+		next := cursor{Sort: p.sort, Value: value, ID: toUint(id)}.encode()
+		page.Pagination.NextCursor = &next
+	}
+	return page, nil
+}
+
+func toUint(value interface{}) uint {
+	id, _ := value.(uint)
+	return id
+}
+
+// Equal filters column = value.
+func Equal(column string, parse Parser) Filter {
+	return compare(column, "=", parse)
+}
+
+// AtLeast filters column >= value.
+func AtLeast(column string, parse Parser) Filter {
+	return compare(column, ">=", parse)
+}
+
+// AtMost filters column <= value.
+func AtMost(column string, parse Parser) Filter {
+	return compare(column, "<=", parse)
+}
+
+func compare(column, operator string, parse Parser) Filter {
+	return func(db *gorm.DB, value string) (*gorm.DB, error) {
+		arg, err := parse(value)
+		if err != nil {
+			return nil, err
+		}
+		return db.Where(fmt.Sprintf("%s %s ?", column, operator), arg), nil
+	}
+}
+
+// Until filters column up to a time, or to the end of a day given as a
+// date.
+func Until(column string) Filter {
+	return func(db *gorm.DB, value string) (*gorm.DB, error) {
+		if day, err := time.Parse("2006-01-02", value); err == nil {
+			return db.Where(column+" < ?", day.AddDate(0, 0, 1)), nil
+		}
+		return compare(column, "<=", Time)(db, value)
+	}
+}
+
+// Uint parses an ID.
+func Uint(value string) (interface{}, error) {
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// Int parses a whole number.
+func Int(value string) (interface{}, error) {
+	return strconv.Atoi(value)
+}
+
+// Decimal parses an exact amount.
+func Decimal(value string) (interface{}, error) {
+	return money.Parse(value)
+}
+
+// Time parses an RFC 3339 time or a date, which means its start.
+func Time(value string) (interface{}, error) {
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,77 @@
+package listing
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testSpec = Spec{
+	Sorts:       map[string]string{"id": "id", "name": "name"},
+	DefaultSort: "-id",
+}
+
+func testContext(target string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", target, nil)
+	return c
+}
+
+func TestParse(t *testing.T) {
+	p, err := parse(testContext("/"), testSpec)
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	if p.limit != DefaultLimit || p.offset != 0 || p.column != "id" || !p.desc {
+		t.Errorf("Expected the defaults, got %+v", p)
+	}
+
+	p, err = parse(testContext("/?limit=10&offset=20&sort=name"), testSpec)
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	if p.limit != 10 || p.offset != 20 || p.column != "name" || p.desc {
+		t.Errorf("Expected limit 10, offset 20 and ascending name, got %+v", p)
+	}
+
+	after := cursor{Sort: "name", Value: "Mouse", ID: 7}.encode()
+	p, err = parse(testContext("/?sort=name&cursor="+after), testSpec)
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	if p.after == nil || p.after.Value != "Mouse" || p.after.ID != 7 {
+		t.Errorf("Expected the cursor to round-trip, got %+v", p.after)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	cursor := cursor{Sort: "name", Value: "Mouse", ID: 7}.encode()
+	for _, target := range []string{
+		"/?limit=0",
+		"/?limit=1000",
+		"/?offset=-1",
+		"/?sort=password",
+		"/?cursor=not-a-cursor",
+		"/?cursor=" + cursor,
+		"/?sort=name&offset=5&cursor=" + cursor,
+	} {
+		_, err := parse(testContext(target), testSpec)
+		var paramErr *Error
+		if !errors.As(err, &paramErr) {
+			t.Errorf("parse(%q) error = %v, want *Error", target, err)
+		}
+	}
+}
+
+func TestCursorKeepsNumbersExact(t *testing.T) {
+	c, err := decodeCursor(cursor{Sort: "stock", Value: 9007199254740993, ID: 1}.encode())
+	if err != nil {
+		t.Fatalf("decodeCursor() error = %v", err)
+	}
+	if c.Value != "9007199254740993" {
+		t.Errorf("Expected the exact number, got %v", c.Value)
+	}
+}
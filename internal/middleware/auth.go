@@ -3,13 +3,23 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
 	"github.com/edwinjordan/erp_golang/pkg/utils"
 	"github.com/gin-gonic/gin"
 )
 
+// AuthMiddleware authenticates the request with a Bearer access token or,
+// for machine clients, an X-API-Key header.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			authenticateAPIKey(c, key)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
@@ -25,45 +35,49 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		claims, err := utils.ValidateToken(parts[1])
-		if err != nil {
+		if err != nil || claims.Purpose != "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		c.Set("userID", claims.UserID)
-		c.Set("roleID", claims.RoleID)
-		c.Next()
-	}
-}
-
-func RBACMiddleware(requiredPermission string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		roleID, exists := c.Get("roleID")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Role not found in context"})
+		// The role is read from the database rather than the token so role
+		// changes and disabled accounts take effect immediately.
+		var user models.User
+		if err := database.DB.Select("id", "role_id", "disabled_at", "password_changed_at", "sessions_revoked_at").
+			First(&user, claims.UserID).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		// Role-based access: Admin (roleID: 1) has full access
-		// Users (roleID: 2) have limited access
-		if roleID.(uint) == 1 {
-			// Admin has access to everything
-			c.Next()
+		if user.IsDisabled() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is disabled"})
+			c.Abort()
 			return
 		}
 
-		// For non-admin users, check specific permissions
-		// In a production system, you would check permissions from database
-		// For now, we allow basic read operations for all authenticated users
-		if requiredPermission == "read" {
-			c.Next()
+		// NumericDate drops sub-second precision, so compare at whole seconds
+		// to keep tokens issued in the same second as the revocation valid.
+		if claims.IssuedAt == nil || claims.IssuedAt.Time.Before(user.TokensValidAfter().Truncate(time.Second)) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
 			return
 		}
 
-		// Deny access for write operations to non-admin users
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
-		c.Abort()
+		c.Set("userID", user.ID)
+		c.Set("roleID", user.RoleID)
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
+
+// IsTokenRevoked reports whether an access token ID has been revoked. It is
+// installed into utils.ValidateToken with utils.SetRevocationCheck.
+func IsTokenRevoked(jti string) (bool, error) {
+	var count int64
+	if err := database.DB.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
 	}
+	return count > 0, nil
 }
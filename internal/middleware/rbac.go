@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// permissionCacheTTL bounds how long a role's permissions are served from
+// memory. Changes made through this instance invalidate the cache
+// immediately; the TTL covers changes made by other instances.
+const permissionCacheTTL = time.Minute
+
+type cachedPermissions struct {
+	names    map[string]struct{}
+	loadedAt time.Time
+}
+
+var (
+	permissionCacheMu sync.RWMutex
+	permissionCache   = map[uint]cachedPermissions{}
+)
+
+// InvalidateRolePermissions drops the cached permissions of a role so the
+// next request reloads them from role_permissions.
+func InvalidateRolePermissions(roleID uint) {
+	permissionCacheMu.Lock()
+	delete(permissionCache, roleID)
+	permissionCacheMu.Unlock()
+}
+
+// InvalidateAllPermissions clears the permission cache for every role.
+func InvalidateAllPermissions() {
+	permissionCacheMu.Lock()
+	permissionCache = map[uint]cachedPermissions{}
+	permissionCacheMu.Unlock()
+}
+
+// RolePermissions returns the permission names granted to a role.
+func RolePermissions(roleID uint) (map[string]struct{}, error) {
+	permissionCacheMu.RLock()
+	cached, ok := permissionCache[roleID]
+	permissionCacheMu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < permissionCacheTTL {
+		return cached.names, nil
+	}
+
+	var names []string
+	err := database.DB.Table("permissions").
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Where("role_permissions.role_id = ? AND permissions.deleted_at IS NULL", roleID).
+		Pluck("permissions.name", &names).Error
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+
+	permissionCacheMu.Lock()
+	permissionCache[roleID] = cachedPermissions{names: set, loadedAt: time.Now()}
+	permissionCacheMu.Unlock()
+
+	return set, nil
+}
+
+// RBACMiddleware allows the request only if the caller's role has been
+// granted requiredPermission and, for API keys, the key has been granted it
+// too. It must run after AuthMiddleware.
+func RBACMiddleware(requiredPermission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roleID, exists := c.Get("roleID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Role not found in context"})
+			c.Abort()
+			return
+		}
+
+		permissions, err := RolePermissions(roleID.(uint))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions"})
+			c.Abort()
+			return
+		}
+
+		if _, ok := permissions[requiredPermission]; !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		// API keys are further limited to the permissions they were issued with.
+		if keyPermissions, ok := c.Get("apiKeyPermissions"); ok {
+			if _, ok := keyPermissions.(map[string]struct{})[requiredPermission]; !ok {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
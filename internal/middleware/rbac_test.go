@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/database"
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB connects to the PostgreSQL database named by TEST_DATABASE_URL.
+// Tests needing it are skipped when it is not set.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Role{}, &models.Permission{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = previous })
+	return db
+}
+
+// primePermissions caches names as the permissions of a role, so the
+// middleware can be tested without a database.
+func primePermissions(t *testing.T, roleID uint, names ...string) {
+	t.Helper()
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	permissionCacheMu.Lock()
+	permissionCache[roleID] = cachedPermissions{names: set, loadedAt: time.Now()}
+	permissionCacheMu.Unlock()
+	t.Cleanup(InvalidateAllPermissions)
+}
+
+// rbacStatus runs a request through RBACMiddleware with the given context
+// values set, as AuthMiddleware would, and returns the status.
+func rbacStatus(required string, values map[string]interface{}) int {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		for key, value := range values {
+			c.Set(key, value)
+		}
+	})
+	router.GET("/", RBACMiddleware(required), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w.Code
+}
+
+func TestRBACMiddleware(t *testing.T) {
+	primePermissions(t, 1, models.PermProductsRead, models.PermProductsWrite)
+
+	tests := []struct {
+		name     string
+		required string
+		values   map[string]interface{}
+		want     int
+	}{
+		{"granted", models.PermProductsRead, map[string]interface{}{"roleID": uint(1)}, http.StatusOK},
+		{"not granted", models.PermUnitsWrite, map[string]interface{}{"roleID": uint(1)}, http.StatusForbidden},
+		{"no role", models.PermProductsRead, nil, http.StatusUnauthorized},
+		{"key granted", models.PermProductsRead, map[string]interface{}{
+			"roleID": uint(1), "apiKeyPermissions": map[string]struct{}{models.PermProductsRead: {}},
+		}, http.StatusOK},
+		{"key narrower than role", models.PermProductsWrite, map[string]interface{}{
+			"roleID": uint(1), "apiKeyPermissions": map[string]struct{}{models.PermProductsRead: {}},
+		}, http.StatusForbidden},
+		{"key wider than role", models.PermUnitsWrite, map[string]interface{}{
+			"roleID": uint(1), "apiKeyPermissions": map[string]struct{}{models.PermUnitsWrite: {}},
+		}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := rbacStatus(tt.required, tt.values); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidatePermissions(t *testing.T) {
+	primePermissions(t, 1, models.PermProductsRead)
+	primePermissions(t, 2, models.PermProductsRead)
+	cached := func(roleID uint) bool {
+		permissionCacheMu.RLock()
+		defer permissionCacheMu.RUnlock()
+		_, ok := permissionCache[roleID]
+		return ok
+	}
+
+	InvalidateRolePermissions(1)
+	if cached(1) || !cached(2) {
+		t.Errorf("Only role 1 should have been dropped, cached: 1=%v 2=%v", cached(1), cached(2))
+	}
+
+	InvalidateAllPermissions()
+	if cached(2) {
+		t.Error("InvalidateAllPermissions should drop every role")
+	}
+}
+
+func TestRolePermissionsCache(t *testing.T) {
+	db := testDB(t)
+	t.Cleanup(InvalidateAllPermissions)
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	read := models.Permission{Name: "rbac-test-read-" + suffix}
+	write := models.Permission{Name: "rbac-test-write-" + suffix}
+	db.Create(&read)
+	db.Create(&write)
+	role := models.Role{Name: "rbac-test-" + suffix, Permissions: []models.Permission{read}}
+	db.Create(&role)
+	t.Cleanup(func() {
+		db.Model(&role).Association("Permissions").Clear()
+		db.Unscoped().Delete(&role)
+		db.Unscoped().Delete(&read)
+		db.Unscoped().Delete(&write)
+	})
+	allowed := func(name string) bool {
+		t.Helper()
+		return rbacStatus(name, map[string]interface{}{"roleID": role.ID}) == http.StatusOK
+	}
+
+	if !allowed(read.Name) || allowed(write.Name) {
+		t.Fatal("Expected the role to hold only the read permission")
+	}
+
+	// Editing the role behind the cache's back is not seen until the entry
+	// is invalidated, as the role handlers do.
+	db.Model(&role).Association("Permissions").Append(&write)
+	if allowed(write.Name) {
+		t.Error("The cached permissions should still be served")
+	}
+	InvalidateRolePermissions(role.ID)
+	if !allowed(write.Name) {
+		t.Error("The role edit should be seen once the role is invalidated")
+	}
+
+	db.Model(&role).Association("Permissions").Delete(&write)
+	InvalidateAllPermissions()
+	if allowed(write.Name) {
+		t.Error("The revoked permission should be gone once the cache is cleared")
+	}
+
+	// Edits made by another instance are picked up once the entry expires.
+	db.Model(&role).Association("Permissions").Append(&write)
+	permissionCacheMu.Lock()
+	entry := permissionCache[role.ID]
+	entry.loadedAt = time.Now().Add(-permissionCacheTTL)
+	permissionCache[role.ID] = entry
+	permissionCacheMu.Unlock()
+	if !allowed(write.Name) {
+		t.Error("An expired entry should be reloaded")
+	}
+}
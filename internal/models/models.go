@@ -1,28 +1,159 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/edwinjordan/erp_golang/pkg/measure"
+	"github.com/edwinjordan/erp_golang/pkg/money"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type User struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	Username  string         `gorm:"unique;not null" json:"username"`
-	Email     string         `gorm:"unique;not null" json:"email"`
-	Password  string         `gorm:"not null" json:"-"`
-	RoleID    uint           `json:"role_id"`
-	Role      Role           `gorm:"foreignKey:RoleID" json:"role"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                uint           `gorm:"primaryKey" json:"id"`
+	Username          string         `gorm:"unique;not null" json:"username"`
+	Email             string         `gorm:"unique;not null" json:"email"`
+	Password          string         `gorm:"not null" json:"-"`
+	RoleID            uint           `json:"role_id"`
+	Role              Role           `gorm:"foreignKey:RoleID" json:"role"`
+	DisabledAt        *time.Time     `json:"disabled_at"`
+	TOTPEnabled       bool           `gorm:"not null;default:false" json:"totp_enabled"`
+	TOTPSecret        string         `json:"-"`
+	TOTPLastStep      int64          `gorm:"not null;default:0" json:"-"`
+	PasswordChangedAt *time.Time     `json:"-"`
+	SessionsRevokedAt *time.Time     `json:"-"`
+	OIDCIssuer        *string        `gorm:"uniqueIndex:idx_users_oidc_identity" json:"oidc_issuer,omitempty"`
+	OIDCSubject       *string        `gorm:"uniqueIndex:idx_users_oidc_identity" json:"-"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Purposes of a UserToken.
+const (
+	TokenPurposeInvite        = "invite"
+	TokenPurposePasswordReset = "password_reset"
+)
+
+// UserToken is a single-use token sent to a user out of band, such as an
+// invitation or password reset link. Only the SHA-256 hash of the token is stored.
+type UserToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	Purpose   string     `gorm:"not null" json:"purpose"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RefreshToken is a long-lived, single-use token exchanged at /refresh for a
+// new access token. Each exchange revokes the presented token and issues a
+// replacement in the same family; presenting a revoked token revokes the
+// whole family.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	FamilyID  string     `gorm:"index;not null" json:"-"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	UserAgent string     `json:"user_agent"`
+	IPAddress string     `json:"ip_address"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// OIDCAuthRequest remembers a single sign-on login between the redirect to
+// the identity provider and the callback. Only the SHA-256 hash of the state
+// is stored.
+type OIDCAuthRequest struct {
+	StateHash    string `gorm:"primaryKey"`
+	Nonce        string `gorm:"not null"`
+	CodeVerifier string `gorm:"not null"`
+	// LinkUserID is set when a signed-in user links the identity to their
+	// account instead of signing in with it.
+	LinkUserID *uint
+	ExpiresAt  time.Time `gorm:"index;not null"`
+	CreatedAt  time.Time
+}
+
+// RecoveryCode is a single-use fallback for a lost TOTP device. Only the
+// SHA-256 hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RevokedToken records the jti of an access token revoked before its expiry.
+// Rows can be pruned once ExpiresAt has passed.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey" json:"jti"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"index;not null" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKey authenticates a machine client through the X-API-Key header. The
+// key acts on behalf of its owner but is limited to its own permissions, so
+// it can never do more than the owner's role allows. Only the SHA-256 hash of
+// the key is stored; Prefix identifies it in listings.
+type APIKey struct {
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	Name        string       `gorm:"not null" json:"name"`
+	Prefix      string       `gorm:"not null" json:"prefix"`
+	KeyHash     string       `gorm:"uniqueIndex;not null" json:"-"`
+	UserID      uint         `gorm:"index;not null" json:"user_id"`
+	User        User         `gorm:"foreignKey:UserID" json:"-"`
+	CreatedByID uint         `json:"created_by_id"`
+	Permissions []Permission `gorm:"many2many:api_key_permissions;" json:"permissions"`
+	// AllowedIPs lists the IP addresses or CIDR ranges the key may be used
+	// from. An empty list allows any address.
+	AllowedIPs []string   `gorm:"serializer:json" json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// IsActive reports whether the key is neither revoked nor expired at now.
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// LoginThrottle holds the failed login counter of a throttle key such as
+// "user:alice" or "ip:10.0.0.1". It is shared by every API instance.
+type LoginThrottle struct {
+	Key           string     `gorm:"primaryKey" json:"key"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt *time.Time `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// LoginAttempt is an append-only audit record of a login attempt.
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Username  string    `gorm:"index" json:"username"`
+	UserID    *uint     `gorm:"index" json:"user_id"`
+	IPAddress string    `gorm:"index" json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `gorm:"not null" json:"success"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
 }
 
 type Role struct {
 	ID          uint           `gorm:"primaryKey" json:"id"`
 	Name        string         `gorm:"unique;not null" json:"name"`
 	Description string         `json:"description"`
+	IsSystem    bool           `gorm:"not null;default:false" json:"is_system"`
+	RequireMFA  bool           `gorm:"not null;default:false" json:"require_mfa"`
 	Permissions []Permission   `gorm:"many2many:role_permissions;" json:"permissions"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
@@ -33,6 +164,7 @@ type Permission struct {
 	ID          uint           `gorm:"primaryKey" json:"id"`
 	Name        string         `gorm:"unique;not null" json:"name"`
 	Description string         `json:"description"`
+	IsSystem    bool           `gorm:"not null;default:false" json:"is_system"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
@@ -48,51 +180,600 @@ type Category struct {
 }
 
 type Unit struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	Name        string `gorm:"unique;not null" json:"name"`
+	Description string `json:"description"`
+	// AllowFractions lets quantities in the unit have decimals, such as
+	// 1.25 kilograms; otherwise they must be whole.
+	AllowFractions bool           `gorm:"not null;default:false" json:"allow_fractions"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Warehouse is a location that holds stock, such as a store or the back
+// warehouse. Stock without an explicit location goes to the default one.
+type Warehouse struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	Code      string         `gorm:"unique;not null" json:"code"`
+	Name      string         `gorm:"unique;not null" json:"name"`
+	Address   string         `json:"address"`
+	IsDefault bool           `gorm:"not null;default:false" json:"is_default"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Terminal is a point-of-sale till. Its sales take stock from its warehouse.
+type Terminal struct {
 	ID          uint           `gorm:"primaryKey" json:"id"`
-	Name        string         `gorm:"unique;not null" json:"name"`
-	Description string         `json:"description"`
+	Code        string         `gorm:"unique;not null" json:"code"`
+	Name        string         `gorm:"not null" json:"name"`
+	WarehouseID uint           `gorm:"index;not null" json:"warehouse_id"`
+	Warehouse   Warehouse      `gorm:"foreignKey:WarehouseID" json:"warehouse"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// StockLevel is the on-hand quantity of a product at one warehouse. Like
+// Product.Stock, which is the total over all warehouses, it is a cache of
+// the movement ledger.
+type StockLevel struct {
+	ID          uint             `gorm:"primaryKey" json:"-"`
+	ProductID   uint             `gorm:"uniqueIndex:idx_stock_levels_product_warehouse;not null" json:"product_id"`
+	WarehouseID uint             `gorm:"uniqueIndex:idx_stock_levels_product_warehouse;index;not null" json:"warehouse_id"`
+	Warehouse   Warehouse        `gorm:"foreignKey:WarehouseID" json:"warehouse"`
+	Quantity    measure.Quantity `gorm:"not null;default:0" json:"quantity"`
+	// Reserved is held by active reservations and cannot be sold.
+	Reserved  measure.Quantity `gorm:"not null;default:0" json:"reserved"`
+	Available measure.Quantity `gorm:"-" json:"available"`
+	// ReorderPoint and ReorderQuantity override the product's values at
+	// this warehouse when set.
+	ReorderPoint    *measure.Quantity `json:"reorder_point"`
+	ReorderQuantity *measure.Quantity `json:"reorder_quantity"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+// AfterFind fills in the stock available to sell.
+func (l *StockLevel) AfterFind(tx *gorm.DB) error {
+	l.Available = l.Quantity.Sub(l.Reserved)
+	return nil
+}
+
+// LowStockAlert marks a product that has been reported low on stock at a
+// warehouse, so the alert is raised once rather than on every check. It is
+// removed when stock recovers.
+type LowStockAlert struct {
+	ProductID   uint      `gorm:"primaryKey;autoIncrement:false" json:"product_id"`
+	WarehouseID uint      `gorm:"primaryKey;autoIncrement:false" json:"warehouse_id"`
+	RaisedAt    time.Time `gorm:"not null" json:"raised_at"`
+}
+
 type Product struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	// SKU is the product's unique stock-keeping code. Products created
+	// without one get DefaultSKU. Deleted products keep theirs but no longer
+	// reserve it.
+	SKU         *string  `gorm:"size:64;uniqueIndex:idx_products_sku_active,where:deleted_at IS NULL" json:"sku"`
+	Name        string   `gorm:"not null" json:"name"`
+	Description string   `json:"description"`
+	CategoryID  uint     `json:"category_id"`
+	Category    Category `gorm:"foreignKey:CategoryID" json:"category"`
+	// UnitID is the base unit, which stock is kept and priced in. Units
+	// lists the other units the product is bought or sold in.
+	UnitID uint             `json:"unit_id"`
+	Unit   Unit             `gorm:"foreignKey:UnitID" json:"unit"`
+	Units  []ProductUnit    `gorm:"foreignKey:ProductID" json:"units,omitempty"`
+	Price  money.Amount     `gorm:"not null" json:"price"`
+	Stock  measure.Quantity `gorm:"not null;default:0" json:"stock"`
+	// TrackLots requires stock to be received in lots, which are then sold
+	// first-expired-first-out.
+	TrackLots bool `gorm:"not null;default:false" json:"track_lots"`
+	// Serialized requires a serial number for every unit received and
+	// sold.
+	Serialized bool `gorm:"not null;default:false" json:"serialized"`
+	// HasVariants marks a parent product, such as a shirt sold in several
+	// sizes and colours. A parent holds no stock and is not sold; its
+	// variants, one per combination of its Attributes, are.
+	HasVariants bool               `gorm:"not null;default:false" json:"has_variants"`
+	Attributes  []ProductAttribute `gorm:"serializer:json" json:"attributes,omitempty"`
+	// ParentID and Options are set on a variant: its parent and its value
+	// of each of the parent's attributes, in the same order.
+	ParentID *uint     `gorm:"index" json:"parent_id,omitempty"`
+	Options  []string  `gorm:"serializer:json" json:"options,omitempty"`
+	Variants []Product `gorm:"foreignKey:ParentID" json:"variants,omitempty"`
+	// PriceOverride marks a variant priced apart from its parent. Other
+	// variants follow the parent's price.
+	PriceOverride bool `gorm:"not null;default:false" json:"price_override,omitempty"`
+	// Reserved is the total held by active reservations; Available is
+	// Stock less Reserved.
+	Reserved  measure.Quantity `gorm:"not null;default:0" json:"reserved"`
+	Available measure.Quantity `gorm:"-" json:"available"`
+	// ReorderPoint and ReorderQuantity apply at every warehouse without
+	// its own values. Stock at or below the reorder point is low; nil
+	// disables the check.
+	ReorderPoint    *measure.Quantity `json:"reorder_point"`
+	ReorderQuantity *measure.Quantity `json:"reorder_quantity"`
+	StockLevels     []StockLevel      `gorm:"foreignKey:ProductID" json:"stock_levels,omitempty"`
+	Barcodes        []ProductBarcode  `gorm:"foreignKey:ProductID" json:"barcodes,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt    `gorm:"index" json:"-"`
+}
+
+// AfterFind fills in the stock available to sell.
+func (p *Product) AfterFind(tx *gorm.DB) error {
+	p.Available = p.Stock.Sub(p.Reserved)
+	return nil
+}
+
+// ProductUnit is a unit a product is bought or sold in besides its base
+// unit, such as a carton of 12 pieces or a 5 kg bag of rice.
+type ProductUnit struct {
+	ID        uint `gorm:"primaryKey" json:"id"`
+	ProductID uint `gorm:"uniqueIndex:idx_product_units_product_unit;not null" json:"product_id"`
+	UnitID    uint `gorm:"uniqueIndex:idx_product_units_product_unit;not null" json:"unit_id"`
+	Unit      Unit `gorm:"foreignKey:UnitID" json:"unit"`
+	// Factor is the quantity of the base unit in one of this unit.
+	Factor      measure.Quantity `gorm:"not null" json:"factor"`
+	ForPurchase bool             `gorm:"not null;default:false" json:"for_purchase"`
+	ForSale     bool             `gorm:"not null;default:false" json:"for_sale"`
+	// Price is the sale price of one of this unit. Without it the unit
+	// sells at the product's price times Factor.
+	Price *money.Amount `json:"price"`
+}
+
+// ProductAttribute is an attribute a parent product's variants differ by,
+// such as size, and the values it takes.
+type ProductAttribute struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// DefaultSKU is the SKU given to a product created without one. The
+// 2024100101_product_skus migration backfills existing products the same
+// way.
+func DefaultSKU(id uint) string {
+	return fmt.Sprintf("P%06d", id)
+}
+
+// ProductBarcode is a barcode a product is scanned by, stored as a 13-digit
+// GTIN. A product can have several, such as the manufacturer's and an
+// in-store one.
+type ProductBarcode struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	ProductID uint      `gorm:"index;not null" json:"-"`
+	Code      string    `gorm:"size:13;uniqueIndex;not null" json:"code"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Types of StockMovement.
+const (
+	MovementSale        = "sale"
+	MovementReceipt     = "receipt"
+	MovementAdjustment  = "adjustment"
+	MovementTransferOut = "transfer_out"
+	MovementTransferIn  = "transfer_in"
+	MovementReturn      = "return"
+)
+
+// Document types a StockMovement can reference.
+const (
+	ReferenceSale       = "sale"
+	ReferenceTransfer   = "transfer"
+	ReferenceAdjustment = "adjustment"
+)
+
+// StockMovement is an append-only ledger entry for a change in a product's
+// stock at a warehouse. Quantity is signed: receipts and returns are
+// positive, sales and outgoing transfers negative. The sum of a product's
+// movements at a warehouse is its on-hand quantity there, which StockLevel
+// caches.
+type StockMovement struct {
+	ID          uint             `gorm:"primaryKey" json:"id"`
+	ProductID   uint             `gorm:"index;not null" json:"product_id"`
+	Product     Product          `gorm:"foreignKey:ProductID" json:"-"`
+	WarehouseID uint             `gorm:"index" json:"warehouse_id"`
+	Type        string           `gorm:"not null" json:"type"`
+	Quantity    measure.Quantity `gorm:"not null" json:"quantity"`
+	// Balance is the product's stock at the warehouse right after the
+	// movement.
+	Balance measure.Quantity `gorm:"not null" json:"balance"`
+	Reason  string           `json:"reason"`
+	// ReferenceType and ReferenceID name the document that caused the
+	// movement, such as "sale" and the sale ID.
+	ReferenceType string `gorm:"index:idx_stock_movements_reference" json:"reference_type"`
+	ReferenceID   *uint  `gorm:"index:idx_stock_movements_reference" json:"reference_id"`
+	// LotID is the lot the stock moved in or out of, if any.
+	LotID     *uint     `gorm:"index" json:"lot_id"`
+	UserID    *uint     `gorm:"index" json:"user_id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// Lot is a batch of a product received together, usually sharing an expiry
+// date. A lot can be spread over several warehouses; LotLevel holds its
+// stock at each.
+type Lot struct {
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	ProductID uint    `gorm:"uniqueIndex:idx_lots_product_number;not null" json:"product_id"`
+	Product   Product `gorm:"foreignKey:ProductID" json:"-"`
+	LotNumber string  `gorm:"uniqueIndex:idx_lots_product_number;not null" json:"lot_number"`
+	// ExpiryDate is the last day the lot may be sold; nil if it does not
+	// expire.
+	ExpiryDate *time.Time `gorm:"type:date;index" json:"expiry_date"`
+	Levels     []LotLevel `gorm:"foreignKey:LotID" json:"levels,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IsExpired reports whether the lot's expiry date is before the day of now.
+func (l *Lot) IsExpired(now time.Time) bool {
+	return l.ExpiryDate != nil && l.ExpiryDate.Format("2006-01-02") < now.Format("2006-01-02")
+}
+
+// LotLevel is the stock of a lot at a warehouse, a cache of the lot's
+// movements there. Stock of a lot-tracked product that is in no lot, for
+// example found in a cycle count, is its StockLevel less its lot levels.
+type LotLevel struct {
+	ID          uint             `gorm:"primaryKey" json:"-"`
+	LotID       uint             `gorm:"uniqueIndex:idx_lot_levels_lot_warehouse;not null" json:"lot_id"`
+	WarehouseID uint             `gorm:"uniqueIndex:idx_lot_levels_lot_warehouse;index;not null" json:"warehouse_id"`
+	Warehouse   Warehouse        `gorm:"foreignKey:WarehouseID" json:"warehouse"`
+	Quantity    measure.Quantity `gorm:"not null;default:0" json:"quantity"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// Statuses of a SerialNumber.
+const (
+	SerialInStock    = "in_stock"
+	SerialSold       = "sold"
+	SerialInTransit  = "in_transit"
+	SerialWrittenOff = "written_off"
+)
+
+// SerialNumber is one unit of a serialized product, identified by its
+// serial. Events records what happened to it, for warranty claims.
+type SerialNumber struct {
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	ProductID uint    `gorm:"uniqueIndex:idx_serial_numbers_product_serial;not null" json:"product_id"`
+	Product   Product `gorm:"foreignKey:ProductID" json:"-"`
+	Serial    string  `gorm:"uniqueIndex:idx_serial_numbers_product_serial;index;not null" json:"serial"`
+	Status    string  `gorm:"index;not null" json:"status"`
+	// WarehouseID is where the unit is in stock, nil while it is sold, in
+	// transit or written off.
+	WarehouseID *uint         `gorm:"index" json:"warehouse_id"`
+	Events      []SerialEvent `gorm:"foreignKey:SerialNumberID" json:"events,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// Types of SerialEvent.
+const (
+	SerialEventReceived   = "received"
+	SerialEventSold       = "sold"
+	SerialEventReturned   = "returned"
+	SerialEventShipped    = "shipped"
+	SerialEventArrived    = "arrived"
+	SerialEventWrittenOff = "written_off"
+	SerialEventFound      = "found"
+)
+
+// SerialEvent is an entry in a serial number's history.
+type SerialEvent struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	SerialNumberID uint      `gorm:"index;not null" json:"serial_number_id"`
+	Type           string    `gorm:"not null" json:"type"`
+	WarehouseID    uint      `gorm:"not null" json:"warehouse_id"`
+	Warehouse      Warehouse `gorm:"foreignKey:WarehouseID" json:"warehouse"`
+	// ReferenceType and ReferenceID name the document involved, such as
+	// "sale" and the sale ID, as on StockMovement.
+	ReferenceType string    `json:"reference_type"`
+	ReferenceID   *uint     `json:"reference_id"`
+	UserID        *uint     `json:"user_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Statuses of a Transfer.
+const (
+	TransferDraft             = "draft"
+	TransferShipped           = "shipped"
+	TransferPartiallyReceived = "partially_received"
+	TransferReceived          = "received"
+	TransferCancelled         = "cancelled"
+)
+
+// Transfer moves stock from one warehouse to another. Shipping it takes the
+// stock out of the source; from then until it is received, or written off
+// as a discrepancy, the stock is in transit and on hand at neither end.
+type Transfer struct {
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	FromWarehouseID uint           `gorm:"index;not null" json:"from_warehouse_id"`
+	FromWarehouse   Warehouse      `gorm:"foreignKey:FromWarehouseID" json:"from_warehouse"`
+	ToWarehouseID   uint           `gorm:"index;not null" json:"to_warehouse_id"`
+	ToWarehouse     Warehouse      `gorm:"foreignKey:ToWarehouseID" json:"to_warehouse"`
+	Status          string         `gorm:"index;not null" json:"status"`
+	Notes           string         `json:"notes"`
+	Lines           []TransferLine `gorm:"foreignKey:TransferID" json:"lines"`
+	CreatedByID     uint           `json:"created_by_id"`
+	ShippedByID     *uint          `json:"shipped_by_id"`
+	ShippedAt       *time.Time     `json:"shipped_at"`
+	ReceivedAt      *time.Time     `json:"received_at"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
+// TransferLine is one product on a Transfer. Of the Quantity shipped,
+// ReceivedQuantity has arrived and DiscrepancyQuantity was recorded as lost
+// or damaged on the way; the rest is in transit.
+type TransferLine struct {
+	ID                  uint             `gorm:"primaryKey" json:"id"`
+	TransferID          uint             `gorm:"index;not null" json:"transfer_id"`
+	ProductID           uint             `gorm:"index;not null" json:"product_id"`
+	Product             Product          `gorm:"foreignKey:ProductID" json:"product"`
+	Quantity            measure.Quantity `gorm:"not null" json:"quantity"`
+	ReceivedQuantity    measure.Quantity `gorm:"not null;default:0" json:"received_quantity"`
+	DiscrepancyQuantity measure.Quantity `gorm:"not null;default:0" json:"discrepancy_quantity"`
+	DiscrepancyReason   string           `json:"discrepancy_reason"`
+	// Serials lists the serial numbers shipped, for serialized products.
+	Serials []TransferLineSerial `gorm:"foreignKey:TransferLineID" json:"serials,omitempty"`
+	// InTransit is the outstanding quantity once the transfer has shipped.
+	InTransit measure.Quantity `gorm:"-" json:"in_transit"`
+}
+
+// TransferLineSerial is a serial number shipped on a transfer line.
+type TransferLineSerial struct {
+	ID             uint   `gorm:"primaryKey" json:"id"`
+	TransferLineID uint   `gorm:"index;not null" json:"transfer_line_id"`
+	Serial         string `gorm:"not null" json:"serial"`
+}
+
+// HasSerial reports whether the serial number was shipped on the line.
+func (l *TransferLine) HasSerial(serial string) bool {
+	for _, shipped := range l.Serials {
+		if shipped.Serial == serial {
+			return true
+		}
+	}
+	return false
+}
+
+// Outstanding returns the quantity neither received nor written off.
+func (l *TransferLine) Outstanding() measure.Quantity {
+	return l.Quantity.Sub(l.ReceivedQuantity).Sub(l.DiscrepancyQuantity)
+}
+
+// IsInTransit reports whether the transfer has shipped and is not yet fully
+// received.
+func (t *Transfer) IsInTransit() bool {
+	return t.Status == TransferShipped || t.Status == TransferPartiallyReceived
+}
+
+// AfterFind fills in the in-transit quantity of each loaded line.
+func (t *Transfer) AfterFind(tx *gorm.DB) error {
+	for i := range t.Lines {
+		if t.IsInTransit() {
+			t.Lines[i].InTransit = t.Lines[i].Outstanding()
+		}
+	}
+	return nil
+}
+
+// Statuses of a StockAdjustment.
+const (
+	AdjustmentDraft     = "draft"
+	AdjustmentPosted    = "posted"
+	AdjustmentCancelled = "cancelled"
+)
+
+// Reason codes of a StockAdjustment.
+const (
+	AdjustmentReasonShrinkage       = "shrinkage"
+	AdjustmentReasonDamage          = "damage"
+	AdjustmentReasonCountCorrection = "count_correction"
+	AdjustmentReasonFound           = "found"
+	AdjustmentReasonOther           = "other"
+)
+
+// AdjustmentReasons lists the valid adjustment reason codes.
+var AdjustmentReasons = []string{
+	AdjustmentReasonShrinkage,
+	AdjustmentReasonDamage,
+	AdjustmentReasonCountCorrection,
+	AdjustmentReasonFound,
+	AdjustmentReasonOther,
+}
+
+// StockAdjustment corrects stock at a warehouse outside of sales and
+// transfers. It is drafted first and only changes stock once posted, which
+// takes a separate permission.
+type StockAdjustment struct {
+	ID          uint                  `gorm:"primaryKey" json:"id"`
+	WarehouseID uint                  `gorm:"index;not null" json:"warehouse_id"`
+	Warehouse   Warehouse             `gorm:"foreignKey:WarehouseID" json:"warehouse"`
+	ReasonCode  string                `gorm:"index;not null" json:"reason_code"`
+	Notes       string                `json:"notes"`
+	Status      string                `gorm:"index;not null" json:"status"`
+	Lines       []StockAdjustmentLine `gorm:"foreignKey:AdjustmentID" json:"lines"`
+	// CycleCountID is set on adjustments created by approving a cycle count.
+	CycleCountID *uint      `gorm:"index" json:"cycle_count_id"`
+	CreatedByID  uint       `json:"created_by_id"`
+	PostedByID   *uint      `json:"posted_by_id"`
+	PostedAt     *time.Time `json:"posted_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// StockAdjustmentLine is the signed change to one product's stock.
+type StockAdjustmentLine struct {
+	ID           uint             `gorm:"primaryKey" json:"id"`
+	AdjustmentID uint             `gorm:"index;not null" json:"adjustment_id"`
+	ProductID    uint             `gorm:"index;not null" json:"product_id"`
+	Product      Product          `gorm:"foreignKey:ProductID" json:"product"`
+	Quantity     measure.Quantity `gorm:"not null" json:"quantity"`
+	// Serials lists the serial numbers written off or found, for serialized
+	// products.
+	Serials []StockAdjustmentSerial `gorm:"foreignKey:AdjustmentLineID" json:"serials,omitempty"`
+}
+
+// StockAdjustmentSerial is a serial number written off or found on an
+// adjustment line.
+type StockAdjustmentSerial struct {
+	ID               uint   `gorm:"primaryKey" json:"id"`
+	AdjustmentLineID uint   `gorm:"index;not null" json:"adjustment_line_id"`
+	Serial           string `gorm:"not null" json:"serial"`
+}
+
+// Statuses of a CycleCount.
+const (
+	CycleCountOpen      = "open"
+	CycleCountApproved  = "approved"
+	CycleCountCancelled = "cancelled"
+)
+
+// CycleCount is a stock count of some or all products at a warehouse. The
+// expected quantities are frozen when a product is added to the count, so
+// the variance is against the stock at that moment. Approving the count
+// posts the variances as a count_correction adjustment.
+type CycleCount struct {
+	ID           uint             `gorm:"primaryKey" json:"id"`
+	WarehouseID  uint             `gorm:"index;not null" json:"warehouse_id"`
+	Warehouse    Warehouse        `gorm:"foreignKey:WarehouseID" json:"warehouse"`
+	Status       string           `gorm:"index;not null" json:"status"`
+	Notes        string           `json:"notes"`
+	Lines        []CycleCountLine `gorm:"foreignKey:CycleCountID" json:"lines"`
+	AdjustmentID *uint            `json:"adjustment_id"`
+	CreatedByID  uint             `json:"created_by_id"`
+	ApprovedByID *uint            `json:"approved_by_id"`
+	ApprovedAt   *time.Time       `json:"approved_at"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+}
+
+// CycleCountLine is one product in a CycleCount. CountedQuantity is nil
+// until the product has been counted.
+type CycleCountLine struct {
+	ID               uint              `gorm:"primaryKey" json:"id"`
+	CycleCountID     uint              `gorm:"uniqueIndex:idx_cycle_count_lines_product;not null" json:"cycle_count_id"`
+	ProductID        uint              `gorm:"uniqueIndex:idx_cycle_count_lines_product;not null" json:"product_id"`
+	Product          Product           `gorm:"foreignKey:ProductID" json:"product"`
+	ExpectedQuantity measure.Quantity  `gorm:"not null" json:"expected_quantity"`
+	CountedQuantity  *measure.Quantity `json:"counted_quantity"`
+	CountedByID      *uint             `json:"counted_by_id"`
+	CountedAt        *time.Time        `json:"counted_at"`
+	// Serials lists the serial numbers counted, for serialized products.
+	Serials []CycleCountSerial `gorm:"foreignKey:CycleCountLineID" json:"serials,omitempty"`
+	// Variance is CountedQuantity minus ExpectedQuantity, or nil if the
+	// product has not been counted.
+	Variance *measure.Quantity `gorm:"-" json:"variance"`
+}
+
+// CycleCountSerial is a serial number counted on a cycle count line.
+type CycleCountSerial struct {
+	ID               uint   `gorm:"primaryKey" json:"id"`
+	CycleCountLineID uint   `gorm:"index;not null" json:"cycle_count_line_id"`
+	Serial           string `gorm:"not null" json:"serial"`
+}
+
+// AfterFind fills in the variance of a counted line.
+func (l *CycleCountLine) AfterFind(tx *gorm.DB) error {
+	if l.CountedQuantity != nil {
+		variance := l.CountedQuantity.Sub(l.ExpectedQuantity)
+		l.Variance = &variance
+	}
+	return nil
+}
+
+// Statuses of a StockReservation.
+const (
+	ReservationActive    = "active"
+	ReservationFulfilled = "fulfilled"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// StockReservation holds stock at a warehouse for an order that is not paid
+// yet, such as a web order or a parked cart. While it is active the stock
+// stays on hand but cannot be sold to anyone else. It ends when a sale
+// fulfils it, when it is released, or when it expires.
+type StockReservation struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	WarehouseID uint      `gorm:"index;not null" json:"warehouse_id"`
+	Warehouse   Warehouse `gorm:"foreignKey:WarehouseID" json:"warehouse"`
+	Status      string    `gorm:"index;not null" json:"status"`
+	// Reference identifies the order outside the ERP, e.g. a web order
+	// number.
+	Reference   string                 `gorm:"index" json:"reference"`
+	Notes       string                 `json:"notes"`
+	ExpiresAt   time.Time              `gorm:"index;not null" json:"expires_at"`
+	Lines       []StockReservationLine `gorm:"foreignKey:ReservationID" json:"lines"`
+	SaleID      *uint                  `gorm:"index" json:"sale_id"`
+	CreatedByID uint                   `json:"created_by_id"`
+	ReleasedAt  *time.Time             `json:"released_at"`
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+}
+
+// StockReservationLine is the quantity of one product held by a
+// StockReservation.
+type StockReservationLine struct {
+	ID            uint             `gorm:"primaryKey" json:"id"`
+	ReservationID uint             `gorm:"index;not null" json:"reservation_id"`
+	ProductID     uint             `gorm:"index;not null" json:"product_id"`
+	Product       Product          `gorm:"foreignKey:ProductID" json:"product"`
+	Quantity      measure.Quantity `gorm:"not null" json:"quantity"`
+}
+
+type Sale struct {
 	ID          uint           `gorm:"primaryKey" json:"id"`
-	Name        string         `gorm:"not null" json:"name"`
-	Description string         `json:"description"`
-	CategoryID  uint           `json:"category_id"`
-	Category    Category       `gorm:"foreignKey:CategoryID" json:"category"`
-	UnitID      uint           `json:"unit_id"`
-	Unit        Unit           `gorm:"foreignKey:UnitID" json:"unit"`
-	Price       float64        `gorm:"not null" json:"price"`
-	Stock       int            `gorm:"not null;default:0" json:"stock"`
-	CreatedAt   time.Time      `json:"created_at"`
+	UserID      uint           `gorm:"index" json:"user_id"`
+	User        User           `gorm:"foreignKey:UserID" json:"user"`
+	TerminalID  *uint          `gorm:"index" json:"terminal_id"`
+	WarehouseID uint           `gorm:"index" json:"warehouse_id"`
+	Total       money.Amount   `gorm:"not null" json:"total"`
+	SaleItems   []SaleItem     `gorm:"foreignKey:SaleID" json:"sale_items"`
+	CreatedAt   time.Time      `gorm:"index" json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
-type Sale struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	UserID    uint           `json:"user_id"`
-	User      User           `gorm:"foreignKey:UserID" json:"user"`
-	Total     float64        `gorm:"not null" json:"total"`
-	SaleItems []SaleItem     `gorm:"foreignKey:SaleID" json:"sale_items"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+type SaleItem struct {
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	SaleID    uint    `json:"sale_id"`
+	ProductID uint    `json:"product_id"`
+	Product   Product `gorm:"foreignKey:ProductID" json:"product"`
+	// Quantity is in the product's base unit. The item was sold as
+	// UnitQuantity of Unit, which Price is for.
+	Quantity     measure.Quantity `gorm:"not null" json:"quantity"`
+	UnitID       *uint            `json:"unit_id"`
+	Unit         *Unit            `gorm:"foreignKey:UnitID" json:"unit,omitempty"`
+	UnitQuantity measure.Quantity `gorm:"not null;default:0" json:"unit_quantity"`
+	Price        money.Amount     `gorm:"not null" json:"price"`
+	Subtotal     money.Amount     `gorm:"not null" json:"subtotal"`
+	// Lots lists the lots the item was taken from, for lot-tracked
+	// products.
+	Lots []SaleItemLot `gorm:"foreignKey:SaleItemID" json:"lots,omitempty"`
+	// Serials lists the serial numbers sold, for serialized products.
+	Serials   []SaleItemSerial `gorm:"foreignKey:SaleItemID" json:"serials,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	DeletedAt gorm.DeletedAt   `gorm:"index" json:"-"`
 }
 
-type SaleItem struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	SaleID    uint           `json:"sale_id"`
-	ProductID uint           `json:"product_id"`
-	Product   Product        `gorm:"foreignKey:ProductID" json:"product"`
-	Quantity  int            `gorm:"not null" json:"quantity"`
-	Price     float64        `gorm:"not null" json:"price"`
-	Subtotal  float64        `gorm:"not null" json:"subtotal"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+// SaleItemLot is the quantity of a sale item taken from one lot.
+type SaleItemLot struct {
+	ID         uint             `gorm:"primaryKey" json:"id"`
+	SaleItemID uint             `gorm:"index;not null" json:"sale_item_id"`
+	LotID      uint             `gorm:"index;not null" json:"lot_id"`
+	Lot        Lot              `gorm:"foreignKey:LotID" json:"lot"`
+	Quantity   measure.Quantity `gorm:"not null" json:"quantity"`
+}
+
+// SaleItemSerial is a serial number sold on a sale item.
+type SaleItemSerial struct {
+	ID             uint         `gorm:"primaryKey" json:"id"`
+	SaleItemID     uint         `gorm:"index;not null" json:"sale_item_id"`
+	SerialNumberID uint         `gorm:"index;not null" json:"serial_number_id"`
+	SerialNumber   SerialNumber `gorm:"foreignKey:SerialNumberID" json:"serial_number"`
 }
 
 // HashPassword hashes the user password
@@ -109,3 +790,21 @@ func (u *User) HashPassword(password string) error {
 func (u *User) CheckPassword(password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 }
+
+// TokensValidAfter returns the time before which access tokens issued to the
+// user are no longer accepted, or the zero time if there is none
+func (u *User) TokensValidAfter() time.Time {
+	var after time.Time
+	if u.PasswordChangedAt != nil {
+		after = *u.PasswordChangedAt
+	}
+	if u.SessionsRevokedAt != nil && u.SessionsRevokedAt.After(after) {
+		after = *u.SessionsRevokedAt
+	}
+	return after
+}
+
+// IsDisabled reports whether an administrator has disabled the account
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -48,3 +49,42 @@ func TestCheckPassword(t *testing.T) {
 		t.Errorf("Expected bcrypt.ErrMismatchedHashAndPassword, got %v", err)
 	}
 }
+
+func TestTokensValidAfter(t *testing.T) {
+	user := &User{}
+	if !user.TokensValidAfter().IsZero() {
+		t.Error("TokensValidAfter should be zero when nothing was revoked")
+	}
+
+	changed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	revoked := changed.Add(time.Hour)
+
+	user.PasswordChangedAt = &changed
+	if !user.TokensValidAfter().Equal(changed) {
+		t.Errorf("Expected %v, got %v", changed, user.TokensValidAfter())
+	}
+
+	user.SessionsRevokedAt = &revoked
+	if !user.TokensValidAfter().Equal(revoked) {
+		t.Errorf("Expected the later of both timestamps, got %v", user.TokensValidAfter())
+	}
+}
+
+func TestAPIKeyIsActive(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+
+	if !(&APIKey{}).IsActive(now) {
+		t.Error("A key without expiry should be active")
+	}
+	if !(&APIKey{ExpiresAt: &future}).IsActive(now) {
+		t.Error("A key expiring in the future should be active")
+	}
+	if (&APIKey{ExpiresAt: &past}).IsActive(now) {
+		t.Error("An expired key should not be active")
+	}
+	if (&APIKey{RevokedAt: &past}).IsActive(now) {
+		t.Error("A revoked key should not be active")
+	}
+}
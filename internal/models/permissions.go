@@ -0,0 +1,69 @@
+package models
+
+// Names of the built-in roles created on startup. Built-in roles cannot be
+// deleted or renamed.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Permission names checked by middleware.RBACMiddleware. They follow the
+// "<resource>:<action>" convention and are seeded into the permissions table
+// on startup.
+const (
+	PermCategoriesRead  = "categories:read"
+	PermCategoriesWrite = "categories:write"
+	PermUnitsRead       = "units:read"
+	PermUnitsWrite      = "units:write"
+	PermProductsRead    = "products:read"
+	PermProductsWrite   = "products:write"
+	PermSalesRead       = "sales:read"
+	PermSalesCreate     = "sales:create"
+	PermRolesRead       = "roles:read"
+	PermRolesWrite      = "roles:write"
+	PermUsersRead       = "users:read"
+	PermUsersWrite      = "users:write"
+	PermAPIKeysRead     = "api_keys:read"
+	PermAPIKeysWrite    = "api_keys:write"
+	PermInventoryRead   = "inventory:read"
+	PermInventoryWrite  = "inventory:write"
+	PermInventoryAdjust = "inventory:adjust"
+	PermWarehousesRead  = "warehouses:read"
+	PermWarehousesWrite = "warehouses:write"
+)
+
+// PermissionCatalogue is the set of permissions known to the application.
+// They are seeded as system permissions and cannot be deleted through the API.
+var PermissionCatalogue = []Permission{
+	{Name: PermCategoriesRead, Description: "View categories"},
+	{Name: PermCategoriesWrite, Description: "Create, update and delete categories"},
+	{Name: PermUnitsRead, Description: "View units"},
+	{Name: PermUnitsWrite, Description: "Create, update and delete units"},
+	{Name: PermProductsRead, Description: "View products"},
+	{Name: PermProductsWrite, Description: "Create, update and delete products"},
+	{Name: PermSalesRead, Description: "View sales"},
+	{Name: PermSalesCreate, Description: "Record sales at the point of sale"},
+	{Name: PermRolesRead, Description: "View roles and permissions"},
+	{Name: PermRolesWrite, Description: "Manage roles and their permissions"},
+	{Name: PermUsersRead, Description: "View user accounts"},
+	{Name: PermUsersWrite, Description: "Invite, create, disable and change the role of users"},
+	{Name: PermAPIKeysRead, Description: "View API keys"},
+	{Name: PermAPIKeysWrite, Description: "Issue and revoke API keys"},
+	{Name: PermInventoryRead, Description: "View stock movements and inventory reports"},
+	{Name: PermInventoryWrite, Description: "Receive and transfer stock, draft adjustments, run cycle counts and reconcile inventory"},
+	{Name: PermInventoryAdjust, Description: "Post stock adjustments and approve cycle counts"},
+	{Name: PermWarehousesRead, Description: "View warehouses and terminals"},
+	{Name: PermWarehousesWrite, Description: "Create, update and delete warehouses and terminals"},
+}
+
+// DefaultUserPermissions are granted to the built-in "user" role when it has
+// no permissions yet.
+var DefaultUserPermissions = []string{
+	PermCategoriesRead,
+	PermUnitsRead,
+	PermProductsRead,
+	PermInventoryRead,
+	PermWarehousesRead,
+	PermSalesRead,
+	PermSalesCreate,
+}
@@ -0,0 +1,78 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DBStore keeps state in the login_throttles table so every API instance
+// sees the same counters. Updates lock the row with SELECT ... FOR UPDATE.
+type DBStore struct {
+	db *gorm.DB
+}
+
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+func (s *DBStore) Get(ctx context.Context, key string) (State, error) {
+	var row models.LoginThrottle
+	err := s.db.WithContext(ctx).Where("key = ?", key).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+	return stateFromRow(row), nil
+}
+
+func (s *DBStore) Update(ctx context.Context, key string, fn func(State) State) (State, error) {
+	var state State
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Make sure the row exists so there is something to lock.
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.LoginThrottle{Key: key}).Error
+		if err != nil {
+			return err
+		}
+
+		var row models.LoginThrottle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&row).Error; err != nil {
+			return err
+		}
+
+		state = fn(stateFromRow(row))
+		row.Failures = state.Failures
+		row.LastFailureAt = nullableTime(state.LastFailureAt)
+		row.LockedUntil = nullableTime(state.LockedUntil)
+		return tx.Save(&row).Error
+	})
+	return state, err
+}
+
+func (s *DBStore) Delete(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where("key = ?", key).Delete(&models.LoginThrottle{}).Error
+}
+
+func stateFromRow(row models.LoginThrottle) State {
+	state := State{Failures: row.Failures}
+	if row.LastFailureAt != nil {
+		state.LastFailureAt = *row.LastFailureAt
+	}
+	if row.LockedUntil != nil {
+		state.LockedUntil = *row.LockedUntil
+	}
+	return state
+}
+
+func nullableTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
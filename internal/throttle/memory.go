@@ -0,0 +1,38 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore keeps state in process memory. It is only suitable for a
+// single API instance and for tests.
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: map[string]State{}}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[key], nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, key string, fn func(State) State) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := fn(s.states[key])
+	s.states[key] = state
+	return state, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+	return nil
+}
@@ -0,0 +1,119 @@
+// Package throttle tracks failed attempts per key (such as a username or a
+// client IP) and tells callers how long to wait before the next attempt.
+package throttle
+
+import (
+	"context"
+	"time"
+)
+
+// State is the failure history of a key.
+type State struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// Store persists State per key. Update must apply fn atomically, even when
+// several processes share the store.
+type Store interface {
+	Get(ctx context.Context, key string) (State, error)
+	Update(ctx context.Context, key string, fn func(State) State) (State, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Policy decides how failures turn into waiting time. After each failure the
+// next attempt is delayed by BaseDelay doubled per previous failure, capped
+// at MaxDelay. Reaching MaxFailures locks the key for LockoutDuration.
+// Failures older than Window are forgotten.
+type Policy struct {
+	MaxFailures     int
+	LockoutDuration time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	Window          time.Duration
+}
+
+// RetryAfter returns how long the key must wait before another attempt, or
+// zero if it may try now.
+func (p Policy) RetryAfter(state State, now time.Time) time.Duration {
+	if now.Before(state.LockedUntil) {
+		return state.LockedUntil.Sub(now)
+	}
+	if state.Failures == 0 || p.expired(state, now) {
+		return 0
+	}
+
+	next := state.LastFailureAt.Add(p.delay(state.Failures))
+	if now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// RecordFailure returns the state after one more failure at now.
+func (p Policy) RecordFailure(state State, now time.Time) State {
+	if p.expired(state, now) && !now.Before(state.LockedUntil) {
+		state = State{}
+	}
+
+	state.Failures++
+	state.LastFailureAt = now
+	if p.MaxFailures > 0 && state.Failures >= p.MaxFailures {
+		state.LockedUntil = now.Add(p.LockoutDuration)
+		// Start counting afresh once the lockout ends.
+		state.Failures = 0
+	}
+	return state
+}
+
+func (p Policy) expired(state State, now time.Time) bool {
+	return p.Window > 0 && now.Sub(state.LastFailureAt) > p.Window
+}
+
+func (p Policy) delay(failures int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+// Limiter applies a Policy to the keys kept in a Store.
+type Limiter struct {
+	store  Store
+	policy Policy
+	now    func() time.Time
+}
+
+func NewLimiter(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy, now: time.Now}
+}
+
+// RetryAfter returns how long key must wait before another attempt.
+func (l *Limiter) RetryAfter(ctx context.Context, key string) (time.Duration, error) {
+	state, err := l.store.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return l.policy.RetryAfter(state, l.now()), nil
+}
+
+// Fail records a failed attempt for key and returns the resulting state.
+func (l *Limiter) Fail(ctx context.Context, key string) (State, error) {
+	now := l.now()
+	return l.store.Update(ctx, key, func(state State) State {
+		return l.policy.RecordFailure(state, now)
+	})
+}
+
+// Reset forgets every failure of key, lifting any lockout.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Delete(ctx, key)
+}
@@ -0,0 +1,125 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	MaxFailures:     4,
+	LockoutDuration: 15 * time.Minute,
+	BaseDelay:       time.Second,
+	MaxDelay:        3 * time.Second,
+	Window:          time.Hour,
+}
+
+func newTestLimiter(now *time.Time) *Limiter {
+	limiter := NewLimiter(NewMemoryStore(), testPolicy)
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+func TestBackoffGrowsExponentially(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+
+	wants := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	for i, want := range wants {
+		if _, err := limiter.Fail(ctx, "user:alice"); err != nil {
+			t.Fatalf("Fail returned error: %v", err)
+		}
+		got, err := limiter.RetryAfter(ctx, "user:alice")
+		if err != nil {
+			t.Fatalf("RetryAfter returned error: %v", err)
+		}
+		if got != want {
+			t.Errorf("After %d failures RetryAfter = %v, want %v", i+1, got, want)
+		}
+	}
+
+	now = now.Add(3 * time.Second)
+	if got, _ := limiter.RetryAfter(ctx, "user:alice"); got != 0 {
+		t.Errorf("RetryAfter should be zero once the delay passed, got %v", got)
+	}
+}
+
+func TestLockoutAfterMaxFailures(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+
+	for i := 0; i < testPolicy.MaxFailures; i++ {
+		now = now.Add(10 * time.Second)
+		if _, err := limiter.Fail(ctx, "ip:10.0.0.1"); err != nil {
+			t.Fatalf("Fail returned error: %v", err)
+		}
+	}
+
+	got, _ := limiter.RetryAfter(ctx, "ip:10.0.0.1")
+	if got != testPolicy.LockoutDuration {
+		t.Errorf("Expected lockout of %v, got %v", testPolicy.LockoutDuration, got)
+	}
+
+	now = now.Add(testPolicy.LockoutDuration)
+	if got, _ := limiter.RetryAfter(ctx, "ip:10.0.0.1"); got != 0 {
+		t.Errorf("Lockout should end after %v, still %v left", testPolicy.LockoutDuration, got)
+	}
+
+	// The counter starts again after a lockout.
+	state, _ := limiter.Fail(ctx, "ip:10.0.0.1")
+	if state.Failures != 1 {
+		t.Errorf("Expected failure count to restart at 1, got %d", state.Failures)
+	}
+}
+
+func TestResetLiftsLockout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+
+	for i := 0; i < testPolicy.MaxFailures; i++ {
+		limiter.Fail(ctx, "user:bob")
+	}
+	if got, _ := limiter.RetryAfter(ctx, "user:bob"); got == 0 {
+		t.Fatal("Key should be locked")
+	}
+
+	if err := limiter.Reset(ctx, "user:bob"); err != nil {
+		t.Fatalf("Reset returned error: %v", err)
+	}
+	if got, _ := limiter.RetryAfter(ctx, "user:bob"); got != 0 {
+		t.Errorf("Reset should lift the lockout, got %v", got)
+	}
+}
+
+func TestFailuresExpireAfterWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+
+	for i := 0; i < testPolicy.MaxFailures-1; i++ {
+		limiter.Fail(ctx, "user:carol")
+	}
+
+	now = now.Add(testPolicy.Window + time.Minute)
+	state, _ := limiter.Fail(ctx, "user:carol")
+	if state.Failures != 1 {
+		t.Errorf("Old failures should be forgotten, got %d", state.Failures)
+	}
+	if !state.LockedUntil.IsZero() {
+		t.Error("Key should not be locked after old failures expired")
+	}
+}
+
+func TestKeysAreIndependent(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+
+	limiter.Fail(ctx, "user:alice")
+	if got, _ := limiter.RetryAfter(ctx, "user:dave"); got != 0 {
+		t.Errorf("Failures of one key should not affect another, got %v", got)
+	}
+}
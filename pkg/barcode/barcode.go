@@ -0,0 +1,68 @@
+// Package barcode validates and generates the retail barcodes products are
+// scanned by: EAN-13 and UPC-A. Both are GTINs; a UPC-A is an EAN-13 with a
+// leading zero left off, so codes are normalized to 13 digits.
+package barcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// InternalPrefix starts the barcodes Internal generates. GS1 reserves
+// prefixes 20 to 29 for numbers used only inside a company, so they cannot
+// clash with manufacturers' barcodes.
+const InternalPrefix = "20"
+
+var (
+	// ErrInvalidFormat is returned for a code that is not 12 or 13 digits.
+	ErrInvalidFormat = errors.New("barcode must be 12 (UPC-A) or 13 (EAN-13) digits")
+	// ErrCheckDigit is returned for a code whose last digit does not match
+	// the others.
+	ErrCheckDigit = errors.New("barcode check digit is wrong")
+)
+
+// CheckDigit returns the GS1 check digit for digits, the code without its
+// check digit.
+func CheckDigit(digits string) int {
+	sum := 0
+	// Weights alternate 3, 1, starting with 3 at the rightmost digit.
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
+
+// Normalize validates an EAN-13 or UPC-A code and returns it as 13 digits.
+func Normalize(code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != 12 && len(code) != 13 {
+		return "", ErrInvalidFormat
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "", ErrInvalidFormat
+		}
+	}
+	if len(code) == 12 {
+		code = "0" + code
+	}
+	if CheckDigit(code[:12]) != int(code[12]-'0') {
+		return "", ErrCheckDigit
+	}
+	return code, nil
+}
+
+// Internal returns an EAN-13 for in-store use built from a number, such as
+// a product ID, unique among the numbers it is given.
+func Internal(n uint) (string, error) {
+	digits := fmt.Sprintf("%s%010d", InternalPrefix, n)
+	if len(digits) != 12 {
+		return "", fmt.Errorf("number %d is too large for an internal barcode", n)
+	}
+	return fmt.Sprintf("%s%d", digits, CheckDigit(digits)), nil
+}
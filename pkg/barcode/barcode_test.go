@@ -0,0 +1,48 @@
+package barcode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+		err  error
+	}{
+		{"4006381333931", "4006381333931", nil},
+		{"036000291452", "0036000291452", nil},
+		{" 5901234123457 ", "5901234123457", nil},
+		{"4006381333932", "", ErrCheckDigit},
+		{"036000291453", "", ErrCheckDigit},
+		{"40063813339", "", ErrInvalidFormat},
+		{"400638133393A", "", ErrInvalidFormat},
+		{"", "", ErrInvalidFormat},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.code)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q, %v", tt.code, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestInternal(t *testing.T) {
+	code, err := Internal(42)
+	if err != nil {
+		t.Fatalf("Internal() error = %v", err)
+	}
+	if code[:2] != InternalPrefix || len(code) != 13 {
+		t.Errorf("Expected a 13-digit code with the internal prefix, got %q", code)
+	}
+	if normalized, err := Normalize(code); err != nil || normalized != code {
+		t.Errorf("Expected a valid EAN-13, got %q: %v", normalized, err)
+	}
+	if other, _ := Internal(43); other == code {
+		t.Error("Expected different numbers to give different codes")
+	}
+	if _, err := Internal(1 << 40); err == nil {
+		t.Error("Expected a number over ten digits to be refused")
+	}
+}
@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir, From: "erp@example.com"}
+
+	msg := Message{
+		To:      []string{"jane@example.com"},
+		Subject: "Reset your password",
+		Body:    "Open this link:\nhttps://erp.example.com/reset?token=abc",
+	}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Failed to send mail: %v", err)
+	}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Failed to send mail: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatalf("Failed to list mail files: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 mail files, got %d", len(files))
+	}
+
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("Failed to read mail file: %v", err)
+	}
+	for _, want := range []string{
+		"From: erp@example.com\r\n",
+		"To: jane@example.com\r\n",
+		"Subject: Reset your password\r\n",
+		"https://erp.example.com/reset?token=abc",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Mail file should contain %q", want)
+		}
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := &LogMailer{Logger: log.New(&buf, "", 0)}
+
+	err := m.Send(context.Background(), Message{
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: "Hello",
+		Body:    "Body text",
+	})
+	if err != nil {
+		t.Fatalf("Failed to send mail: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "a@example.com,b@example.com") || !strings.Contains(out, "Body text") {
+		t.Errorf("Unexpected log output %q", out)
+	}
+}
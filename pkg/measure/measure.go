@@ -0,0 +1,159 @@
+// Package measure represents stock quantities as exact decimals, so goods
+// sold by weight or length can be counted in fractions of their unit.
+// Quantities are stored in NUMERIC columns with Places decimal places and
+// serialized to JSON as numbers written out exactly.
+package measure
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Places is the number of decimal places a quantity may have: grams of a
+// product counted in kilograms, millilitres of one counted in litres.
+const Places = 3
+
+// Quantity is an exact decimal quantity. The zero value is 0.
+type Quantity struct {
+	d decimal.Decimal
+}
+
+// Zero is a quantity of 0.
+var Zero = Quantity{}
+
+// Parse reads a quantity in plain decimal notation such as "2.5".
+func Parse(s string) (Quantity, error) {
+	d, err := decimal.NewFromString(strings.TrimSpace(s))
+	if err != nil {
+		return Quantity{}, fmt.Errorf("invalid quantity %q", s)
+	}
+	return Quantity{d: d}, nil
+}
+
+// MustParse is like Parse but panics on invalid input. It is meant for
+// constants and tests.
+func MustParse(s string) Quantity {
+	q, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// FromInt returns a quantity of n whole units.
+func FromInt(n int64) Quantity {
+	return Quantity{d: decimal.NewFromInt(n)}
+}
+
+// FromDecimal wraps a decimal.
+func FromDecimal(d decimal.Decimal) Quantity {
+	return Quantity{d: d}
+}
+
+// Decimal returns the quantity as a decimal.
+func (q Quantity) Decimal() decimal.Decimal {
+	return q.d
+}
+
+func (q Quantity) Add(r Quantity) Quantity {
+	return Quantity{d: q.d.Add(r.d)}
+}
+
+func (q Quantity) Sub(r Quantity) Quantity {
+	return Quantity{d: q.d.Sub(r.d)}
+}
+
+func (q Quantity) Neg() Quantity {
+	return Quantity{d: q.d.Neg()}
+}
+
+// Mul multiplies the quantity by a factor, such as the number of base
+// units in another unit. The result is not rounded.
+func (q Quantity) Mul(factor Quantity) Quantity {
+	return Quantity{d: q.d.Mul(factor.d)}
+}
+
+// Cmp returns -1, 0 or 1 if q is less than, equal to or greater than r.
+func (q Quantity) Cmp(r Quantity) int {
+	return q.d.Cmp(r.d)
+}
+
+func (q Quantity) Equal(r Quantity) bool {
+	return q.d.Equal(r.d)
+}
+
+func (q Quantity) IsZero() bool {
+	return q.d.IsZero()
+}
+
+func (q Quantity) IsNegative() bool {
+	return q.d.IsNegative()
+}
+
+func (q Quantity) IsPositive() bool {
+	return q.d.IsPositive()
+}
+
+// IsWhole reports whether the quantity has no fractional part.
+func (q Quantity) IsWhole() bool {
+	return q.d.Equal(q.d.Truncate(0))
+}
+
+// IsExact reports whether the quantity fits in Places decimal places, so
+// it can be stored without rounding.
+func (q Quantity) IsExact() bool {
+	return q.d.Equal(q.d.Truncate(Places))
+}
+
+// Count returns the number of whole units in a whole quantity.
+func (q Quantity) Count() int {
+	return int(q.d.IntPart())
+}
+
+// String returns the quantity without trailing zeros, such as "2.5" or "3".
+func (q Quantity) String() string {
+	return q.d.String()
+}
+
+// MarshalJSON encodes the quantity as a JSON number written out exactly.
+func (q Quantity) MarshalJSON() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or string. Numbers are read from their
+// text, not through float64, so they keep every digit. Quantities with more
+// than Places decimal places are refused, since they could not be stored.
+func (q *Quantity) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	parsed, err := Parse(string(bytes.Trim(data, `"`)))
+	if err != nil {
+		return err
+	}
+	if !parsed.IsExact() {
+		return fmt.Errorf("quantity %s has more than %d decimal places", parsed, Places)
+	}
+	*q = parsed
+	return nil
+}
+
+// Scan implements sql.Scanner.
+func (q *Quantity) Scan(value interface{}) error {
+	return q.d.Scan(value)
+}
+
+// Value implements driver.Valuer, storing the quantity as exact text.
+func (q Quantity) Value() (driver.Value, error) {
+	return q.d.String(), nil
+}
+
+// GormDataType is the column type used for quantities.
+func (Quantity) GormDataType() string {
+	return "numeric(18,3)"
+}
@@ -0,0 +1,70 @@
+package measure
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"10", "10"},
+		{"10.000", "10"},
+		{"2.500", "2.5"},
+		{"-0.125", "-0.125"},
+	}
+	for _, tt := range tests {
+		encoded, err := json.Marshal(MustParse(tt.in))
+		if err != nil {
+			t.Fatalf("Marshal(%s): %v", tt.in, err)
+		}
+		if string(encoded) != tt.want {
+			t.Errorf("Marshal(%s) = %s, want %s", tt.in, encoded, tt.want)
+		}
+	}
+
+	var payload struct {
+		Num Quantity  `json:"num"`
+		Str Quantity  `json:"str"`
+		Nil *Quantity `json:"nil"`
+	}
+	if err := json.Unmarshal([]byte(`{"num": 1.1, "str": "0.75", "nil": null}`), &payload); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !payload.Num.Equal(MustParse("1.1")) || !payload.Str.Equal(MustParse("0.75")) || payload.Nil != nil {
+		t.Errorf("Expected 1.1, 0.75 and nil, got %s, %s and %v", payload.Num, payload.Str, payload.Nil)
+	}
+	if err := json.Unmarshal([]byte(`{"num": "two"}`), &payload); err == nil {
+		t.Error("Invalid quantities should fail to unmarshal")
+	}
+	if err := json.Unmarshal([]byte(`{"num": 0.0001}`), &payload); err == nil {
+		t.Errorf("Quantities with more than %d decimal places should fail to unmarshal", Places)
+	}
+}
+
+func TestIsWholeAndIsExact(t *testing.T) {
+	if !MustParse("3.000").IsWhole() || MustParse("2.5").IsWhole() {
+		t.Error("Expected 3.000 to be whole and 2.5 not")
+	}
+	if !MustParse("0.125").IsExact() || MustParse("0.1255").IsExact() {
+		t.Errorf("Expected %d decimal places to be exact and more not", Places)
+	}
+	if got := MustParse("0.5").Mul(FromInt(5)); !got.Equal(MustParse("2.5")) || got.Count() != 2 {
+		t.Errorf("Expected 2.5, got %s", got)
+	}
+}
+
+func TestScanAndValue(t *testing.T) {
+	var q Quantity
+	if err := q.Scan("12.500"); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if !q.Equal(MustParse("12.5")) {
+		t.Errorf("Expected 12.5, got %s", q)
+	}
+	if value, err := MustParse("0.1").Value(); err != nil || value != "0.1" {
+		t.Errorf("Expected the exact text 0.1, got %v (%v)", value, err)
+	}
+}
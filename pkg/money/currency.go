@@ -0,0 +1,92 @@
+package money
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RoundingMode decides how an amount halfway between two minor units is
+// rounded.
+type RoundingMode int
+
+const (
+	// RoundHalfUp rounds halves away from zero (1.005 -> 1.01).
+	RoundHalfUp RoundingMode = iota
+	// RoundHalfEven rounds halves to the nearest even minor unit (1.005 ->
+	// 1.00), also known as banker's rounding.
+	RoundHalfEven
+)
+
+// ParseRoundingMode reads "half_up" or "half_even".
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	switch strings.ToLower(s) {
+	case "half_up":
+		return RoundHalfUp, nil
+	case "half_even":
+		return RoundHalfEven, nil
+	default:
+		return 0, fmt.Errorf("unsupported rounding mode %q", s)
+	}
+}
+
+// Currency describes how amounts in a currency are rounded.
+type Currency struct {
+	Code string
+	// Scale is the number of decimal places of the minor unit (ISO 4217).
+	Scale    int32
+	Rounding RoundingMode
+}
+
+// currencyScales lists the minor units of the supported currencies.
+var currencyScales = map[string]int32{
+	"AUD": 2,
+	"BHD": 3,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"IDR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MYR": 2,
+	"PHP": 2,
+	"SGD": 2,
+	"THB": 2,
+	"USD": 2,
+	"VND": 0,
+}
+
+// LookupCurrency returns the currency with the given ISO 4217 code.
+func LookupCurrency(code string, rounding RoundingMode) (Currency, error) {
+	code = strings.ToUpper(code)
+	scale, ok := currencyScales[code]
+	if !ok {
+		return Currency{}, fmt.Errorf("unsupported currency %q", code)
+	}
+	return Currency{Code: code, Scale: scale, Rounding: rounding}, nil
+}
+
+// Round rounds an amount to the currency's minor unit.
+func (c Currency) Round(a Amount) Amount {
+	if c.Rounding == RoundHalfEven {
+		return Amount{d: a.d.RoundBank(c.Scale)}
+	}
+	return Amount{d: a.d.Round(c.Scale)}
+}
+
+// IsRounded reports whether a has no digits beyond the currency's minor unit.
+func (c Currency) IsRounded(a Amount) bool {
+	return a.d.Equal(a.d.Truncate(c.Scale))
+}
+
+var defaultCurrency = Currency{Code: "IDR", Scale: 2}
+
+// SetDefaultCurrency sets the currency amounts are formatted for.
+func SetDefaultCurrency(c Currency) {
+	defaultCurrency = c
+}
+
+// DefaultCurrency returns the currency set with SetDefaultCurrency.
+func DefaultCurrency() Currency {
+	return defaultCurrency
+}
@@ -0,0 +1,138 @@
+// Package money represents monetary amounts as exact decimals. Amounts are
+// stored in NUMERIC columns and serialized to JSON as strings so no float
+// conversion happens anywhere between the database and the client.
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Amount is an exact decimal amount of money. The zero value is 0.
+type Amount struct {
+	d decimal.Decimal
+}
+
+// Zero is an amount of 0.
+var Zero = Amount{}
+
+// Parse reads an amount in plain decimal notation such as "1500.25".
+func Parse(s string) (Amount, error) {
+	d, err := decimal.NewFromString(strings.TrimSpace(s))
+	if err != nil {
+		return Amount{}, fmt.Errorf("invalid amount %q", s)
+	}
+	return Amount{d: d}, nil
+}
+
+// MustParse is like Parse but panics on invalid input. It is meant for
+// constants and tests.
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// FromInt returns an amount of n whole units.
+func FromInt(n int64) Amount {
+	return Amount{d: decimal.NewFromInt(n)}
+}
+
+// FromDecimal wraps a decimal.
+func FromDecimal(d decimal.Decimal) Amount {
+	return Amount{d: d}
+}
+
+// Decimal returns the amount as a decimal.
+func (a Amount) Decimal() decimal.Decimal {
+	return a.d
+}
+
+func (a Amount) Add(b Amount) Amount {
+	return Amount{d: a.d.Add(b.d)}
+}
+
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{d: a.d.Sub(b.d)}
+}
+
+// Mul multiplies the amount by a quantity. The result is not rounded.
+func (a Amount) Mul(quantity decimal.Decimal) Amount {
+	return Amount{d: a.d.Mul(quantity)}
+}
+
+// MulInt multiplies the amount by a whole quantity.
+func (a Amount) MulInt(quantity int64) Amount {
+	return Amount{d: a.d.Mul(decimal.NewFromInt(quantity))}
+}
+
+// Cmp returns -1, 0 or 1 if a is less than, equal to or greater than b.
+func (a Amount) Cmp(b Amount) int {
+	return a.d.Cmp(b.d)
+}
+
+func (a Amount) Equal(b Amount) bool {
+	return a.d.Equal(b.d)
+}
+
+func (a Amount) IsZero() bool {
+	return a.d.IsZero()
+}
+
+func (a Amount) IsNegative() bool {
+	return a.d.IsNegative()
+}
+
+// String returns the amount with at least the default currency's number of
+// decimal places, and more if needed to show it exactly.
+func (a Amount) String() string {
+	places := defaultCurrency.Scale
+	// decimal.String drops trailing zeros, leaving the digits that matter.
+	exact := a.d.String()
+	if i := strings.IndexByte(exact, '.'); i >= 0 && int32(len(exact)-i-1) > places {
+		places = int32(len(exact) - i - 1)
+	}
+	return a.d.StringFixed(places)
+}
+
+// MarshalJSON encodes the amount as a JSON string.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + a.String() + `"`), nil
+}
+
+// UnmarshalJSON accepts a JSON string or number. Numbers are read from their
+// text, not through float64, so they keep every digit.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	s := string(bytes.Trim(data, `"`))
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Scan implements sql.Scanner.
+func (a *Amount) Scan(value interface{}) error {
+	return a.d.Scan(value)
+}
+
+// Value implements driver.Valuer, storing the amount as exact text.
+func (a Amount) Value() (driver.Value, error) {
+	return a.d.String(), nil
+}
+
+// GormDataType is the column type used for amounts.
+func (Amount) GormDataType() string {
+	return "numeric(19,4)"
+}
@@ -0,0 +1,132 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestAddIsExact(t *testing.T) {
+	total := Zero
+	for i := 0; i < 10; i++ {
+		total = total.Add(MustParse("0.1"))
+	}
+	if !total.Equal(FromInt(1)) {
+		t.Errorf("Ten times 0.1 should be exactly 1, got %s", total)
+	}
+}
+
+func TestCurrencyRound(t *testing.T) {
+	tests := []struct {
+		code     string
+		rounding RoundingMode
+		in, want string
+	}{
+		{"USD", RoundHalfUp, "1.005", "1.01"},
+		{"USD", RoundHalfEven, "1.005", "1.00"},
+		{"USD", RoundHalfEven, "1.015", "1.02"},
+		{"USD", RoundHalfUp, "-1.005", "-1.01"},
+		{"JPY", RoundHalfUp, "149.5", "150"},
+		{"KWD", RoundHalfUp, "2.0005", "2.001"},
+	}
+	for _, tt := range tests {
+		currency, err := LookupCurrency(tt.code, tt.rounding)
+		if err != nil {
+			t.Fatalf("LookupCurrency(%s): %v", tt.code, err)
+		}
+		if got := currency.Round(MustParse(tt.in)); !got.Equal(MustParse(tt.want)) {
+			t.Errorf("%s Round(%s) = %s, want %s", tt.code, tt.in, got.Decimal(), tt.want)
+		}
+	}
+
+	if _, err := LookupCurrency("XXX", RoundHalfUp); err == nil {
+		t.Error("Unknown currencies should be rejected")
+	}
+}
+
+func TestIsRounded(t *testing.T) {
+	usd, _ := LookupCurrency("usd", RoundHalfUp)
+	if !usd.IsRounded(MustParse("12.50")) || !usd.IsRounded(MustParse("12.5000")) {
+		t.Error("12.50 is a whole number of cents")
+	}
+	if usd.IsRounded(MustParse("12.505")) {
+		t.Error("12.505 is not a whole number of cents")
+	}
+}
+
+func TestMulInt(t *testing.T) {
+	if got := MustParse("19.99").MulInt(3); !got.Equal(MustParse("59.97")) {
+		t.Errorf("Expected 59.97, got %s", got)
+	}
+	if got := MustParse("2.5").Mul(decimal.RequireFromString("0.333")); !got.Equal(MustParse("0.8325")) {
+		t.Errorf("Expected 0.8325, got %s", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	defer SetDefaultCurrency(DefaultCurrency())
+	usd, _ := LookupCurrency("USD", RoundHalfUp)
+	SetDefaultCurrency(usd)
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"1500", `"1500.00"`},
+		{"0.1", `"0.10"`},
+		{"12.3450", `"12.345"`},
+		{"-3.5", `"-3.50"`},
+	}
+	for _, tt := range tests {
+		encoded, err := json.Marshal(MustParse(tt.in))
+		if err != nil {
+			t.Fatalf("Marshal(%s): %v", tt.in, err)
+		}
+		if string(encoded) != tt.want {
+			t.Errorf("Marshal(%s) = %s, want %s", tt.in, encoded, tt.want)
+		}
+	}
+
+	var payload struct {
+		Str Amount `json:"str"`
+		Num Amount `json:"num"`
+	}
+	if err := json.Unmarshal([]byte(`{"str": "19.99", "num": 0.30000000000000004}`), &payload); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !payload.Str.Equal(MustParse("19.99")) {
+		t.Errorf("Expected 19.99, got %s", payload.Str)
+	}
+	if !payload.Num.Equal(MustParse("0.30000000000000004")) {
+		t.Errorf("Numbers should be read exactly, got %s", payload.Num)
+	}
+
+	if err := json.Unmarshal([]byte(`{"str": "abc"}`), &payload); err == nil {
+		t.Error("Invalid amounts should fail to unmarshal")
+	}
+}
+
+func TestScanAndValue(t *testing.T) {
+	var a Amount
+	if err := a.Scan("1500.2500"); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if !a.Equal(MustParse("1500.25")) {
+		t.Errorf("Expected 1500.25, got %s", a)
+	}
+
+	value, err := MustParse("0.1").Value()
+	if err != nil || value != "0.1" {
+		t.Errorf("Expected the exact text 0.1, got %v (%v)", value, err)
+	}
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	if mode, err := ParseRoundingMode("half_even"); err != nil || mode != RoundHalfEven {
+		t.Errorf("Expected half_even, got %v (%v)", mode, err)
+	}
+	if _, err := ParseRoundingMode("up"); err == nil {
+		t.Error("Unknown rounding modes should be rejected")
+	}
+}
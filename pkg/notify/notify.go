@@ -0,0 +1,81 @@
+// Package notify delivers operational alerts, such as low stock warnings, to
+// people or other systems.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/edwinjordan/erp_golang/pkg/mailer"
+)
+
+// Alert is a notification. Body is plain text for people; Data carries the
+// same content in structured form for machines.
+type Alert struct {
+	Kind    string      `json:"kind"`
+	Subject string      `json:"subject"`
+	Body    string      `json:"body"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Notifier delivers alerts.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// LogNotifier writes alerts to the standard logger. It is meant for
+// development.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, alert Alert) error {
+	log.Printf("ALERT %s: %s\n%s", alert.Kind, alert.Subject, alert.Body)
+	return nil
+}
+
+// MailNotifier emails alerts to a fixed list of recipients.
+type MailNotifier struct {
+	Mailer mailer.Mailer
+	To     []string
+}
+
+func (n *MailNotifier) Notify(ctx context.Context, alert Alert) error {
+	return n.Mailer.Send(ctx, mailer.Message{To: n.To, Subject: alert.Subject, Body: alert.Body})
+}
+
+// WebhookNotifier POSTs alerts as JSON to URL. Any 2xx response counts as
+// delivered.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
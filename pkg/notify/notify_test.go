@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edwinjordan/erp_golang/pkg/mailer"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var received Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	n := &WebhookNotifier{URL: server.URL}
+	alert := Alert{Kind: "low_stock", Subject: "2 products are low on stock", Body: "..."}
+	if err := n.Notify(context.Background(), alert); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	if received.Kind != alert.Kind || received.Subject != alert.Subject {
+		t.Errorf("Unexpected alert received %+v", received)
+	}
+}
+
+func TestWebhookNotifierRejectedStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	n := &WebhookNotifier{URL: server.URL}
+	if err := n.Notify(context.Background(), Alert{Subject: "x"}); err == nil {
+		t.Error("A non-2xx response should be an error")
+	}
+}
+
+type recordingMailer struct{ sent []mailer.Message }
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestMailNotifier(t *testing.T) {
+	m := &recordingMailer{}
+	n := &MailNotifier{Mailer: m, To: []string{"stock@example.com"}}
+	if err := n.Notify(context.Background(), Alert{Subject: "Low stock", Body: "Laptop: 1 left"}); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+	if len(m.sent) != 1 || m.sent[0].To[0] != "stock@example.com" || m.sent[0].Subject != "Low stock" {
+		t.Errorf("Unexpected mail %+v", m.sent)
+	}
+}
@@ -0,0 +1,156 @@
+package oidc_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/edwinjordan/erp_golang/pkg/oidc"
+	"github.com/edwinjordan/erp_golang/pkg/oidc/oidctest"
+)
+
+const redirectURL = "http://localhost:8080/auth/oidc/callback"
+
+func newProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+	server, err := oidctest.NewServer("erp", "s3cret")
+	if err != nil {
+		t.Fatalf("Failed to start mock provider: %v", err)
+	}
+	t.Cleanup(server.Close)
+	return server, oidc.NewProvider(server.Config(redirectURL))
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	server, provider := newProvider(t)
+	server.SetClaims(map[string]interface{}{
+		"sub":            "alice-123",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"erp-admins", "staff"},
+	})
+	ctx := context.Background()
+
+	verifier, err := oidc.GenerateCodeVerifier()
+	if err != nil {
+		t.Fatalf("Failed to generate verifier: %v", err)
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", oidc.CodeChallenge(verifier))
+	if err != nil {
+		t.Fatalf("Failed to build auth URL: %v", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	if parsed.Query().Get("code_challenge_method") != "S256" {
+		t.Error("Auth URL should request the S256 challenge method")
+	}
+
+	code, state, err := server.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorization failed: %v", err)
+	}
+	if state != "state-1" {
+		t.Errorf("Expected state to round-trip, got %q", state)
+	}
+
+	token, err := provider.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, token.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("ID token verification failed: %v", err)
+	}
+	if claims.String("sub") != "alice-123" || claims.String("email") != "alice@example.com" {
+		t.Errorf("Unexpected claims %v", claims)
+	}
+	if !claims.Bool("email_verified") {
+		t.Error("email_verified should be true")
+	}
+	if groups := claims.Strings("groups"); len(groups) != 2 || groups[0] != "erp-admins" {
+		t.Errorf("Unexpected groups %v", groups)
+	}
+
+	if _, err := provider.Exchange(ctx, code, verifier); err == nil {
+		t.Error("An authorization code should only be redeemable once")
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	server, provider := newProvider(t)
+	ctx := context.Background()
+
+	verifier, _ := oidc.GenerateCodeVerifier()
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", oidc.CodeChallenge(verifier))
+	if err != nil {
+		t.Fatalf("Failed to build auth URL: %v", err)
+	}
+	code, _, err := server.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorization failed: %v", err)
+	}
+
+	other, _ := oidc.GenerateCodeVerifier()
+	if _, err := provider.Exchange(ctx, code, other); err == nil {
+		t.Error("Exchange should fail with a different code verifier")
+	}
+}
+
+func TestVerifyIDTokenRejectsWrongNonceAndAudience(t *testing.T) {
+	server, provider := newProvider(t)
+	ctx := context.Background()
+
+	verifier, _ := oidc.GenerateCodeVerifier()
+	authURL, _ := provider.AuthCodeURL(ctx, "state", "nonce", oidc.CodeChallenge(verifier))
+	code, _, err := server.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorization failed: %v", err)
+	}
+	token, err := provider.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, token.IDToken, "other-nonce"); err == nil {
+		t.Error("Verification should fail for a different nonce")
+	}
+
+	cfg := server.Config(redirectURL)
+	cfg.ClientID = "someone-else"
+	if _, err := oidc.NewProvider(cfg).VerifyIDToken(ctx, token.IDToken, "nonce"); err == nil {
+		t.Error("Verification should fail for another client")
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	server, _ := newProvider(t)
+
+	cfg := server.Config(redirectURL)
+	cfg.IssuerURL = server.URL + "/"
+	if _, err := oidc.NewProvider(cfg).Discover(context.Background()); err == nil {
+		t.Error("Discovery should reject a document for another issuer")
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	verifier, err := oidc.GenerateCodeVerifier()
+	if err != nil {
+		t.Fatalf("Failed to generate verifier: %v", err)
+	}
+	if len(verifier) < 43 {
+		t.Errorf("A code verifier must be at least 43 characters, got %d", len(verifier))
+	}
+
+	challenge := oidc.CodeChallenge(verifier)
+	if challenge != oidc.CodeChallenge(verifier) {
+		t.Error("CodeChallenge should be deterministic")
+	}
+	// Unpadded base64url of a SHA-256 digest.
+	if len(challenge) != 43 {
+		t.Errorf("Expected a 43 character challenge, got %q", challenge)
+	}
+	if challenge == verifier {
+		t.Error("The challenge must not reveal the verifier")
+	}
+}
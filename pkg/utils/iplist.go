@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseIPAllowList parses IP addresses and CIDR ranges. A bare address is
+// treated as a single-host range.
+func ParseIPAllowList(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR range %q", entry)
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", entry)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+// IPAllowed reports whether ip matches an entry of the allow-list. An empty
+// list allows every address; an unparsable list or address allows none.
+func IPAllowed(ip string, entries []string) bool {
+	if len(entries) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	nets, err := ParseIPAllowList(entries)
+	if err != nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
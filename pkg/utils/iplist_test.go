@@ -0,0 +1,41 @@
+package utils
+
+import "testing"
+
+func TestParseIPAllowList(t *testing.T) {
+	if _, err := ParseIPAllowList([]string{"10.0.0.1", "192.168.0.0/16", "2001:db8::/32", "::1"}); err != nil {
+		t.Errorf("Valid entries should parse: %v", err)
+	}
+
+	for _, entry := range []string{"10.0.0", "10.0.0.0/33", "printer"} {
+		if _, err := ParseIPAllowList([]string{entry}); err == nil {
+			t.Errorf("Entry %q should be rejected", entry)
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	list := []string{"203.0.113.7", "10.1.0.0/16", "2001:db8::/32"}
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"10.1.200.3", true},
+		{"10.2.0.1", false},
+		{"2001:db8::42", true},
+		{"2001:db9::1", false},
+		{"not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if got := IPAllowed(tt.ip, list); got != tt.allowed {
+			t.Errorf("IPAllowed(%q) = %v, want %v", tt.ip, got, tt.allowed)
+		}
+	}
+
+	if !IPAllowed("198.51.100.1", nil) {
+		t.Error("An empty allow-list should allow every address")
+	}
+}
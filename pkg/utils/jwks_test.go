@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAsymmetricSigning(t *testing.T) {
+	defer InitJWT("test-secret-key")
+
+	for _, alg := range []string{"RS256", "EdDSA"} {
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			t.Fatalf("Failed to generate %s key: %v", alg, err)
+		}
+
+		if err := InitJWTKeys(key.KID, []SigningKey{key}); err != nil {
+			t.Fatalf("Failed to init %s keys: %v", alg, err)
+		}
+
+		token, err := GenerateToken(7, 3)
+		if err != nil {
+			t.Fatalf("Failed to generate %s token: %v", alg, err)
+		}
+
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+		if err != nil {
+			t.Fatalf("Failed to parse %s token: %v", alg, err)
+		}
+		if parsed.Method.Alg() != alg {
+			t.Errorf("Expected alg %s, got %s", alg, parsed.Method.Alg())
+		}
+		if parsed.Header["kid"] != key.KID {
+			t.Errorf("Expected kid %s, got %v", key.KID, parsed.Header["kid"])
+		}
+
+		claims, err := ValidateToken(token)
+		if err != nil {
+			t.Fatalf("Failed to validate %s token: %v", alg, err)
+		}
+		if claims.UserID != 7 || claims.RoleID != 3 {
+			t.Errorf("Unexpected claims %+v", claims)
+		}
+	}
+}
+
+func TestKeyRotationKeepsOldTokensValid(t *testing.T) {
+	defer InitJWT("test-secret-key")
+
+	oldKey, err := GenerateSigningKey("RS256")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	newKey, err := GenerateSigningKey("EdDSA")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	if err := InitJWTKeys(oldKey.KID, []SigningKey{oldKey}); err != nil {
+		t.Fatalf("Failed to init keys: %v", err)
+	}
+	oldToken, err := GenerateToken(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	// Rotate: the new key signs, the old one is kept for verification only.
+	retired := SigningKey{KID: oldKey.KID, Method: oldKey.Method, Public: oldKey.Public}
+	if err := InitJWTKeys(newKey.KID, []SigningKey{newKey, retired}); err != nil {
+		t.Fatalf("Failed to rotate keys: %v", err)
+	}
+
+	if _, err := ValidateToken(oldToken); err != nil {
+		t.Errorf("Token signed with the retired key should still validate: %v", err)
+	}
+
+	newToken, err := GenerateToken(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if _, err := ValidateToken(newToken); err != nil {
+		t.Errorf("Token signed with the new key should validate: %v", err)
+	}
+
+	// Dropping the old key entirely invalidates its tokens.
+	if err := InitJWTKeys(newKey.KID, []SigningKey{newKey}); err != nil {
+		t.Fatalf("Failed to init keys: %v", err)
+	}
+	if _, err := ValidateToken(oldToken); err == nil {
+		t.Error("Token signed with an unknown key should fail")
+	}
+}
+
+func TestInitJWTKeysRejectsVerifyOnlyActiveKey(t *testing.T) {
+	defer InitJWT("test-secret-key")
+
+	key, err := GenerateSigningKey("RS256")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	verifyOnly := SigningKey{KID: key.KID, Method: key.Method, Public: key.Public}
+
+	if err := InitJWTKeys(key.KID, []SigningKey{verifyOnly}); err == nil {
+		t.Error("InitJWTKeys should fail when the active key cannot sign")
+	}
+	if err := InitJWTKeys("missing", []SigningKey{key}); err == nil {
+		t.Error("InitJWTKeys should fail when the active key is not in the set")
+	}
+}
+
+func TestHS256TokenRejectedInAsymmetricMode(t *testing.T) {
+	defer InitJWT("test-secret-key")
+
+	InitJWT("test-secret-key")
+	hsToken, err := GenerateToken(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	key, err := GenerateSigningKey("RS256")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if err := InitJWTKeys(key.KID, []SigningKey{key}); err != nil {
+		t.Fatalf("Failed to init keys: %v", err)
+	}
+
+	if _, err := ValidateToken(hsToken); err == nil {
+		t.Error("HS256 tokens should be rejected once asymmetric keys are configured")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	defer InitJWT("test-secret-key")
+
+	InitJWT("test-secret-key")
+	if len(JWKS().Keys) != 0 {
+		t.Error("JWKS should be empty in HS256 mode")
+	}
+
+	rsaKey, err := GenerateSigningKey("RS256")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	edKey, err := GenerateSigningKey("EdDSA")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if err := InitJWTKeys(rsaKey.KID, []SigningKey{rsaKey, edKey}); err != nil {
+		t.Fatalf("Failed to init keys: %v", err)
+	}
+
+	keys := map[string]JSONWebKey{}
+	for _, jwk := range JWKS().Keys {
+		keys[jwk.Kid] = jwk
+	}
+
+	if jwk := keys[rsaKey.KID]; jwk.Kty != "RSA" || jwk.Alg != "RS256" || jwk.N == "" || jwk.E != "AQAB" {
+		t.Errorf("Unexpected RSA JWK %+v", jwk)
+	}
+	if jwk := keys[edKey.KID]; jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Alg != "EdDSA" || jwk.X == "" {
+		t.Errorf("Unexpected Ed25519 JWK %+v", jwk)
+	}
+}
+
+func TestParseSigningKeyPEM(t *testing.T) {
+	key, err := GenerateSigningKey("EdDSA")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	parsed, err := ParseSigningKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %v", err)
+	}
+	if parsed.KID != key.KID || parsed.Private == nil {
+		t.Error("Parsed private key should keep its kid and be able to sign")
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(key.Public)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	parsed, err = ParseSigningKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+	if parsed.KID != key.KID || parsed.Private != nil {
+		t.Error("Parsed public key should keep its kid and be verify-only")
+	}
+
+	if _, err := ParseSigningKeyPEM([]byte("not a key")); err == nil {
+		t.Error("ParseSigningKeyPEM should fail without a PEM block")
+	}
+}
@@ -1,7 +1,12 @@
 package utils
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -9,42 +14,228 @@ import (
 
 var jwtSecret []byte
 
+// signingKeys holds the asymmetric keys tokens are verified with, indexed by
+// kid. When it is empty tokens are signed and verified with jwtSecret.
+var signingKeys map[string]SigningKey
+
+// activeKey is the key new tokens are signed with in asymmetric mode.
+var activeKey *SigningKey
+
+// accessTokenTTL is the lifetime of tokens issued by GenerateToken.
+var accessTokenTTL = 15 * time.Minute
+
+// revocationCheck reports whether a token ID has been revoked. It is nil
+// until SetRevocationCheck is called.
+var revocationCheck func(jti string) (bool, error)
+
+// ErrTokenRevoked is returned by ValidateToken for tokens whose ID has been
+// revoked.
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// InitJWT configures HS256 signing with a shared secret and discards any
+// asymmetric keys.
 func InitJWT(secret string) {
 	jwtSecret = []byte(secret)
+	signingKeys = nil
+	activeKey = nil
+}
+
+// InitJWTKeys configures asymmetric signing. New tokens are signed with the
+// key identified by activeKID; every key in keys is accepted for
+// verification, so retired keys can stay listed until the tokens they signed
+// have expired.
+func InitJWTKeys(activeKID string, keys []SigningKey) error {
+	byKID := make(map[string]SigningKey, len(keys))
+	for _, key := range keys {
+		if _, exists := byKID[key.KID]; exists {
+			return fmt.Errorf("duplicate signing key id %q", key.KID)
+		}
+		byKID[key.KID] = key
+	}
+
+	active, ok := byKID[activeKID]
+	if !ok {
+		return fmt.Errorf("active signing key %q not found", activeKID)
+	}
+	if active.Private == nil {
+		return fmt.Errorf("active signing key %q has no private key", activeKID)
+	}
+
+	jwtSecret = nil
+	signingKeys = byKID
+	activeKey = &active
+	return nil
+}
+
+// SetAccessTokenTTL changes the lifetime of tokens issued by GenerateToken.
+func SetAccessTokenTTL(ttl time.Duration) {
+	accessTokenTTL = ttl
+}
+
+// AccessTokenTTL returns the lifetime of tokens issued by GenerateToken.
+func AccessTokenTTL() time.Duration {
+	return accessTokenTTL
+}
+
+// SetRevocationCheck installs the function ValidateToken uses to reject
+// revoked token IDs.
+func SetRevocationCheck(check func(jti string) (bool, error)) {
+	revocationCheck = check
 }
 
+// Token purposes other than normal API access. Tokens with a purpose are
+// rejected by AuthMiddleware.
+const (
+	// PurposeMFA marks a token proving the password step of a login whose
+	// second factor is still pending.
+	PurposeMFA = "mfa"
+	// PurposeMFAEnroll marks a token allowing a user whose role requires
+	// two-factor authentication to enroll before logging in.
+	PurposeMFAEnroll = "mfa_enroll"
+)
+
 type Claims struct {
-	UserID uint `json:"user_id"`
-	RoleID uint `json:"role_id"`
+	UserID  uint   `json:"user_id"`
+	RoleID  uint   `json:"role_id"`
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func GenerateToken(userID, roleID uint) (string, error) {
+	jti, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
 	claims := &Claims{
 		UserID: userID,
 		RoleID: roleID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
+	return signClaims(claims)
+}
+
+// GeneratePurposeToken issues a short-lived token restricted to purpose.
+func GeneratePurposeToken(userID, roleID uint, purpose string, ttl time.Duration) (string, error) {
+	jti, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := &Claims{
+		UserID:  userID,
+		RoleID:  roleID,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return signClaims(claims)
+}
+
+func signClaims(claims jwt.Claims) (string, error) {
+	if activeKey != nil {
+		token := jwt.NewWithClaims(activeKey.Method, claims)
+		token.Header["kid"] = activeKey.KID
+		return token.SignedString(activeKey.Private)
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(jwtSecret)
 }
 
 func ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, verificationKey)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if revocationCheck != nil && claims.ID != "" {
+		revoked, err := revocationCheck(claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+
+	return claims, nil
+}
+
+// verificationKey picks the key a token must be verified with. The algorithm
+// is taken from our own key, never from the token, so an RS256 public key
+// can never be used as an HS256 secret.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if signingKeys == nil {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return jwtSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := signingKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// SigningKey is an asymmetric key used to sign or verify tokens.
+type SigningKey struct {
+	KID     string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// NewSigningKey wraps an RSA or Ed25519 key. priv may be nil for keys that
+// are only used to verify tokens. The key ID is the RFC 7638 thumbprint of
+// the public key.
+func NewSigningKey(priv crypto.Signer, pub crypto.PublicKey) (SigningKey, error) {
+	if priv != nil {
+		pub = priv.Public()
+	}
+
+	var method jwt.SigningMethod
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	case *ecdsa.PublicKey:
+		return SigningKey{}, errors.New("ECDSA keys are not supported")
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	kid, err := thumbprint(pub)
+	if err != nil {
+		return SigningKey{}, err
 	}
 
-	return nil, errors.New("invalid token")
+	return SigningKey{
+		KID:     kid,
+		Method:  method,
+		Private: priv,
+		Public:  pub,
+	}, nil
 }
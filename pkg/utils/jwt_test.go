@@ -36,12 +36,16 @@ func TestGenerateAndValidateToken(t *testing.T) {
 		t.Errorf("Expected RoleID %d, got %d", roleID, claims.RoleID)
 	}
 
+	if claims.ID == "" {
+		t.Error("Token should carry a jti")
+	}
+
 	// Check expiration time
 	if claims.ExpiresAt == nil {
 		t.Error("Token should have an expiration time")
 	} else {
 		expiresAt := claims.ExpiresAt.Time
-		expectedExpiration := time.Now().Add(24 * time.Hour)
+		expectedExpiration := time.Now().Add(AccessTokenTTL())
 		diff := expiresAt.Sub(expectedExpiration)
 		if diff < -time.Minute || diff > time.Minute {
 			t.Errorf("Token expiration time is not as expected")
@@ -80,3 +84,85 @@ func TestValidateTokenWithDifferentSecret(t *testing.T) {
 		t.Error("ValidateToken should fail when secret is different")
 	}
 }
+
+func TestAccessTokenTTL(t *testing.T) {
+	InitJWT("test-secret-key")
+	defer SetAccessTokenTTL(AccessTokenTTL())
+
+	SetAccessTokenTTL(5 * time.Minute)
+	token, err := GenerateToken(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+
+	diff := claims.ExpiresAt.Time.Sub(time.Now().Add(5 * time.Minute))
+	if diff < -time.Minute || diff > time.Minute {
+		t.Errorf("Token expiration should follow the configured TTL")
+	}
+}
+
+func TestValidateRevokedToken(t *testing.T) {
+	InitJWT("test-secret-key")
+	defer SetRevocationCheck(nil)
+
+	token, err := GenerateToken(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+
+	revoked := map[string]bool{claims.ID: true}
+	SetRevocationCheck(func(jti string) (bool, error) {
+		return revoked[jti], nil
+	})
+
+	if _, err := ValidateToken(token); err != ErrTokenRevoked {
+		t.Errorf("Expected ErrTokenRevoked, got %v", err)
+	}
+
+	other, err := GenerateToken(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if _, err := ValidateToken(other); err != nil {
+		t.Errorf("Tokens with other IDs should still validate: %v", err)
+	}
+}
+
+func TestGeneratePurposeToken(t *testing.T) {
+	InitJWT("test-secret-key")
+
+	token, err := GeneratePurposeToken(4, 2, PurposeMFA, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+	if claims.Purpose != PurposeMFA || claims.UserID != 4 {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	access, err := GenerateToken(4, 2)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err = ValidateToken(access)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+	if claims.Purpose != "" {
+		t.Error("Access tokens should not carry a purpose")
+	}
+}
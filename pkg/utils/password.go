@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// commonPasswords are rejected by every PasswordPolicy. The list covers the
+// most frequent entries of public breach corpora; deployments can extend it
+// with LoadBannedPasswords.
+var commonPasswords = []string{
+	"123456", "123456789", "12345678", "1234567890", "12345", "1234567",
+	"password", "password1", "password123", "passw0rd", "qwerty", "qwerty123",
+	"qwertyuiop", "abc123", "111111", "000000", "123123", "654321", "iloveyou",
+	"admin", "admin123", "administrator", "welcome", "welcome1", "letmein",
+	"monkey", "dragon", "football", "baseball", "sunshine", "princess",
+	"master", "shadow", "superman", "trustno1", "changeme", "secret",
+	"1q2w3e4r", "1qaz2wsx", "zaq12wsx", "asdfghjkl", "p@ssw0rd", "p@ssword",
+}
+
+// ErrPasswordTooShort and ErrPasswordBanned are returned by
+// PasswordPolicy.Validate.
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordBanned   = errors.New("password is too common")
+)
+
+// PasswordPolicy describes which passwords users may choose.
+type PasswordPolicy struct {
+	MinLength int
+	banned    map[string]struct{}
+}
+
+// NewPasswordPolicy returns a policy requiring minLength characters and
+// rejecting common passwords plus any in extraBanned.
+func NewPasswordPolicy(minLength int, extraBanned []string) *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength: minLength,
+		banned:    make(map[string]struct{}, len(commonPasswords)+len(extraBanned)),
+	}
+	for _, list := range [][]string{commonPasswords, extraBanned} {
+		for _, password := range list {
+			policy.banned[strings.ToLower(password)] = struct{}{}
+		}
+	}
+	return policy
+}
+
+// Validate checks a password against the policy. The username is banned as
+// well, since it is the first thing an attacker tries.
+func (p *PasswordPolicy) Validate(password, username string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: at least %d characters required", ErrPasswordTooShort, p.MinLength)
+	}
+
+	lowered := strings.ToLower(password)
+	if _, banned := p.banned[lowered]; banned {
+		return ErrPasswordBanned
+	}
+	if username != "" && lowered == strings.ToLower(username) {
+		return ErrPasswordBanned
+	}
+	return nil
+}
+
+// LoadBannedPasswords reads one password per line from path, skipping blank
+// lines and lines starting with '#'.
+func LoadBannedPasswords(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var passwords []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords = append(passwords, line)
+	}
+	return passwords, scanner.Err()
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := NewPasswordPolicy(10, []string{"AcmeCorp2024"})
+
+	tests := []struct {
+		password string
+		username string
+		wantErr  error
+	}{
+		{"short", "", ErrPasswordTooShort},
+		{"correct horse battery", "", nil},
+		{"Password123", "", ErrPasswordBanned},
+		{"acmecorp2024", "", ErrPasswordBanned},
+		{"jane.doe.smith", "Jane.Doe.Smith", ErrPasswordBanned},
+		{"jane.doe.smith", "someone", nil},
+	}
+
+	for _, tt := range tests {
+		err := policy.Validate(tt.password, tt.username)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Validate(%q, %q) = %v, want %v", tt.password, tt.username, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadBannedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned.txt")
+	content := "# company specific\nerp-golang\n\n  summer2024  \n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	passwords, err := LoadBannedPasswords(path)
+	if err != nil {
+		t.Fatalf("Failed to load banned passwords: %v", err)
+	}
+	if len(passwords) != 2 || passwords[0] != "erp-golang" || passwords[1] != "summer2024" {
+		t.Errorf("Unexpected passwords %v", passwords)
+	}
+}
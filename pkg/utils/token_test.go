@@ -0,0 +1,37 @@
+package utils
+
+import "testing"
+
+func TestGenerateRandomToken(t *testing.T) {
+	first, err := GenerateRandomToken(32)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	second, err := GenerateRandomToken(32)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	if first == second {
+		t.Error("Random tokens should not repeat")
+	}
+
+	if len(first) != 43 {
+		t.Errorf("Expected 43 characters for 32 bytes, got %d", len(first))
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	if HashToken("abc") != HashToken("abc") {
+		t.Error("HashToken should be deterministic")
+	}
+
+	if HashToken("abc") == HashToken("abd") {
+		t.Error("Different tokens should hash differently")
+	}
+
+	if HashToken("abc") == "abc" {
+		t.Error("HashToken should not return the token itself")
+	}
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed from RFC 6238 appendix B.
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// RFC 6238 lists 8-digit codes; the 6-digit code is their last 6 digits.
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	for unix, want := range vectors {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("Failed to compute code: %v", err)
+		}
+		if got != want[2:] {
+			t.Errorf("TOTPCode at %d = %s, want %s", unix, got, want[2:])
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatalf("Failed to compute code: %v", err)
+	}
+
+	step, ok := VerifyTOTP(secret, code, now, 0)
+	if !ok {
+		t.Fatal("Current code should verify")
+	}
+
+	if _, ok := VerifyTOTP(secret, code, now, step); ok {
+		t.Error("A code should not verify twice")
+	}
+
+	if _, ok := VerifyTOTP(secret, code, now.Add(30*time.Second), 0); !ok {
+		t.Error("The previous period's code should be accepted for clock drift")
+	}
+
+	if _, ok := VerifyTOTP(secret, code, now.Add(2*time.Minute), 0); ok {
+		t.Error("Old codes should be rejected")
+	}
+
+	if _, ok := VerifyTOTP(secret, "000000", now, 0); ok && code != "000000" {
+		t.Error("Wrong codes should be rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("ERP Golang", "admin", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/ERP%20Golang:admin?") {
+		t.Errorf("Unexpected URI prefix: %s", uri)
+	}
+	for _, part := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=ERP+Golang", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("URI should contain %q: %s", part, uri)
+		}
+	}
+}
@@ -31,8 +31,8 @@ echo "Database is ready!"
 echo ""
 echo "Creating .env file from .env.example..."
 if [ ! -f .env ]; then
-    cp .env.example .env
-    echo ".env file created successfully"
+    sed 's/^APP_ENV=.*/APP_ENV=development/' .env.example > .env
+    echo ".env file created successfully (APP_ENV=development)"
 else
     echo ".env file already exists"
 fi